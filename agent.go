@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// agentMaxJobs is how many jobs one scheduler connection runs at once
+const agentMaxJobs = 16
+
+type (
+	// agentJob is a probe job a scheduler dispatches to the agent, a probe
+	// request with the ID its messages are tagged with
+	agentJob struct {
+		ID string `json:"id"`
+		probeRequest
+	}
+
+	// agentMessage is what the agent streams back for a job: one message per
+	// result as soon as its probe completes, then a last one with Done set,
+	// carrying the summary of the job or the error that ended it
+	agentMessage struct {
+		ID      string         `json:"id"`
+		Result  *udping.Result `json:"result,omitempty"`
+		Done    bool           `json:"done,omitempty"`
+		Summary *udping.Stats  `json:"summary,omitempty"`
+		Error   string         `json:"error,omitempty"`
+	}
+)
+
+// serveAgent runs a long-lived agent on addr until the listener fails.
+// Schedulers connect over tcp and stream jobs, one JSON object per line:
+//
+//	{"id":"dns-1","destination":"192.0.2.53","port":53,"mode":"dns","count":3}
+//
+// The agent streams back agentMessages the same way, tagged with the ID of
+// their job. Jobs of a connection run concurrently, their messages
+// interleaved, and are cancelled as soon as the connection reaches its end:
+// a scheduler keeps it open until the last message of each of its jobs came
+// in, closing or shutting down its sending side cancels the jobs left. The
+// stream is JSON lines rather than gRPC, whose generated code and runtime
+// this module does not depend on; the message types are its schema.
+func serveAgent(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("agent listening on %v\n", ln.Addr())
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go handleAgent(c)
+	}
+}
+
+// handleAgent runs the jobs of one scheduler connection
+func handleAgent(c net.Conn) {
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(c)
+	send := func(m agentMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(m); err != nil {
+			// the scheduler is gone
+			cancel()
+		}
+	}
+
+	var wg sync.WaitGroup
+	jobs := make(chan struct{}, agentMaxJobs)
+	dec := json.NewDecoder(bufio.NewReader(c))
+	dec.DisallowUnknownFields()
+	for {
+		var job agentJob
+		if err := dec.Decode(&job); err != nil {
+			if !errors.Is(err, io.EOF) {
+				// a malformed job, unknown fields included
+				send(agentMessage{Done: true, Error: err.Error()})
+			}
+			// the scheduler hung up, or its stream cannot be read any further
+			cancel()
+			break
+		}
+		select {
+		case jobs <- struct{}{}:
+		default:
+			send(agentMessage{ID: job.ID, Done: true, Error: fmt.Sprintf("at most %d jobs run at once", agentMaxJobs)})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-jobs }()
+			send(runAgentJob(ctx, job, send))
+		}()
+	}
+	// the jobs left stop at their next probe, their last messages sent if the
+	// scheduler still reads
+	wg.Wait()
+}
+
+// runAgentJob runs the probes of job, sending each result as it completes,
+// and returns the last message of the job
+func runAgentJob(ctx context.Context, job agentJob, send func(agentMessage)) agentMessage {
+	r, err := newAPIRunner(job.probeRequest)
+	if err != nil {
+		return agentMessage{ID: job.ID, Done: true, Error: err.Error()}
+	}
+	r.OnResult = func(res udping.Result) {
+		send(agentMessage{ID: job.ID, Result: &res})
+	}
+	if err := r.RunContext(ctx); err != nil {
+		return agentMessage{ID: job.ID, Done: true, Error: err.Error()}
+	}
+	summary := r.Summary()
+	return agentMessage{ID: job.ID, Done: true, Summary: &summary}
+}
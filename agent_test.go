@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// dialAgent runs handleAgent on a loopback connection and returns the
+// scheduler's end of it and a channel closed once handleAgent returned
+func dialAgent(t *testing.T) (*net.TCPConn, <-chan struct{}) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if c, err := ln.Accept(); err == nil {
+			handleAgent(c)
+		}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	return c.(*net.TCPConn), done
+}
+
+// readMessages reads the messages of the agent until n jobs are done
+func readMessages(t *testing.T, sc *bufio.Scanner, n int) map[string][]agentMessage {
+	t.Helper()
+	msgs := map[string][]agentMessage{}
+	for done := 0; done < n && sc.Scan(); {
+		var m agentMessage
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("%v: %s", err, sc.Bytes())
+		}
+		msgs[m.ID] = append(msgs[m.ID], m)
+		if m.Done {
+			done++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestAgentJobs(t *testing.T) {
+	port := echoServer(t)
+	c, _ := dialAgent(t)
+	fmt.Fprintf(c, `{"id":"a","destination":"127.0.0.1","port":%d,"count":2,"interval":0.01,"timeout":1}`+"\n", port)
+	fmt.Fprintf(c, `{"id":"b","destination":"127.0.0.1","port":%d,"count":3,"interval":"10ms","timeout":"1s"}`+"\n", port)
+	msgs := readMessages(t, bufio.NewScanner(c), 2)
+	for id, count := range map[string]int{"a": 2, "b": 3} {
+		m := msgs[id]
+		if len(m) != count+1 {
+			t.Fatalf("job %s: messages %+v, want %d results and the last one", id, m, count)
+		}
+		for _, r := range m[:count] {
+			if r.Done || r.Result == nil || !r.Result.Success {
+				t.Errorf("job %s: message %+v, want a successful result", id, r)
+			}
+		}
+		if last := m[count]; !last.Done || last.Summary == nil || last.Summary.Received != count {
+			t.Errorf("job %s: last message %+v, want a summary of %d received", id, last, count)
+		}
+	}
+}
+
+func TestAgentHangUpCancels(t *testing.T) {
+	port := echoServer(t)
+	c, done := dialAgent(t)
+	// 100 probes a second apart would run for more than a minute
+	fmt.Fprintf(c, `{"id":"long","destination":"127.0.0.1","port":%d,"count":100,"interval":1,"timeout":1}`+"\n", port)
+	sc := bufio.NewScanner(c)
+	if !sc.Scan() {
+		t.Fatalf("no first result: %v", sc.Err())
+	}
+	start := time.Now()
+	c.CloseWrite()
+	msgs := readMessages(t, sc, 1)
+	last := msgs["long"][len(msgs["long"])-1]
+	if !last.Done || last.Summary == nil || last.Summary.Sent >= 100 {
+		t.Errorf("last message %+v, want the job cut short", last)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the agent kept the connection after the scheduler hung up")
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("the job took %v to stop", d)
+	}
+}
+
+func TestAgentMalformedJob(t *testing.T) {
+	for _, line := range []string{`{"id":}`, `{"id":"x","destination":"127.0.0.1","bogus":true}`} {
+		c, done := dialAgent(t)
+		fmt.Fprintln(c, line)
+		// the stream cannot be read past it, so it is the last message
+		msgs := readMessages(t, bufio.NewScanner(c), 1)
+		if m := msgs[""]; len(m) != 1 || m[0].Error == "" {
+			t.Errorf("%s: messages %+v, want an error", line, msgs)
+		}
+		<-done
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const (
+	// alertMinProbes is how many probes a window must hold before it is judged,
+	// so that the first lost probe of a run is not a 100% loss alert
+	alertMinProbes = 3
+	alertQueueSize = 64               // alerts waiting to be delivered, dropped beyond
+	alertTimeout   = 10 * time.Second // longest a command or webhook delivery may take
+)
+
+type (
+	// alertEvent is what an alert hook receives, as JSON on the standard input
+	// of -alert-exec and as the body of -alert-webhook requests
+	alertEvent struct {
+		Target string      `json:"target"`
+		State  string      `json:"state"` // State is firing when a threshold is crossed and resolved when the target is back under
+		At     time.Time   `json:"at"`
+		Reason string      `json:"reason,omitempty"`
+		Stats  windowStats `json:"stats"` // Stats are the target's aggregates over the alert window
+		// Thresholds of the alert, unset when not configured
+		MaxLoss float64 `json:"maxloss,omitempty"`
+		MaxRTT  float64 `json:"maxrtt,omitempty"` // MaxRTT is the average RTT threshold, in seconds
+	}
+
+	// alerter watches the loss and average RTT of every target over a rolling
+	// window and fires an alert when one crosses its threshold, and again when
+	// it is back under, smokeping style. Deliveries run in the background so
+	// that a slow hook never holds up probing.
+	alerter struct {
+		maxLoss float64 // percentage, negative to disable
+		maxRTT  time.Duration
+		window  time.Duration
+		command string
+		webhook string
+		client  *http.Client
+
+		mu      sync.Mutex
+		targets map[string]*alertTarget
+		queue   chan alertEvent
+		done    chan struct{}
+	}
+
+	// alertTarget is the window and alert state of one target
+	alertTarget struct {
+		history history
+		firing  bool
+	}
+)
+
+func newAlerter(maxLoss float64, maxRTT, window time.Duration, command, webhook string) *alerter {
+	a := &alerter{
+		maxLoss: maxLoss,
+		maxRTT:  maxRTT,
+		window:  window,
+		command: command,
+		webhook: webhook,
+		client:  &http.Client{Timeout: alertTimeout},
+		targets: map[string]*alertTarget{},
+		queue:   make(chan alertEvent, alertQueueSize),
+		done:    make(chan struct{}),
+	}
+	go a.loop()
+	return a
+}
+
+// Add records a result and queues an alert when its target crosses a threshold
+func (a *alerter) Add(res udping.Result) {
+	key := net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t := a.targets[key]
+	if t == nil {
+		t = &alertTarget{}
+		a.targets[key] = t
+	}
+	t.history.add(res, now)
+	ws := t.history.window(now, a.window)
+	ws.Window = a.window.String()
+	if ws.Sent < alertMinProbes {
+		return
+	}
+	reason := a.crossed(ws)
+	if (reason != "") == t.firing {
+		return
+	}
+	t.firing = reason != ""
+	ev := alertEvent{Target: key, State: "resolved", At: now, Reason: reason, Stats: ws}
+	if t.firing {
+		ev.State = "firing"
+	}
+	if a.maxLoss >= 0 {
+		ev.MaxLoss = a.maxLoss
+	}
+	ev.MaxRTT = a.maxRTT.Seconds()
+	select {
+	case a.queue <- ev:
+	default:
+		log.Printf("alert queue full, dropping the %s alert of %s\n", ev.State, key)
+	}
+}
+
+// crossed returns which thresholds the aggregates of a window are over, empty when none
+func (a *alerter) crossed(ws windowStats) string {
+	var over []string
+	if a.maxLoss >= 0 && ws.Loss > a.maxLoss {
+		over = append(over, fmt.Sprintf("loss %.1f%% over %.1f%%", ws.Loss, a.maxLoss))
+	}
+	if a.maxRTT > 0 && ws.AvgRTT > a.maxRTT.Seconds() {
+		rtt := time.Duration(ws.AvgRTT * float64(time.Second)).Round(time.Microsecond)
+		over = append(over, fmt.Sprintf("average rtt %v over %v", rtt, a.maxRTT))
+	}
+	return strings.Join(over, ", ")
+}
+
+// loop delivers the queued alerts until Close
+func (a *alerter) loop() {
+	defer close(a.done)
+	for ev := range a.queue {
+		body, _ := json.Marshal(ev)
+		if a.command != "" {
+			if err := a.exec(ev, body); err != nil {
+				log.Printf("alert command: %v\n", err)
+			}
+		}
+		if a.webhook != "" {
+			if err := a.post(body); err != nil {
+				log.Printf("alert webhook: %v\n", err)
+			}
+		}
+	}
+}
+
+// exec runs the alert command through the shell, the event as JSON on its
+// standard input and its target and state in UDPING_ALERT_TARGET and
+// UDPING_ALERT_STATE
+func (a *alerter) exec(ev alertEvent, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", a.command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(), "UDPING_ALERT_TARGET="+ev.Target, "UDPING_ALERT_STATE="+ev.State)
+	return cmd.Run()
+}
+
+// post sends the event to the webhook
+func (a *alerter) post(body []byte) error {
+	resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close delivers the alerts still queued and stops the alerter
+func (a *alerter) Close() {
+	close(a.queue)
+	<-a.done
+}
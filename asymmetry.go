@@ -0,0 +1,60 @@
+package main
+
+import "github.com/nguyendhst/udping/pkg/udping"
+
+type (
+	// asymmetryReport compares the average forward and reverse delays of an echo run
+	asymmetryReport struct {
+		Forward    float64 `json:"forward"`    // Forward is the average client to server delay in seconds
+		Reverse    float64 `json:"reverse"`    // Reverse is the average server to client delay in seconds
+		Ratio      float64 `json:"ratio"`      // Ratio is the larger average delay divided by the smaller one
+		Threshold  float64 `json:"threshold"`  // Threshold is the ratio above which the path is reported as asymmetric
+		Samples    int     `json:"samples"`    // Samples is the number of echo replies considered
+		Asymmetric bool    `json:"asymmetric"` // Asymmetric is true when Ratio exceeds Threshold
+		Note       string  `json:"note,omitempty"`
+	}
+
+	// asymmetryTracker accumulates one-way delays as results arrive
+	asymmetryTracker struct {
+		ratio   float64
+		forward float64
+		reverse float64
+		samples int
+	}
+)
+
+func newAsymmetryTracker(ratio float64) *asymmetryTracker {
+	return &asymmetryTracker{ratio: ratio}
+}
+
+// Add records the one-way delays of a result, if it has any
+func (t *asymmetryTracker) Add(res udping.Result) {
+	if res.ForwardDelay == 0 && res.ReverseDelay == 0 {
+		return
+	}
+	t.forward += res.ForwardDelay
+	t.reverse += res.ReverseDelay
+	t.samples++
+}
+
+// Report returns the asymmetry verdict over the results added so far.
+// Clock offset between client and server shows up as a non-positive delay,
+// in which case no ratio can be computed.
+func (t *asymmetryTracker) Report() asymmetryReport {
+	rep := asymmetryReport{Threshold: t.ratio, Samples: t.samples}
+	if t.samples == 0 {
+		return rep
+	}
+	rep.Forward = t.forward / float64(t.samples)
+	rep.Reverse = t.reverse / float64(t.samples)
+	if rep.Forward <= 0 || rep.Reverse <= 0 {
+		rep.Note = "client and server clocks are not synchronized, one-way delays are unreliable"
+		return rep
+	}
+	rep.Ratio = rep.Forward / rep.Reverse
+	if rep.Ratio < 1 {
+		rep.Ratio = 1 / rep.Ratio
+	}
+	rep.Asymmetric = rep.Ratio > t.ratio
+	return rep
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// budgetReport shows how much of the -byte-budget a run used
+type budgetReport struct {
+	Budget    int64 `json:"budget"`    // Budget is the maximum number of payload bytes allowed
+	Sent      int64 `json:"sent"`      // Sent is the number of payload bytes actually sent
+	Exhausted bool  `json:"exhausted"` // Exhausted is true when probing stopped early because of the budget
+
+	shared *udping.Budget // shared is drawn from by every run, those in parallel too
+}
+
+// byteUnits maps size suffixes to their multiplier. KB, MB and GB are powers of 1000; KiB, MiB and GiB of 1024.
+var byteUnits = []struct {
+	suffix string
+	mult   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "1500", "64KB" or "1MiB"
+func parseByteSize(s string) (int64, error) {
+	num, mult := strings.TrimSpace(s), int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(num), strings.ToUpper(u.suffix)) {
+			num, mult = strings.TrimSpace(num[:len(num)-len(u.suffix)]), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
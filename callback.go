@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const (
+	callbackBufferSize = 1024 // max results held in memory waiting to be posted
+	callbackRetries    = 3    // attempts per batch before it is dropped
+	callbackBackoff    = 500 * time.Millisecond
+	callbackFlushEvery = 1 * time.Second // partial batches are posted at least this often
+	callbackTimeout    = 5 * time.Second // per-request HTTP timeout
+	// callbackDrainTimeout bounds how long Close keeps posting the results
+	// still queued, so that an unreachable collector cannot hold up exiting
+	callbackDrainTimeout = 10 * time.Second
+)
+
+type (
+	// callbackRecord is a single result as posted to the callback URL
+	callbackRecord struct {
+		RunID  string        `json:"run_id"` // RunID identifies the udping process run the result belongs to
+		Seq    int           `json:"seq"`    // Seq is the position of the result in the run, starting at 0
+		Result udping.Result `json:"result"`
+	}
+
+	// callbackPoster streams results to a remote collector in the background.
+	// Results are queued in a bounded buffer so that a slow or unreachable
+	// collector never blocks probing; when the buffer is full results are dropped.
+	callbackPoster struct {
+		url     string
+		name    string // name of the poster in its log messages
+		runID   string
+		batch   int
+		client  *http.Client
+		queue   chan callbackRecord
+		seq     int
+		dropped int
+		mu      sync.Mutex
+		done    chan struct{}
+		// ctx is the context of every request, batches started before Close
+		// included, cancelled once Close has waited callbackDrainTimeout
+		ctx    context.Context
+		cancel context.CancelFunc
+		// encode returns the body of the request posting a batch
+		encode func(batch []callbackRecord) ([]byte, error)
+	}
+)
+
+// newCallbackPoster starts a poster that sends results to url in batches of at most batch records
+func newCallbackPoster(url string, batch int) *callbackPoster {
+	return newPoster(url, "results callback", batch, func(batch []callbackRecord) ([]byte, error) { return json.Marshal(batch) })
+}
+
+// newPoster starts a poster that sends results to url in batches of at most
+// batch records, encoded by encode
+func newPoster(url, name string, batch int, encode func([]callbackRecord) ([]byte, error)) *callbackPoster {
+	if batch < 1 {
+		batch = 1
+	}
+	p := &callbackPoster{
+		url:    url,
+		name:   name,
+		runID:  newRunID(),
+		batch:  batch,
+		client: &http.Client{Timeout: callbackTimeout},
+		queue:  make(chan callbackRecord, callbackBufferSize),
+		done:   make(chan struct{}),
+		encode: encode,
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go p.loop()
+	return p
+}
+
+// newRunID returns a random identifier for this process run
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Send queues a result for posting. It never blocks: if the buffer is full the result is dropped.
+func (p *callbackPoster) Send(res udping.Result) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec := callbackRecord{RunID: p.runID, Seq: p.seq, Result: res}
+	p.seq++
+	select {
+	case p.queue <- rec:
+	default:
+		p.dropped++
+	}
+}
+
+// Close flushes the queued results and waits for the poster to finish, for
+// at most callbackDrainTimeout: the results not posted by then are dropped
+func (p *callbackPoster) Close() {
+	stop := time.AfterFunc(callbackDrainTimeout, p.cancel)
+	defer stop.Stop()
+	defer p.cancel()
+	close(p.queue)
+	<-p.done
+	if p.dropped > 0 {
+		log.Printf("%s: dropped %d results\n", p.name, p.dropped)
+	}
+}
+
+func (p *callbackPoster) loop() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(callbackFlushEvery)
+	defer ticker.Stop()
+
+	pending := make([]callbackRecord, 0, p.batch)
+	for {
+		select {
+		case rec, ok := <-p.queue:
+			if !ok {
+				if len(pending) > 0 {
+					p.post(pending)
+				}
+				return
+			}
+			pending = append(pending, rec)
+			if len(pending) >= p.batch {
+				p.post(pending)
+				pending = pending[:0]
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				p.post(pending)
+				pending = pending[:0]
+			}
+		}
+	}
+}
+
+// post sends a batch to the collector, retrying transport errors and 5xx
+// responses with a linear backoff. A batch that still fails after
+// callbackRetries attempts, that the collector rejects with another status,
+// or that is left once Close stops waiting, is dropped.
+func (p *callbackPoster) post(batch []callbackRecord) {
+	body, err := p.encode(batch)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for attempt := 1; attempt <= callbackRetries; attempt++ {
+		// p.ctx is also the one of the request in flight when Close stops
+		// waiting, which cancels it
+		retry, err := p.send(p.ctx, body)
+		if err == nil {
+			return
+		}
+		if p.ctx.Err() != nil {
+			// Close is done waiting, its count of dropped results covers these
+			p.mu.Lock()
+			p.dropped += len(batch)
+			p.mu.Unlock()
+			return
+		}
+		if !retry || attempt == callbackRetries {
+			p.drop(batch, err)
+			return
+		}
+		select {
+		case <-p.ctx.Done():
+		case <-time.After(time.Duration(attempt) * callbackBackoff):
+		}
+	}
+}
+
+// send posts body once, returning whether a failure is worth retrying:
+// transport errors and server errors are, other statuses are not
+func (p *callbackPoster) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode >= 500, fmt.Errorf("collector returned %s", resp.Status)
+}
+
+// drop gives up on the results of batch, counting them as dropped
+func (p *callbackPoster) drop(batch []callbackRecord, err error) {
+	log.Printf("%s: giving up on %d results: %v\n", p.name, len(batch), err)
+	p.mu.Lock()
+	p.dropped += len(batch)
+	p.mu.Unlock()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+func TestCallbackDrainCancelsRequestInFlight(t *testing.T) {
+	arrived, release := make(chan struct{}, 1), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		// a collector that never answers
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	p := newCallbackPoster(srv.URL, 1)
+	p.Send(udping.Result{Success: true})
+	// the batch is posted before Close is called
+	<-arrived
+	closed := make(chan struct{})
+	go func() {
+		p.Close()
+		close(closed)
+	}()
+	// as the drain timer does once callbackDrainTimeout is over
+	p.cancel()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close waited for a request started before it")
+	}
+	if p.dropped != 1 {
+		t.Errorf("dropped %d results, want 1", p.dropped)
+	}
+}
+
+func TestCallbackRetries(t *testing.T) {
+	for _, tc := range []struct {
+		status   int
+		attempts int
+	}{
+		{http.StatusOK, 1},
+		{http.StatusServiceUnavailable, callbackRetries},
+		{http.StatusBadRequest, 1},
+	} {
+		attempts := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			w.WriteHeader(tc.status)
+		}))
+		log.SetOutput(io.Discard)
+		p := newCallbackPoster(srv.URL, 1)
+		p.Send(udping.Result{Success: true})
+		p.Close()
+		log.SetOutput(os.Stderr)
+		srv.Close()
+		if attempts != tc.attempts {
+			t.Errorf("status %d: %d attempts, want %d", tc.status, attempts, tc.attempts)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// changeFilter remembers the last outcome of every target and passes on
+// only the results that change it, for -changes-only. The first result of a
+// target passes as its initial state. With a state file the outcomes carry
+// over from one run to the next, so that a scheduled run only reports what
+// changed since the previous one.
+type changeFilter struct {
+	mu    sync.Mutex
+	path  string
+	state map[string]udping.Outcome
+}
+
+// newChangeFilter returns a filter starting from the states saved at path,
+// if any. A missing file starts with no known state.
+func newChangeFilter(path string) (*changeFilter, error) {
+	f := &changeFilter{path: path, state: map[string]udping.Outcome{}}
+	if path == "" {
+		return f, nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &f.state); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// changeKey is the target a result is remembered under, told apart by the
+// local address and interface it was probed from
+func changeKey(res udping.Result) string {
+	key := net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort))) + "/" + res.Protocol
+	if res.Source != "" {
+		key += " from " + res.Source
+	}
+	if res.Interface != "" {
+		key += " via " + res.Interface
+	}
+	return key
+}
+
+// changed records the outcome of res and reports whether it differs from
+// the last one known for its target
+func (f *changeFilter) changed(res udping.Result) bool {
+	key := changeKey(res)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if prev, ok := f.state[key]; ok && prev == res.Outcome {
+		return false
+	}
+	f.state[key] = res.Outcome
+	return true
+}
+
+// wrap returns hook called only with the results that changed their target's state
+func (f *changeFilter) wrap(hook func(res udping.Result)) func(res udping.Result) {
+	return func(res udping.Result) {
+		if f.changed(res) {
+			hook(res)
+		}
+	}
+}
+
+// save writes the known states to the state file, replacing it at once so
+// that an interrupted write leaves the previous states in place
+func (f *changeFilter) save() error {
+	if f.path == "" {
+		return nil
+	}
+	f.mu.Lock()
+	b, err := json.MarshalIndent(f.state, "", "  ")
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
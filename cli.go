@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// commands are the subcommands of udping, each parsing its own flags:
+//
+//	udping probe [flags] <host>:<port>...        (the default, also run without a subcommand)
+//	udping traceroute [flags] <host>:<port>...   (probe with -traceroute)
+//	udping compare [flags] <a>:<port> <b>:<port> (probe with -compare)
+//	udping serve [<addr>]                        (the HTTP API of -serve)
+//	udping echo [-plain] [<addr>]                (the echo server of -echo)
+//	udping agent [<addr>]                        (run probe jobs streamed by schedulers)
+//	udping report -store <file> [flags]          (summarize the results kept with -store)
+//	udping run [-input <file>]                   (run a parameters JSON document, printing its report)
+var commands = map[string]func(args []string) int{
+	"probe":      probeCommand,
+	"traceroute": tracerouteCommand,
+	"compare":    compareCommand,
+	"serve":      serveCommand,
+	"echo":       echoCommand,
+	"report":     reportCommand,
+	"agent":      agentCommand,
+	"run":        runCommand,
+}
+
+// parseInterspersed parses the flags of args into fs wherever they are,
+// before, between or after the positional arguments, and returns the
+// positional arguments. Everything after a "--" is positional.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		// Parse stops at the first positional argument, or after a "--"
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// parseCommand parses the flags of a subcommand other than probe, accepting
+// at most one positional argument, and returns it or the empty string
+func parseCommand(fs *flag.FlagSet, args []string) (string, int, bool) {
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", exitOK, false
+		}
+		return "", exitUsage, false
+	}
+	if len(positional) > 1 {
+		fs.Usage()
+		return "", exitUsage, false
+	}
+	if len(positional) == 0 {
+		return "", exitOK, true
+	}
+	return positional[0], exitOK, true
+}
+
+// tracerouteCommand runs "udping traceroute", a probe with -traceroute set
+func tracerouteCommand(args []string) int {
+	return probeCommand(append([]string{"-traceroute"}, args...))
+}
+
+// compareCommand runs "udping compare", a probe with -compare set
+func compareCommand(args []string) int {
+	return probeCommand(append([]string{"-compare"}, args...))
+}
+
+// serveCommand runs "udping serve", the HTTP API of -serve
+func serveCommand(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s serve [<addr>]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "run an HTTP API on addr, :8080 by default, answering POST /probe with the results of the probes it describes")
+		fs.PrintDefaults()
+	}
+	addr, code, ok := parseCommand(fs, args)
+	if !ok {
+		return code
+	}
+	if addr == "" {
+		addr = ":8080"
+	}
+	if err := serveAPI(addr); err != nil {
+		log.Println(err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// agentCommand runs "udping agent", the job stream of serveAgent
+func agentCommand(args []string) int {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s agent [<addr>]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "run an agent on addr, :8081 by default, taking probe jobs as JSON lines over tcp and streaming back their results")
+		fs.PrintDefaults()
+	}
+	addr, code, ok := parseCommand(fs, args)
+	if !ok {
+		return code
+	}
+	if addr == "" {
+		addr = ":8081"
+	}
+	if err := serveAgent(addr); err != nil {
+		log.Println(err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// echoCommand runs "udping echo", the echo server of -echo
+func echoCommand(args []string) int {
+	fs := flag.NewFlagSet("echo", flag.ContinueOnError)
+	plain := fs.Bool("plain", false, "return datagrams unchanged, without the receive timestamp echo mode needs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s echo [-plain] [<addr>]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "run an echo server on addr, :9000 by default")
+		fs.PrintDefaults()
+	}
+	addr, code, ok := parseCommand(fs, args)
+	if !ok {
+		return code
+	}
+	if addr == "" {
+		addr = ":9000"
+	}
+	serve := udping.ServeEcho
+	if *plain {
+		serve = udping.ServePlainEcho
+	}
+	if err := serve(addr); err != nil {
+		log.Println(err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+type (
+	// compareSide is how one of the two targets of -compare fared
+	compareSide struct {
+		Target   string  `json:"target"`
+		IP       string  `json:"ip,omitempty"`
+		Sent     int     `json:"sent"`
+		Received int     `json:"received"`
+		Loss     float64 `json:"loss"`             // Loss is the percentage of probes that did not succeed
+		AvgRTT   float64 `json:"avgrtt,omitempty"` // AvgRTT is the mean RTT in seconds
+		P50      float64 `json:"p50,omitempty"`    // P50 is the median RTT in seconds
+	}
+
+	// rttDelta is the distribution of the RTT of B minus the RTT of A, in
+	// seconds, over the probes both answered: negative values favour B
+	rttDelta struct {
+		Mean   float64 `json:"mean"`
+		StdDev float64 `json:"stddev"`
+		Min    float64 `json:"min"`
+		P10    float64 `json:"p10"`
+		P50    float64 `json:"p50"`
+		P90    float64 `json:"p90"`
+		Max    float64 `json:"max"`
+	}
+
+	// compareReport is the -compare verdict of B against A, A being the
+	// first target, the current endpoint, and B the candidate
+	compareReport struct {
+		A compareSide `json:"a"`
+		B compareSide `json:"b"`
+		// Pairs is the number of probes both targets answered, sent side by
+		// side, whose RTTs are compared
+		Pairs int       `json:"pairs"`
+		Delta *rttDelta `json:"delta,omitempty"`
+		// BFaster is the number of pairs B answered sooner than A
+		BFaster int `json:"bfaster"`
+		// LossDelta is the loss of B minus the loss of A, in percentage points
+		LossDelta float64 `json:"lossdelta"`
+		// Faster names the target with the lower mean RTT, "a" or "b", when
+		// the mean delta is more than twice its standard error away from
+		// zero; empty when the difference is within the noise
+		Faster string `json:"faster,omitempty"`
+	}
+)
+
+// newCompareReport compares the runs of the two targets of -compare, pairing
+// their probes in the order they were sent
+func newCompareReport(a, b targetResult) compareReport {
+	rep := compareReport{A: newCompareSide(a), B: newCompareSide(b)}
+	rep.LossDelta = rep.B.Loss - rep.A.Loss
+
+	var deltas []float64
+	for i := 0; i < len(a.rtts) && i < len(b.rtts); i++ {
+		if a.rtts[i] < 0 || b.rtts[i] < 0 {
+			continue
+		}
+		d := b.rtts[i] - a.rtts[i]
+		if d < 0 {
+			rep.BFaster++
+		}
+		deltas = append(deltas, d)
+	}
+	rep.Pairs = len(deltas)
+	if rep.Pairs == 0 {
+		return rep
+	}
+
+	var sum float64
+	for _, d := range deltas {
+		sum += d
+	}
+	delta := &rttDelta{Mean: sum / float64(len(deltas))}
+	var sq float64
+	for _, d := range deltas {
+		sq += (d - delta.Mean) * (d - delta.Mean)
+	}
+	delta.StdDev = math.Sqrt(sq / float64(len(deltas)))
+	sort.Float64s(deltas)
+	delta.Min, delta.Max = deltas[0], deltas[len(deltas)-1]
+	delta.P10, delta.P50, delta.P90 = quantile(deltas, 0.1), quantile(deltas, 0.5), quantile(deltas, 0.9)
+	rep.Delta = delta
+
+	if rep.Pairs > 1 {
+		stderr := delta.StdDev / math.Sqrt(float64(rep.Pairs-1))
+		switch {
+		case delta.Mean < -2*stderr:
+			rep.Faster = "b"
+		case delta.Mean > 2*stderr:
+			rep.Faster = "a"
+		}
+	}
+	return rep
+}
+
+// newCompareSide summarizes the run of one target of -compare
+func newCompareSide(tr targetResult) compareSide {
+	return compareSide{Target: tr.Target, IP: tr.IP, Sent: tr.Summary.Sent, Received: tr.Summary.Received,
+		Loss: tr.Summary.Loss, AvgRTT: tr.Summary.AvgRTT, P50: tr.Summary.P50}
+}
+
+// quantile returns the q-th quantile of the sorted values, interpolating
+// between the two closest ranks. values must not be empty.
+func quantile(sorted []float64, q float64) float64 {
+	rank := q * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
@@ -0,0 +1,87 @@
+package main
+
+// A config file describes the targets of repeated runs, so that scheduled
+// monitoring does not need an ever growing command line:
+//
+//	{
+//		"targets": [
+//			{"name": "resolver", "addr": "192.0.2.53:53", "mode": "dns", "count": 5, "timeout": "2s", "group": "dns-servers"},
+//			{"name": "time", "addr": "192.0.2.123:123", "mode": "ntp", "interval": 0.5, "deadline": "10s"},
+//			{"addr": "game.example.com:27015", "weight": 2}
+//		]
+//	}
+//
+// Durations are strings such as "500ms" or numbers of seconds. Fields left
+// out take the value of the matching flag.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type (
+	// config is the contents of a -config file
+	config struct {
+		Targets []configTarget `json:"targets"`
+	}
+
+	// configTarget is one target of a config file
+	configTarget struct {
+		Name     string          `json:"name"`
+		Addr     string          `json:"addr"`
+		Count    int             `json:"count"`
+		Interval secondsDuration `json:"interval"`
+		Timeout  secondsDuration `json:"timeout"`
+		Deadline secondsDuration `json:"deadline"`
+		Mode     string          `json:"mode"`
+		Weight   float64         `json:"weight"`
+		Group    string          `json:"group"`
+	}
+)
+
+// readConfig reads the targets of a config file. Unlike a targets file, any
+// invalid entry fails the whole file: a scheduled run should not quietly
+// probe less than it was configured to.
+func readConfig(path string) ([]target, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	targets := make([]target, 0, len(c.Targets))
+	for i, ct := range c.Targets {
+		name := ct.Name
+		if name == "" {
+			name = fmt.Sprintf("target %d", i+1)
+		}
+		if _, _, err := splitPorts(ct.Addr); err != nil {
+			return nil, fmt.Errorf("%s: %s: %v", path, name, err)
+		}
+		if ct.Count < 0 {
+			return nil, fmt.Errorf("%s: %s: count must not be negative, got %d", path, name, ct.Count)
+		}
+		if ct.Interval < 0 || ct.Timeout < 0 || ct.Deadline < 0 {
+			return nil, fmt.Errorf("%s: %s: durations must not be negative", path, name)
+		}
+		if ct.Weight < 0 {
+			return nil, fmt.Errorf("%s: %s: weight must not be negative, got %v", path, name, ct.Weight)
+		}
+		targets = append(targets, target{
+			Name:     ct.Name,
+			Addr:     ct.Addr,
+			Count:    ct.Count,
+			Interval: time.Duration(ct.Interval),
+			Timeout:  time.Duration(ct.Timeout),
+			Deadline: time.Duration(ct.Deadline),
+			Mode:     ct.Mode,
+			Weight:   ct.Weight,
+			Group:    ct.Group,
+		})
+	}
+	return targets, nil
+}
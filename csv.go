@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const formatCSV = "csv" // one comma separated row per probe, written as each probe completes
+
+// csvColumns is the header row of the csv format. The order is part of the
+// output format and only ever grows at the end:
+//
+//	destination  destination host as given
+//	port         destination port, 0 for icmp
+//	protocol     udp, tcp or icmp
+//	probe        index of the probe within its target, starting at 0
+//	success      true or false
+//	rtt_ms       round trip time in milliseconds, to the microsecond, empty without an answer
+//	error        error of a failed probe, empty otherwise
+//	state        open, closed, filtered or open|filtered, empty for icmp
+//	ip           address probed, one of those the destination resolves to
+//	sent_at      RFC 3339 time the probe was sent, to the nanosecond, empty when it never was
+//	outcome      answered, open|filtered, closed or error
+var csvColumns = []string{"destination", "port", "protocol", "probe", "success", "rtt_ms", "error", "state", "ip", "sent_at", "outcome"}
+
+// csvRow formats the i-th result of a target as a csv row in csvColumns order
+func csvRow(i int, res udping.Result) []string {
+	rtt := ""
+	if res.RTT > 0 {
+		rtt = strconv.FormatFloat(res.RTTMs, 'f', 3, 64)
+	}
+	sentAt := ""
+	if res.SentAt != nil {
+		sentAt = res.SentAt.Format(time.RFC3339Nano)
+	}
+	return []string{
+		res.Destination,
+		strconv.Itoa(int(res.DestinationPort)),
+		res.Protocol,
+		strconv.Itoa(i),
+		strconv.FormatBool(res.Success),
+		rtt,
+		res.Error,
+		res.State,
+		res.IP,
+		sentAt,
+		string(res.Outcome),
+	}
+}
+
+// probeKey identifies the run a result belongs to, for numbering its probes:
+// the destination and port, the address the destination resolved to, with
+// -all-ips one run of many, and the source
+func probeKey(res udping.Result) string {
+	return res.Source + "/" + res.IP + "/" + net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+}
+
+// csvWriter returns an OnResult hook that writes each result to w as a csv row,
+// preceded by the header row when header is set. Probes are numbered per
+// destination, port, address and source so that interleaved targets keep their own count.
+func csvWriter(w io.Writer, header bool) func(res udping.Result) {
+	cw := csv.NewWriter(w)
+	if header {
+		cw.Write(csvColumns)
+		cw.Flush()
+	}
+	probes := map[string]int{}
+	return func(res udping.Result) {
+		key := probeKey(res)
+		cw.Write(csvRow(probes[key], res))
+		cw.Flush()
+		probes[key]++
+	}
+}
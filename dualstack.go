@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type (
+	// familyResult is how the address of one family of a target fared
+	familyResult struct {
+		IP       string  `json:"ip"`
+		Sent     int     `json:"sent"`
+		Received int     `json:"received"`
+		AvgRTT   float64 `json:"avgrtt,omitempty"`
+		Up       bool    `json:"up"` // Up is true when at least one probe succeeded
+	}
+
+	// dualStackVerdict compares the IPv4 and IPv6 runs of one target
+	dualStackVerdict struct {
+		Name   string        `json:"name,omitempty"`
+		Target string        `json:"target"`
+		IPv4   *familyResult `json:"ipv4,omitempty"`
+		IPv6   *familyResult `json:"ipv6,omitempty"`
+		// First is the family whose first answer arrived first, the one a
+		// happy eyeballs client racing both would settle on
+		First string `json:"first,omitempty"`
+		// Degraded is set when the target has both families and only one answered
+		Degraded bool `json:"degraded"`
+	}
+
+	// dualStackReport is the -dual-stack verdict over every target
+	dualStackReport struct {
+		Healthy bool               `json:"healthy"` // Healthy is false when a target is degraded
+		Targets []dualStackVerdict `json:"targets"`
+	}
+)
+
+// expandDualStack replaces every target whose host has both IPv4 and IPv6
+// addresses with a target for the first address of each family, so that they
+// are probed side by side as RFC 8305 races them. It returns the targets and
+// the batches of their indexes to run in parallel: each pair, and every
+// other target alone. Literal IPs, pinned addresses and names with a single
+// family are kept as they are.
+func expandDualStack(targets []target, resolver *net.Resolver) ([]target, [][]int) {
+	var out []target
+	var batches [][]int
+	for _, t := range targets {
+		var v4, v6 string
+		if host, _, err := splitHostPort(t.Addr); err == nil && t.IP == "" && net.ParseIP(host) == nil {
+			ips, _ := resolver.LookupHost(context.Background(), host)
+			for _, ip := range ips {
+				if net.ParseIP(ip).To4() != nil {
+					if v4 == "" {
+						v4 = ip
+					}
+				} else if v6 == "" {
+					v6 = ip
+				}
+			}
+		}
+		if v4 == "" || v6 == "" {
+			batches = append(batches, []int{len(out)})
+			out = append(out, t)
+			continue
+		}
+		e4, e6 := t, t
+		e4.IP, e6.IP = v4, v6
+		batches = append(batches, []int{len(out), len(out) + 1})
+		out = append(out, e4, e6)
+	}
+	return out, batches
+}
+
+// newDualStackReport judges the runs of every batch of expandDualStack
+func newDualStackReport(runs []targetResult, batches [][]int) dualStackReport {
+	rep := dualStackReport{Healthy: true}
+	for _, batch := range batches {
+		v := dualStackVerdict{Name: runs[batch[0]].Name, Target: runs[batch[0]].Target}
+		var first time.Time
+		for _, i := range batch {
+			tr := runs[i]
+			ip := tr.IP
+			if host, _, err := splitHostPort(tr.Target); ip == "" && err == nil && net.ParseIP(host) != nil {
+				// a literal target
+				ip = host
+			}
+			fr := &familyResult{IP: ip, Sent: tr.sent, Received: tr.succeeded, AvgRTT: tr.Summary.AvgRTT, Up: tr.succeeded > 0}
+			family := "IPv4"
+			if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+				family, v.IPv6 = "IPv6", fr
+			} else {
+				v.IPv4 = fr
+			}
+			if !tr.firstAnswer.IsZero() && (first.IsZero() || tr.firstAnswer.Before(first)) {
+				first, v.First = tr.firstAnswer, family
+			}
+		}
+		if v.IPv4 != nil && v.IPv6 != nil && v.IPv4.Up != v.IPv6.Up {
+			v.Degraded = true
+			rep.Healthy = false
+		}
+		rep.Targets = append(rep.Targets, v)
+	}
+	return rep
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const (
+	otlpBatch   = 100 // results per OTLP export request
+	otlpMetrics = "/v1/metrics"
+	// gelfMaxUDP is the largest GELF datagram, above which GELF needs chunking
+	gelfMaxUDP = 8192
+)
+
+type (
+	// otlpAttribute and otlpValue are an OTLP KeyValue and AnyValue in the
+	// JSON encoding of OTLP/HTTP, which has 64 bit integers as strings
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+
+	// otlpPoint is a NumberDataPoint
+	otlpPoint struct {
+		Attributes   []otlpAttribute `json:"attributes"`
+		TimeUnixNano string          `json:"timeUnixNano"`
+		AsDouble     *float64        `json:"asDouble,omitempty"`
+		AsInt        *string         `json:"asInt,omitempty"`
+	}
+
+	// otlpMetric is a Metric holding a gauge
+	otlpMetric struct {
+		Name  string    `json:"name"`
+		Unit  string    `json:"unit"`
+		Gauge otlpGauge `json:"gauge"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpPoint `json:"dataPoints"`
+	}
+
+	// otlpRequest is an ExportMetricsServiceRequest
+	otlpRequest struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	// gelfWriter sends results as GELF messages in udp datagrams
+	gelfWriter struct {
+		c     net.Conn
+		host  string
+		runID string
+	}
+)
+
+func otlpString(key, v string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &v}}
+}
+
+func otlpInt(key string, v int) otlpAttribute {
+	s := strconv.Itoa(v)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+// newOTLPExporter starts a poster exporting every result to the OTLP/HTTP
+// collector at endpoint, http://collector:4318 say, as data points of two
+// gauges: udping.success, 1 or 0, for every probe and udping.rtt, in
+// seconds, for the answered ones. Requests use the JSON encoding of OTLP.
+func newOTLPExporter(endpoint string) *callbackPoster {
+	u := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(u, otlpMetrics) {
+		u += otlpMetrics
+	}
+	return newPoster(u, "otlp export", otlpBatch, encodeOTLP)
+}
+
+// encodeOTLP returns the export request of a batch of results
+func encodeOTLP(batch []callbackRecord) ([]byte, error) {
+	up := otlpMetric{Name: "udping.success", Unit: "1"}
+	rtt := otlpMetric{Name: "udping.rtt", Unit: "s"}
+	for _, rec := range batch {
+		res := rec.Result
+		attrs := []otlpAttribute{
+			otlpString("destination", res.Destination),
+			otlpInt("port", int(res.DestinationPort)),
+			otlpString("protocol", res.Protocol),
+			otlpString("ip", res.IP),
+			otlpString("outcome", string(res.Outcome)),
+			otlpString("udping.run_id", rec.RunID),
+		}
+		if res.Class != "" {
+			attrs = append(attrs, otlpString("class", string(res.Class)))
+		}
+		if res.Source != "" {
+			attrs = append(attrs, otlpString("source", res.Source))
+		}
+		// the time of the answer, or of the probe without one
+		at := res.ReceivedAt
+		if at == nil {
+			at = res.SentAt
+		}
+		var ts string
+		if at != nil {
+			ts = strconv.FormatInt(at.UnixNano(), 10)
+		}
+		success := "0"
+		if res.Success {
+			success = "1"
+		}
+		up.Gauge.DataPoints = append(up.Gauge.DataPoints, otlpPoint{Attributes: attrs, TimeUnixNano: ts, AsInt: &success})
+		if res.Error == "" && res.RTT > 0 {
+			v := res.RTT
+			rtt.Gauge.DataPoints = append(rtt.Gauge.DataPoints, otlpPoint{Attributes: attrs, TimeUnixNano: ts, AsDouble: &v})
+		}
+	}
+	metrics := []otlpMetric{up}
+	if len(rtt.Gauge.DataPoints) > 0 {
+		metrics = append(metrics, rtt)
+	}
+	return json.Marshal(otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: []otlpAttribute{otlpString("service.name", "udping")}},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "udping"}, Metrics: metrics}},
+	}}})
+}
+
+// gelfMessage returns the GELF 1.1 message of a result, its fields as
+// additional fields and the RTT and port as numbers
+func gelfMessage(res udping.Result, host, runID string) map[string]interface{} {
+	level := priInfo
+	if !res.Success {
+		level = priNotice
+	}
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          host,
+		"short_message": resultMessage(res),
+		"level":         level,
+		"_run_id":       runID,
+	}
+	if at := res.SentAt; at != nil {
+		msg["timestamp"] = float64(at.UnixNano()) / 1e9
+	}
+	for _, f := range resultFields(res) {
+		msg["_"+f.Key] = f.Value
+	}
+	msg["_port"] = int(res.DestinationPort)
+	if res.Error == "" {
+		msg["_rtt_ms"] = res.RTTMs
+	}
+	return msg
+}
+
+// newGELFExporter returns an OnResult hook sending every result as a GELF
+// message to endpoint, udp://graylog:12201 for a GELF udp input or an
+// http(s) URL for a GELF http one, and a function closing it
+func newGELFExporter(endpoint string) (func(res udping.Result), func(), error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, nil, fmt.Errorf("gelf endpoint must be udp://host:port or an http(s) URL, got %q", endpoint)
+	}
+	host, _ := os.Hostname()
+	switch u.Scheme {
+	case "udp":
+		c, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, nil, err
+		}
+		g := &gelfWriter{c: c, host: host, runID: newRunID()}
+		return g.Send, func() { c.Close() }, nil
+	case "http", "https":
+		// Graylog takes a single message per request
+		p := newPoster(endpoint, "gelf export", 1, func(batch []callbackRecord) ([]byte, error) {
+			return json.Marshal(gelfMessage(batch[0].Result, host, batch[0].RunID))
+		})
+		return p.Send, p.Close, nil
+	}
+	return nil, nil, fmt.Errorf("gelf endpoint must be udp://host:port or an http(s) URL, got %q", endpoint)
+}
+
+// Send writes the GELF message of a result in a datagram. Messages too big
+// for one are dropped rather than chunked: a result never comes close.
+func (g *gelfWriter) Send(res udping.Result) {
+	b, err := json.Marshal(gelfMessage(res, g.host, g.runID))
+	if err != nil || len(b) > gelfMaxUDP {
+		log.Printf("gelf export: dropping a message of %d bytes\n", len(b))
+		return
+	}
+	g.c.Write(b)
+}
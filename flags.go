@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// secondsDuration is a duration flag that also accepts a bare number of
+// seconds, as -t did before it took durations
+type secondsDuration time.Duration
+
+func (d *secondsDuration) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *secondsDuration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		secs, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return err
+		}
+		v = time.Duration(secs * float64(time.Second))
+		if v == 0 && secs != 0 {
+			return fmt.Errorf("%s seconds is shorter than a nanosecond", s)
+		}
+	}
+	*d = secondsDuration(v)
+	return nil
+}
+
+// durationFlag defines a duration flag of fs like fs.Duration that also
+// accepts a bare number of seconds
+func durationFlag(fs *flag.FlagSet, name string, value time.Duration, usage string) *time.Duration {
+	d := secondsDuration(value)
+	fs.Var(&d, name, usage)
+	return (*time.Duration)(&d)
+}
+
+// UnmarshalJSON reads a duration from a config file, either a string such as
+// "500ms" or a bare number of seconds
+func (d *secondsDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		s = string(b)
+	}
+	return d.Set(s)
+}
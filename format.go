@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const (
+	formatJSON   = "json"   // one JSON object, a runReport, printed once the run completes
+	formatNDJSON = "ndjson" // one JSON object per line, written as each probe completes
+
+	// largeCount is the probe count above which buffering every result for
+	// the json format is considered wasteful and a warning is printed
+	largeCount = 100000
+)
+
+// reportSchema is the version of the runReport layout, raised whenever a
+// field changes meaning or goes away. New fields keep the version: parsers
+// should ignore the fields they do not know.
+//
+//	1: schema and timestamp, the start of the run
+//	2: schema_version, started_at and ended_at; the -traceroute,
+//	   -mtu-discover and -load reports in the envelope instead of bare arrays
+//	3: the durations of params in seconds instead of nanoseconds
+const reportSchema = 3
+
+// runReport is the json format output: the parameters of the run with its
+// results and summary. A single target's results are at the top level,
+// several targets each get an entry in Targets. Runs that do not ping report
+// in Traceroute, MTU or Load instead.
+type runReport struct {
+	SchemaVersion int               `json:"schema_version"`
+	StartedAt     time.Time         `json:"started_at"`
+	EndedAt       time.Time         `json:"ended_at"`
+	Params        udping.Params     `json:"params"`
+	Selection     *udping.Selection `json:"selection,omitempty"`
+	Results       []udping.Result   `json:"results,omitempty"`
+	Summary       *udping.Stats     `json:"summary,omitempty"`
+	Targets       []targetResult    `json:"targets,omitempty"`
+	Traceroute    []traceReport     `json:"traceroute,omitempty"`
+	MTU           []mtuReport       `json:"mtu,omitempty"`
+	Load          []loadReport      `json:"load,omitempty"`
+}
+
+// newReport returns the envelope of a report on a run that started at start
+// and just ended
+func newReport(start time.Time, base udping.Params) runReport {
+	return runReport{SchemaVersion: reportSchema, StartedAt: start, EndedAt: time.Now(), Params: base}
+}
+
+// newRunReport gathers the runs that started at start and just ended into a
+// report. base holds the parameters shared by all targets.
+func newRunReport(start time.Time, base udping.Params, runs []targetResult) runReport {
+	rep := newReport(start, base)
+	if len(runs) != 1 {
+		rep.Targets = runs
+		return rep
+	}
+	tr := runs[0]
+	if tr.params.Destination != "" {
+		rep.Params = tr.params
+	}
+	rep.Selection = tr.Selection
+	rep.Results = tr.Results
+	rep.Summary = &tr.Summary
+	return rep
+}
+
+// validFormat reports whether f is a supported output format or a valid
+// result template
+func validFormat(f string) error {
+	if isTemplate(f) {
+		if _, err := parseResultTemplate(f); err != nil {
+			return fmt.Errorf("invalid format template: %v", err)
+		}
+		return nil
+	}
+	switch f {
+	case formatJSON, formatNDJSON, formatCSV, formatText, formatTable:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q, expected %s, %s, %s, %s, %s or a {{.Field}} template", f, formatJSON, formatNDJSON, formatCSV, formatText, formatTable)
+}
+
+// streamed reports whether format f writes results as they complete rather
+// than keeping them for the json report. The table format keeps its own rows.
+func streamed(f string) bool {
+	return isTemplate(f) || f == formatNDJSON || f == formatCSV || f == formatText || f == formatTable
+}
+
+// ndjsonWriter returns an OnResult hook that writes each result to w as a single JSON line
+func ndjsonWriter(w io.Writer) func(res udping.Result) {
+	enc := json.NewEncoder(w)
+	return func(res udping.Result) {
+		enc.Encode(res)
+	}
+}
+
+// optionalBool is a boolean flag that remembers whether it was given on the command line
+type optionalBool struct {
+	set   bool
+	value bool
+}
+
+func (b *optionalBool) String() string {
+	if b == nil || !b.set {
+		return "auto"
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.set, b.value = true, v
+	return nil
+}
+
+func (b *optionalBool) IsBoolFlag() bool { return true }
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// encodeJSON marshals i, indented when pretty is set and on a single line otherwise
+func encodeJSON(i interface{}, pretty bool) string {
+	if pretty {
+		return prettyPrint(i)
+	}
+	s, _ := json.Marshal(i)
+	return string(s)
+}
+
+// openSink opens the named output: "stdout", "stderr" or a file path, which is
+// truncated unless appendTo is set. The returned function closes the file, if one was opened.
+func openSink(name string, appendTo bool) (io.Writer, func(), error) {
+	switch name {
+	case "stdout":
+		return os.Stdout, func() {}, nil
+	case "stderr", "":
+		return os.Stderr, func() {}, nil
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendTo {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(name, flags, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
@@ -1,3 +1,12 @@
 module github.com/nguyendhst/udping
 
 go 1.18
+
+require golang.org/x/net v0.17.0
+
+require golang.org/x/time v0.3.0
+
+require (
+	github.com/google/gopacket v1.1.19
+	golang.org/x/sys v0.13.0 // indirect
+)
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+type (
+	// transition is a change of a target between reachable and unreachable
+	transition struct {
+		Target string
+		At     time.Time
+		Up     bool
+	}
+
+	// stateTracker follows the up/down state of every target as results arrive
+	// and records when it changes. The first result of a target only sets its
+	// initial state; a target that starts down is still reported as an outage.
+	stateTracker struct {
+		mu          sync.Mutex
+		state       map[string]bool
+		transitions []transition
+	}
+
+	// grafanaAnnotation is an annotation as accepted by Grafana's /api/annotations endpoint
+	grafanaAnnotation struct {
+		Time    int64    `json:"time"`              // Time is when the target went down, in milliseconds since the epoch
+		TimeEnd int64    `json:"timeEnd,omitempty"` // TimeEnd is when it recovered; unset while it is still down
+		Tags    []string `json:"tags"`
+		Text    string   `json:"text"`
+	}
+)
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{state: map[string]bool{}}
+}
+
+// Add records the state a result shows its target in
+func (t *stateTracker) Add(res udping.Result) {
+	t.observe(res)
+}
+
+// observe records the state a result shows its target in and returns the
+// transition it made, if any
+func (t *stateTracker) observe(res udping.Result) (transition, bool) {
+	key := net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+	up := res.Success
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, seen := t.state[key]
+	if seen && prev == up {
+		return transition{}, false
+	}
+	t.state[key] = up
+	if !seen && up {
+		return transition{}, false
+	}
+	tr := transition{Target: key, At: time.Now(), Up: up}
+	t.transitions = append(t.transitions, tr)
+	return tr, true
+}
+
+// annotations returns one region annotation per outage, from the time a target went down until it recovered
+func (t *stateTracker) annotations() []grafanaAnnotation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var out []grafanaAnnotation
+	open := map[string]int{}
+	for _, tr := range t.transitions {
+		if !tr.Up {
+			open[tr.Target] = len(out)
+			out = append(out, grafanaAnnotation{
+				Time: tr.At.UnixNano() / int64(time.Millisecond),
+				Tags: []string{"udping", "outage", tr.Target},
+				Text: fmt.Sprintf("%s unreachable", tr.Target),
+			})
+			continue
+		}
+		if i, ok := open[tr.Target]; ok {
+			out[i].TimeEnd = tr.At.UnixNano() / int64(time.Millisecond)
+			out[i].Text = fmt.Sprintf("%s unreachable for %v", tr.Target, tr.At.Sub(time.Unix(0, out[i].Time*int64(time.Millisecond))).Round(time.Millisecond))
+			delete(open, tr.Target)
+		}
+	}
+	return out
+}
+
+// writeAnnotations stores annotations as a JSON array in path
+func writeAnnotations(path string, annotations []grafanaAnnotation) error {
+	b, err := json.MarshalIndent(annotations, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// postAnnotations sends each annotation to the Grafana instance at baseURL.
+// token, when set, is sent as a bearer token (an API key or service account token).
+func postAnnotations(baseURL, token string, annotations []grafanaAnnotation) error {
+	client := &http.Client{Timeout: callbackTimeout}
+	url := strings.TrimSuffix(baseURL, "/") + "/api/annotations"
+	for _, a := range annotations {
+		body, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("grafana returned %s", resp.Status)
+		}
+	}
+	return nil
+}
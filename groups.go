@@ -0,0 +1,127 @@
+package main
+
+import "sort"
+
+// ungrouped is the group of the targets given no group when others have one
+const ungrouped = "ungrouped"
+
+type (
+	// groupStats aggregates the runs of the targets of one group
+	groupStats struct {
+		Group    string  `json:"group"`
+		Targets  int     `json:"targets"`
+		Up       int     `json:"up"` // Up is the number of targets with at least one successful probe
+		Sent     int     `json:"sent"`
+		Received int     `json:"received"`
+		Loss     float64 `json:"loss"`             // Loss is the percentage of the group's probes that did not succeed
+		AvgRTT   float64 `json:"avgrtt,omitempty"` // AvgRTT is the average RTT over every successful probe of the group
+		MaxRTT   float64 `json:"maxrtt,omitempty"`
+		Pass     bool    `json:"pass"` // Pass is true when the group's loss is at most MaxLoss
+	}
+
+	// rankedTarget is a target listed among the slowest or lossiest
+	rankedTarget struct {
+		Name   string  `json:"name,omitempty"`
+		Target string  `json:"target"`
+		IP     string  `json:"ip,omitempty"`
+		Group  string  `json:"group,omitempty"`
+		Sent   int     `json:"sent"`
+		Loss   float64 `json:"loss"`
+		AvgRTT float64 `json:"avgrtt,omitempty"`
+	}
+
+	// groupReport is the per-group verdict of a multi-target run, with the
+	// targets to look at first
+	groupReport struct {
+		MaxLoss  float64        `json:"max_loss"` // MaxLoss is the highest loss of a passing group
+		Pass     bool           `json:"pass"`     // Pass is false when a group failed
+		Groups   []groupStats   `json:"groups,omitempty"`
+		Slowest  []rankedTarget `json:"slowest"`
+		Lossiest []rankedTarget `json:"lossiest"`
+	}
+)
+
+// newGroupReport aggregates the runs by group, in the order the groups first
+// appear, and ranks up to top targets by their average RTT and by their
+// loss. Runs that could not start count as a total loss. Groups are left out
+// when no target has one.
+func newGroupReport(runs []targetResult, maxLoss float64, top int) groupReport {
+	rep := groupReport{MaxLoss: maxLoss, Pass: true}
+	grouped := false
+	for _, tr := range runs {
+		grouped = grouped || tr.Group != ""
+	}
+
+	index := make(map[string]int)
+	// the sum of the RTTs of each group, and how many probes it is over
+	var rttSums []float64
+	var rttCounts []int
+	ranked := make([]rankedTarget, 0, len(runs))
+	for _, tr := range runs {
+		rt := rankedTarget{Name: tr.Name, Target: tr.Target, IP: tr.IP, Group: tr.Group, Sent: tr.sent, Loss: 100, AvgRTT: tr.Summary.AvgRTT}
+		if tr.sent > 0 {
+			rt.Loss = 100 * float64(tr.sent-tr.succeeded) / float64(tr.sent)
+		}
+		ranked = append(ranked, rt)
+		if !grouped {
+			continue
+		}
+
+		name := tr.Group
+		if name == "" {
+			name = ungrouped
+		}
+		i, ok := index[name]
+		if !ok {
+			i = len(rep.Groups)
+			index[name] = i
+			rep.Groups = append(rep.Groups, groupStats{Group: name})
+			rttSums = append(rttSums, 0)
+			rttCounts = append(rttCounts, 0)
+		}
+		g := &rep.Groups[i]
+		g.Targets++
+		if tr.succeeded > 0 {
+			g.Up++
+		}
+		g.Sent += tr.sent
+		g.Received += tr.succeeded
+		// the summary's average is over the target's successful probes
+		rttSums[i] += tr.Summary.AvgRTT * float64(tr.Summary.Received)
+		rttCounts[i] += tr.Summary.Received
+		if tr.Summary.MaxRTT > g.MaxRTT {
+			g.MaxRTT = tr.Summary.MaxRTT
+		}
+	}
+	for i := range rep.Groups {
+		g := &rep.Groups[i]
+		g.Loss = 100
+		if g.Sent > 0 {
+			g.Loss = 100 * float64(g.Sent-g.Received) / float64(g.Sent)
+		}
+		if rttCounts[i] > 0 {
+			g.AvgRTT = rttSums[i] / float64(rttCounts[i])
+		}
+		g.Pass = g.Loss <= maxLoss
+		rep.Pass = rep.Pass && g.Pass
+	}
+
+	rep.Slowest = topTargets(ranked, top, func(rt rankedTarget) bool { return rt.AvgRTT > 0 }, func(a, b rankedTarget) bool { return a.AvgRTT > b.AvgRTT })
+	rep.Lossiest = topTargets(ranked, top, func(rt rankedTarget) bool { return rt.Loss > 0 }, func(a, b rankedTarget) bool { return a.Loss > b.Loss })
+	return rep
+}
+
+// topTargets returns up to n of the targets that keep, first by less
+func topTargets(targets []rankedTarget, n int, keep func(rankedTarget) bool, less func(a, b rankedTarget) bool) []rankedTarget {
+	out := []rankedTarget{}
+	for _, rt := range targets {
+		if keep(rt) {
+			out = append(out, rt)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return less(out[i], out[j]) })
+	if len(out) > n {
+		out = out[:n]
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// historySize bounds the samples kept per target, 15 minutes of probes at
+// about 9 per second. Faster runs get windows cut short to the samples kept.
+const historySize = 8192
+
+// historyWindows are the spans of the rolling aggregates
+var historyWindows = []struct {
+	name string
+	span time.Duration
+}{{"1m", time.Minute}, {"5m", 5 * time.Minute}, {"15m", 15 * time.Minute}}
+
+type (
+	// sample is what the history keeps of one probe
+	sample struct {
+		at      time.Time
+		success bool
+		rtt     float64 // 0 unless the probe got an answer without error
+	}
+
+	// history is a ring of the latest samples of a target
+	history struct {
+		samples []sample
+		next    int // where the next sample goes once the ring is full
+	}
+
+	// windowStats aggregates the probes of a target that completed within a window
+	windowStats struct {
+		Window   string  `json:"window"`
+		Sent     int     `json:"sent"`
+		Received int     `json:"received"`
+		Loss     float64 `json:"loss"`             // Loss is the percentage of probes that did not succeed
+		AvgRTT   float64 `json:"avgrtt,omitempty"` // AvgRTT is the mean RTT of the answered probes, in seconds
+		MaxRTT   float64 `json:"maxrtt,omitempty"`
+	}
+
+	// targetWindows are the rolling aggregates of one target
+	targetWindows struct {
+		Target  string        `json:"target"`
+		Windows []windowStats `json:"windows"`
+	}
+)
+
+// add records the sample of a result, dropping the oldest once the ring is full
+func (h *history) add(res udping.Result, at time.Time) {
+	s := sample{at: at, success: res.Success}
+	if res.Success && res.Error == "" {
+		s.rtt = res.RTT
+	}
+	if len(h.samples) < historySize {
+		h.samples = append(h.samples, s)
+		return
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % historySize
+}
+
+// windows returns the aggregates of every window ending at now. A window
+// without probes reports no loss rather than a total one: the target was not
+// probed, not found down.
+func (h *history) windows(now time.Time) []windowStats {
+	out := make([]windowStats, len(historyWindows))
+	for i, w := range historyWindows {
+		out[i] = h.window(now, w.span)
+		out[i].Window = w.name
+	}
+	return out
+}
+
+// window returns the aggregate of the probes that completed within span of now
+func (h *history) window(now time.Time, span time.Duration) windowStats {
+	var ws windowStats
+	rtts := 0
+	for _, s := range h.samples {
+		if now.Sub(s.at) > span {
+			continue
+		}
+		ws.Sent++
+		if s.success {
+			ws.Received++
+		}
+		if s.rtt > 0 {
+			rtts++
+			ws.AvgRTT += s.rtt
+			if s.rtt > ws.MaxRTT {
+				ws.MaxRTT = s.rtt
+			}
+		}
+	}
+	if ws.Sent > 0 {
+		ws.Loss = 100 * float64(ws.Sent-ws.Received) / float64(ws.Sent)
+	}
+	if rtts > 0 {
+		ws.AvgRTT /= float64(rtts)
+	}
+	return ws
+}
+
+// windows returns the rolling aggregates of every target, sorted by target
+func (m *metricsCollector) windows(now time.Time) []targetWindows {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]targetWindows, 0, len(m.targets))
+	for k, t := range m.targets {
+		out = append(out, targetWindows{Target: k, Windows: t.history.windows(now)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Target < out[j].Target })
+	return out
+}
+
+// serveWindows writes the rolling aggregates of every target as JSON, for
+// telling a short blip from a sustained outage without a Prometheus server
+func (m *metricsCollector) serveWindows(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(m.windows(time.Now()))
+}
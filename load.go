@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// loadReport is the outcome of a load run against one target
+type loadReport struct {
+	Name   string `json:"name,omitempty"`
+	Target string `json:"target"`
+	udping.LoadReport
+	Error string `json:"error,omitempty"`
+	// invalid is set when the run was refused for its parameters
+	invalid bool
+}
+
+// loadTargets sends the probes of params to every target in parallel at pps
+// each, without waiting for replies, and returns a report per target in the
+// order of targets
+func loadTargets(ctx context.Context, params udping.Params, targets []target, pps float64, progress progressLogger) []loadReport {
+	reports := make([]loadReport, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		p := params
+		rep := &reports[i]
+		rep.Name, rep.Target = t.Name, t.Addr
+		host, port, err := splitHostPort(t.Addr)
+		if err != nil {
+			rep.Error, rep.invalid = err.Error(), true
+			continue
+		}
+		p.Destination, p.DestinationPort, p.IP, p.Source = host, port, t.IP, t.Source
+		if t.Count > 0 {
+			p.Count = t.Count
+		}
+		if t.Timeout > 0 {
+			p.Timeout = t.Timeout
+		}
+		r := udping.New(p)
+		r.Logf = progress.Infof
+		r.Debugf = progress.Debugf
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := r.Load(ctx, pps)
+			warnClampedBuffers(rep.Target, r)
+			rep.LoadReport = res
+			if err != nil {
+				rep.Error, rep.invalid = err.Error(), errors.Is(err, udping.ErrInvalidParameters)
+			}
+		}()
+	}
+	wg.Wait()
+	return reports
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// verbosity levels of progress messages
+const (
+	levelQuiet   = iota // no progress messages, only results and reports
+	levelNormal         // each probe starting and the size of its reply
+	levelVerbose        // also resolved addresses, sockets and probe timings
+	levelTrace          // also every datagram sent and received
+)
+
+// progressLogger writes the progress messages of the runners up to its level
+type progressLogger struct {
+	w     io.Writer
+	level int
+}
+
+// Infof writes a progress message unless the logger is quiet
+func (l progressLogger) Infof(format string, args ...interface{}) {
+	if l.level >= levelNormal {
+		fmt.Fprintf(l.w, format, args...)
+	}
+}
+
+// Debugf writes a detail message when the logger is verbose
+func (l progressLogger) Debugf(format string, args ...interface{}) {
+	if l.level >= levelVerbose {
+		fmt.Fprintf(l.w, format, args...)
+	}
+}
+
+// Tracef writes a datagram dump at the trace level
+func (l progressLogger) Tracef(format string, args ...interface{}) {
+	if l.level >= levelTrace {
+		fmt.Fprintf(l.w, format, args...)
+	}
+}
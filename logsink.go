@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// syslog priorities of the records of a log sink
+const (
+	priWarning = 4 // a target went down
+	priNotice  = 5 // a failed probe, or a target that recovered
+	priInfo    = 6 // an answered probe
+)
+
+// journalSocket is where journald takes records in its native protocol
+const journalSocket = "/run/systemd/journal/socket"
+
+type (
+	// logField is a named value of a structured log record
+	logField struct {
+		Key   string
+		Value string
+	}
+
+	// logWriter writes structured records to a log system
+	logWriter interface {
+		Write(priority int, msg string, fields []logField) error
+		Close() error
+	}
+
+	// journalWriter sends records to journald, every field its own journal field
+	journalWriter struct {
+		c net.Conn
+	}
+
+	// fileLogWriter appends records to a file as logfmt lines
+	fileLogWriter struct {
+		mu sync.Mutex
+		w  io.WriteCloser
+	}
+)
+
+// newLogSink opens the log sink named by spec, syslog, journald or
+// file:<path>, and returns an OnResult hook writing a record per result to
+// it, with an alert record whenever a target goes down or recovers, and a
+// function closing it
+func newLogSink(spec string) (func(res udping.Result), func(), error) {
+	var lw logWriter
+	var err error
+	switch {
+	case spec == "syslog":
+		lw, err = newSyslogWriter()
+	case spec == "journald":
+		lw, err = newJournalWriter()
+	case strings.HasPrefix(spec, "file:") && len(spec) > len("file:"):
+		var f *os.File
+		f, err = os.OpenFile(strings.TrimPrefix(spec, "file:"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		lw = &fileLogWriter{w: f}
+	default:
+		return nil, nil, fmt.Errorf("unknown log sink %q, expected syslog, journald or file:<path>", spec)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("log sink %s: %v", spec, err)
+	}
+	states := newStateTracker()
+	hook := func(res udping.Result) {
+		fields := resultFields(res)
+		priority := priInfo
+		if !res.Success {
+			priority = priNotice
+		}
+		lw.Write(priority, resultMessage(res), fields)
+		if tr, ok := states.observe(res); ok {
+			if tr.Up {
+				lw.Write(priNotice, tr.Target+" recovered", append(fields, logField{"alert", "up"}))
+			} else {
+				lw.Write(priWarning, tr.Target+" is down", append(fields, logField{"alert", "down"}))
+			}
+		}
+	}
+	return hook, func() { lw.Close() }, nil
+}
+
+// resultMessage is the human readable message of the record of res
+func resultMessage(res udping.Result) string {
+	addr := textAddr(res)
+	if res.Error == "" {
+		return fmt.Sprintf("%s answered in %.3f ms", addr, res.RTTMs)
+	}
+	return fmt.Sprintf("%s: %s", addr, res.Error)
+}
+
+// resultFields are the structured fields of the record of res
+func resultFields(res udping.Result) []logField {
+	fields := []logField{
+		{"destination", res.Destination},
+		{"port", strconv.Itoa(int(res.DestinationPort))},
+		{"protocol", res.Protocol},
+		{"ip", res.IP},
+		{"success", strconv.FormatBool(res.Success)},
+		{"outcome", string(res.Outcome)},
+		{"state", res.State},
+	}
+	if res.Error != "" {
+		fields = append(fields, logField{"error", res.Error}, logField{"class", string(res.Class)})
+	} else {
+		fields = append(fields, logField{"rtt_ms", strconv.FormatFloat(res.RTTMs, 'f', 3, 64)})
+	}
+	if res.Source != "" {
+		fields = append(fields, logField{"source", res.Source})
+	}
+	return fields
+}
+
+// logfmt formats fields as key=value pairs, quoting the values that need it
+func logfmt(fields []logField) string {
+	var b strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		if f.Value == "" || strings.ContainsAny(f.Value, " =\"\n") {
+			b.WriteString(strconv.Quote(f.Value))
+		} else {
+			b.WriteString(f.Value)
+		}
+	}
+	return b.String()
+}
+
+func newJournalWriter() (*journalWriter, error) {
+	c, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journalWriter{c: c}, nil
+}
+
+// Write sends a record in the native journal protocol, the fields upper
+// cased and prefixed with UDPING_
+func (j *journalWriter) Write(priority int, msg string, fields []logField) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", msg)
+	journalField(&b, "PRIORITY", strconv.Itoa(priority))
+	journalField(&b, "SYSLOG_IDENTIFIER", "udping")
+	for _, f := range fields {
+		journalField(&b, "UDPING_"+strings.ToUpper(f.Key), f.Value)
+	}
+	_, err := j.c.Write(b.Bytes())
+	return err
+}
+
+func (j *journalWriter) Close() error {
+	return j.c.Close()
+}
+
+// journalField appends a field to a journal record: KEY=value on a line, or
+// for a value spanning lines, the key, a newline, the length of the value as
+// a little endian 64 bit integer, the value and a newline
+func journalField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+	b.Write(n[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// Write appends a record as a logfmt line, led by its time and priority
+func (f *fileLogWriter) Write(priority int, msg string, fields []logField) error {
+	head := []logField{{"time", time.Now().Format(time.RFC3339Nano)}, {"priority", strconv.Itoa(priority)}, {"msg", msg}}
+	line := logfmt(append(head, fields...)) + "\n"
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := io.WriteString(f.w, line)
+	return err
+}
+
+func (f *fileLogWriter) Close() error {
+	return f.w.Close()
+}
@@ -0,0 +1,34 @@
+//go:build !windows && !plan9
+
+package main
+
+import "log/syslog"
+
+// syslogWriter sends records to the local syslog daemon, their fields as
+// logfmt after the message
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func newSyslogWriter() (logWriter, error) {
+	w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "udping")
+	if err != nil {
+		return nil, err
+	}
+	return syslogWriter{w: w}, nil
+}
+
+func (s syslogWriter) Write(priority int, msg string, fields []logField) error {
+	line := msg + " " + logfmt(fields)
+	switch priority {
+	case priWarning:
+		return s.w.Warning(line)
+	case priNotice:
+		return s.w.Notice(line)
+	}
+	return s.w.Info(line)
+}
+
+func (s syslogWriter) Close() error {
+	return s.w.Close()
+}
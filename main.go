@@ -1,64 +1,1205 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
-	"strconv"
-	"strings"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+	"golang.org/x/time/rate"
+)
+
+// syntax: go run . -t <timeout> -c <count> [-targets <file>] <ip>:<port>...
+//         go run . -p icmp -t <timeout> -c <count> <ip>...
+//         go run . -stream -c 0 <ip>:<port> | jq     (one JSON result per line as each probe completes)
+//         go run . -load -rate 1000 -c 60000 <ip>:<port>   (a minute at 1000 packets per second, counting the answers)
+//         go run . report -store <file> -since 24h           (summarize the results kept with -store)
+//         go run . traceroute <ip>:<port>                   (see cli.go for every subcommand)
+
+// process exit codes
+const (
+	exitOK          = 0 // every probe succeeded, or the loss stayed within -fail-on-loss
+	exitUnreachable = 1 // some probes failed or timed out, the loss going above -fail-on-loss, 0 by default; with -fail-on-loss -1, only when every probe of every target did; or -punch did not get datagrams through both ways
+	exitUsage       = 2 // invalid flags, arguments or probe parameters
+	exitSLAFailed   = 3 // an -sla-rtt objective was set and not met, unless exitUsage or exitAddrDown applies too
+	exitAddrDown    = 4 // -require-all-ips was set and at least one address failed, or -dual-stack and one family of a target
+	exitFailed      = 5 // a server such as -echo or -relay could not listen or stopped with an error
+
+	exitInterrupted = 130 // the run was interrupted before completing, as shells report for SIGINT
 )
 
-// syntax: go run main.go <ip>:<port> -t <timeout> -c <count>
+// minUserInterval is the shortest -i allowed without root, as in ping
+const minUserInterval = 200 * time.Millisecond
 
 func main() {
-	// Parse the command line flags
-	flag.Parse()
+	os.Exit(realMain())
+}
 
-	// get address from command line
-	ipport := flag.Arg(0)
+// realMain runs the subcommand the command line names and returns the process
+// exit code. A command line naming none probes, as "udping probe" does.
+func realMain() int {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			return cmd(args[1:])
+		}
+	}
+	return probeCommand(args)
+}
+
+// probeCommand runs "udping probe", probing the targets of args, and returns
+// the process exit code, so that deferred cleanups still run before exiting
+func probeCommand(args []string) int {
+	fs := flag.NewFlagSet("probe", flag.ContinueOnError)
 	// get timeout from command line
-	timeout := flag.Int("t", 5, "timeout")
+	timeout := secondsDuration(5 * time.Second)
+	fs.Var(&timeout, "t", "per-probe timeout, e.g. 500ms or 2s; a bare number is seconds")
 	// get count from command line
-	count := flag.Int("c", 3, "count")
+	count := fs.Int("c", 3, "count, 0 to probe until interrupted")
+	continuous := fs.Bool("continuous", false, "probe until interrupted, ignoring -c")
+	// cap on the whole run
+	deadline := durationFlag(fs, "deadline", 0, "stop the whole run after this long, whatever -c and -t, e.g. 10s; a bare number is seconds")
+	targetDeadline := durationFlag(fs, "target-deadline", 0, "stop probing each target after this long, so that one slow target cannot hold up the others, e.g. 5s; a bare number is seconds")
+	// get protocol from command line
+	protocol := fs.String("p", "udp", "protocol: udp, tcp or icmp")
+	proxyProtocol := fs.String("proxy-protocol", "", "send a PROXY protocol header (v1 or v2) on tcp pings")
+	proxy := fs.String("proxy", "", "send udp probes through the `socks5://[user:password@]host:port` proxy's UDP ASSOCIATE relay")
+	// icmp echo data, like ping -s and -p
+	icmpSize := fs.Int("icmp-size", udping.DefaultICMPSize, "icmp echo data size in bytes")
+	icmpPattern := fs.String("icmp-pattern", "", "hex bytes repeated to fill the icmp echo data, e.g. ff00")
+	// probe mode and its dns query
+	mode := fs.String("mode", udping.ModeRaw, "probe mode: raw, dns, ntp, quic, stun, snmp, sip, tftp, wireguard or echo")
+	fs.StringVar(mode, "probe", udping.ModeRaw, "same as -mode")
+	dnsName := fs.String("dns-name", udping.DefaultDNSName, "name to query in dns mode")
+	snmpCommunity := fs.String("snmp-community", udping.DefaultSNMPCommunity, "community sent in snmp mode")
+	anyReply := fs.Bool("any-reply", false, "count a well-formed error reply of a service mode, a dns NXDOMAIN or an snmp noSuchName, as an answer and report its error")
+	wgKey := fs.String("wg-key", "", "base64 public key of the server in wireguard mode, as wg show prints it")
+	dnsTypeName := fs.String("dns-type", "A", "query type in dns mode: A, AAAA, NS, CNAME, SOA, PTR, MX or TXT")
+	// raw mode payload
+	payload := fs.String("payload", udping.DefaultPayload, "payload sent in raw mode, a string or 0x-prefixed hex; {{host}} in a string is replaced with the destination host")
+	payloadFile := fs.String("payload-file", "", "send the contents of this file as the raw mode payload, as they are")
+	pattern := fs.String("pattern", "", "send these hex bytes as the raw mode payload, repeated to fill -size, e.g. ff00")
+	size := fs.Int("size", 0, "pad with zeros or truncate the payload to this many bytes, e.g. to find path MTU problems")
+	fs.IntVar(size, "s", 0, "shorthand for -size")
+	refusalFails := fs.Bool("refused-fails", false, "count a udp probe refused with an ICMP port unreachable as a failure instead of as reachable, same as -success answered")
+	successPolicy := fs.String("success", "", "outcomes counted as success: reachable (answers, and refusals of raw udp probes, the default), answered, or not-closed (timeouts too)")
+	burst := fs.Int("burst", 0, "send udp probes in rounds of this many back to back, waiting for their replies together and matching them by sequence number")
+	spray := fs.Int("spray", 0, "rotate udp probes over this many source ports, so that they hash onto different ECMP paths, and report each port's loss and rtt")
+	reuseSocket := fs.Bool("reuse-socket", false, "send every udp probe of a target over one socket, for high probe rates; use -correlate to tell late replies apart")
+	randomize := fs.Bool("randomize", false, "send random bytes of the payload's size, fresh for every probe, to defeat response caching")
+	// pause between probes, like ping -i
+	interval := secondsDuration(time.Second)
+	fs.Var(&interval, "i", "wait between probes, e.g. 200ms or 1.5s; a bare number is seconds; ignored when -spacing is set")
+	// minimum send-to-send spacing between probes
+	spacing := fs.Duration("spacing", 0, "minimum time between the start of consecutive probes, e.g. 200ms; replaces -i, probes are sent every -spacing or as soon as the previous one completes")
+	// re-resolve the destination before every probe
+	resolver := fs.String("resolver", "", "DNS server to resolve targets with instead of the system's, e.g. 1.1.1.1:53")
+	noResolve := fs.Bool("no-resolve", false, "fail targets that are not IP addresses instead of resolving them")
+	resolveEach := fs.Bool("resolve-each", false, "resolve the destination before every probe and record the IP used")
+	reresolve := durationFlag(fs, "reresolve", 0, "resolve the destination again every this long during the run, following address changes and flagging results whose lookup returned other addresses, e.g. 30s; a bare number is seconds")
+	// probe all resolved addresses and keep the fastest
+	fastest := fs.Bool("fastest", false, "probe every resolved address once, then run all probes against the fastest")
+	// retries of failed dials within a probe
+	dialRetries := fs.Int("dial-retries", 0, "retry a failed dial this many times within a probe")
+	retries := fs.Int("retries", 0, "send a probe that timed out or hit a transient send error such as ENOBUFS again up to this many times before counting it as failed")
+	backoffStrategy := fs.String("backoff-strategy", udping.BackoffExponential, "delay between retries: fixed, linear or exponential")
+	fs.StringVar(backoffStrategy, "backoff", udping.BackoffExponential, "same as -backoff-strategy")
+	backoffBase := fs.Duration("backoff-base", udping.DefaultBackoffBase, "first delay between retries")
+	backoffCap := fs.Duration("backoff-cap", udping.DefaultBackoffCap, "longest delay between retries")
+	// cap on total probe traffic
+	byteBudget := fs.String("byte-budget", "", "stop once this many probe bytes were sent in total, e.g. 1MB")
+	// adaptive per-probe timeout
+	escalate := fs.Bool("escalate-timeout", false, "start with a short per-probe timeout and grow it up to -t on timeouts")
+	escalateStart := fs.Duration("escalate-start", udping.DefaultEscalateStart, "first per-probe timeout with -escalate-timeout")
+	kernelStamps := fs.Bool("kernel-timestamps", false, "time udp replies by the kernel's receive timestamps, leaving scheduling delays out of RTTs (Linux only)")
+	histogram := fs.Bool("histogram", false, "add the RTT distribution to summaries as log-linear microsecond buckets that merge across runs, as HDR histograms")
+	replyHeaders := fs.Bool("reply-headers", false, "record the TTL and ToS of udp replies, inferring how many hops the reply path has (Linux only)")
+	adaptive := fs.Bool("adaptive-timeout", false, "start with -t and tighten the per-probe timeout to a multiple of the smoothed RTT once replies come in")
+	// tag each payload so its reply can be matched
+	sequence := fs.Bool("seq", false, "append a sequence number and send time to each payload, match replies to their probe and count late and duplicate ones")
+	correlate := fs.Bool("correlate", false, "append a random token to each payload and ignore replies that do not carry it")
+	// reverse DNS, like ping -n
+	numeric := fs.Bool("n", false, "do not look up the reverse DNS name of the probed addresses")
+	// pin the address family
+	ipv4Only := fs.Bool("4", false, "only probe IPv4 addresses")
+	ipv6Only := fs.Bool("6", false, "only probe IPv6 addresses")
+	strictIPv6 := fs.Bool("strict-ipv6-only", false, "fail targets that are or only resolve to IPv4 addresses and never probe over IPv4")
+	// drop duplicate targets
+	dedupe := fs.Bool("dedupe", false, "skip targets resolving to an address, port and protocol already probed")
+	// probe every address of a name
+	allIPs := fs.Bool("all-ips", false, "probe every address a target's host resolves to")
+	requireAllIPs := fs.Bool("require-all-ips", false, "with -all-ips, fail unless every resolved address answers")
+	dualStack := fs.Bool("dual-stack", false, "probe the first IPv4 and IPv6 address of each target's host side by side, reporting which answered first and failing when only one does")
+	// traceroute style reachability
+	mtuDiscover := fs.Bool("mtu-discover", false, "find the path MTU to each udp target, binary searching the largest raw mode probe that gets through with DF set")
+	compare := fs.Bool("compare", false, "probe exactly two targets side by side and compare the second against the first: the distribution of their RTT differences and their loss")
+	route := fs.Bool("traceroute", false, "trace the route to each udp target, raising the TTL of -c probes by one until the destination answers")
+	maxHops := fs.Int("max-hops", defaultMaxHops, "with -traceroute, the largest TTL tried")
+	ttl := fs.Int("ttl", 0, "send udp probes with this IP TTL or IPv6 hop limit, reporting the router that dropped them")
+	dontFragment := fs.Bool("df", false, "send udp probes with the don't fragment bit set, failing those too big for the local or path mtu instead of fragmenting them")
+	rcvbuf := fs.Int("rcvbuf", 0, "set the receive buffer of udp sockets to this many bytes, so that replies of high-rate runs are not dropped in it; warns when the kernel caps it")
+	sndbuf := fs.Int("sndbuf", 0, "set the send buffer of udp sockets to this many bytes; warns when the kernel caps it")
+	// QoS marking
+	dscp := fs.Int("dscp", 0, "mark udp probes with this DSCP value, 0 to 63, e.g. 46 for expedited forwarding")
+	tos := fs.Int("tos", 0, "set the whole ToS byte of udp probes, DSCP and ECN bits, e.g. 0xb8")
+	// expected reply length
+	expectLen := fs.Int("expect-len", 0, "fail probes whose reply is not exactly this many bytes")
+	expectLenMin := fs.Bool("expect-len-min", false, "treat -expect-len as a minimum length")
+	// expected reply content
+	expect := fs.String("expect", "", "fail udp probes whose reply does not contain this substring or 0x-prefixed hex pattern")
+	// show what came back
+	replyDump := fs.Int("reply-dump", 0, "include this many leading reply bytes in results, hex encoded unless -dump-encoding says otherwise")
+	fs.IntVar(replyDump, "dump", 0, "same as -reply-dump")
+	dumpEncoding := fs.String("dump-encoding", udping.DumpHex, "encoding of the reply bytes of -reply-dump: hex or base64")
+	// probe every target from several local addresses
+	sourcesList := fs.String("sources", "", "comma separated local addresses to probe every target from in parallel, e.g. 10.0.0.1,10.0.0.2")
+	fs.StringVar(sourcesList, "src", "", "local address to probe from, same as -sources")
+	sourcePort := fs.Int("sport", 0, "local port to send udp probes from, e.g. to test firewall rules keyed on it")
+	iface := fs.String("interface", "", "network interface or VRF device to send probes through, e.g. eth1")
+	fs.StringVar(iface, "I", "", "shorthand for -interface")
+	// read more targets from a file
+	targetsFile := fs.String("targets", "", "file with one \"host:port [count] [weight=w] [group=g]\" target per line; count overrides -c")
+	fs.StringVar(targetsFile, "f", "", "shorthand for -targets")
+	configFile := fs.String("config", "", "JSON file of named targets with their own count, interval, timeout and mode")
+	// parallel runs
+	concurrency := fs.Int("concurrency", 1, "probe up to this many targets in parallel")
+	fs.IntVar(concurrency, "parallel", 1, "same as -concurrency")
+	probeRate := fs.Float64("rate", 0, "send at most this many probes per second across all targets, 0 for no limit")
+	load := fs.Bool("load", false, "send -c probes to each udp target at -rate per second without waiting for replies and report how many were answered, for load and soak tests")
+	// loss over all targets, weighted per target
+	maxLoss := fs.Float64("max-loss", -1, "report the loss over all targets weighted by their targets file weight, healthy up to this percentage")
+	// per group aggregates
+	groupMaxLoss := fs.Float64("group-max-loss", -1, "report the loss of every targets file group and the -top slowest and lossiest targets, a group passing up to this percentage")
+	top := fs.Int("top", 5, "number of slowest and lossiest targets listed with -group-max-loss")
+	failOnLoss := fs.Float64("fail-on-loss", 0, "exit with status 1 when the loss over all probes is above this percentage; the default 0 fails on any lost probe, -1 only when every probe failed")
+	// output format
+	format := fs.String("format", formatJSON, "output format: json, ndjson, csv, text, table, or a text/template over each result such as '{{.Destination}} {{.RTTMs}}ms {{.State}}'")
+	fs.StringVar(format, "output", formatJSON, "same as -format")
+	stream := fs.Bool("stream", false, "shorthand for -format ndjson, print each result as a JSON line as soon as its probe completes")
+	changesOnly := fs.Bool("changes-only", false, "only output the results that change their target's state, answered to timed out and the like, and each target's first; implies -format ndjson over json")
+	stateFile := fs.String("state-file", "", "with -changes-only, keep the last state of every target in this file, so that scheduled runs only output what changed since the previous run")
+	// where streamed results go
+	resultsPath := fs.String("results-out", "stdout", "write results to stdout, stderr or the named file")
+	storePath := fs.String("store", "", "append every result with the time it completed to this ndjson file, for udping report to summarize later")
+	logSink := fs.String("log-sink", "", "also log every result, and alerts when a target goes down or recovers, with structured fields to syslog, journald or file:<path>")
+	appendResults := fs.Bool("append", false, "append to the -results-out file instead of truncating it")
+	var csvHeader optionalBool
+	fs.Var(&csvHeader, "csv-header", "write the csv column header row (default: true, false with -append)")
+	// json indentation, decided from the terminal unless given
+	var pretty optionalBool
+	fs.Var(&pretty, "pretty", "indent json output (default: only when writing to a terminal)")
+	// latency objective
+	slaRTT := fs.Duration("sla-rtt", 0, "report whether -sla-pct percent of probes were answered faster than this, e.g. 50ms")
+	slaPct := fs.Float64("sla-pct", 95, "percentage of successful probes that must be under -sla-rtt")
+	// loss or latency growing as probes keep coming
+	detectRateLimit := fs.Bool("detect-rate-limiting", false, "report targets whose later probes are lost or slowed much more than their early ones (heuristic)")
+	// one-way delay asymmetry in echo mode
+	asymmetryRatio := fs.Float64("asymmetry-ratio", 2, "in echo mode, warn when one direction's delay exceeds the other's by this factor")
+	// run as the echo server used by -mode echo
+	serveAddr := fs.String("serve", "", "run an HTTP API on this address, e.g. :8080, answering POST /probe with the results of the probes it describes; same as udping serve")
+	echoAddr := fs.String("echo", "", "run an echo server on this address, e.g. :9000, instead of probing; same as udping echo")
+	echoPlain := fs.Bool("echo-plain", false, "with -echo, return datagrams unchanged, without the receive timestamp echo mode needs")
+	expectEcho := fs.Bool("expect-echo", false, "in raw mode, fail udp probes whose reply is not the payload sent, as from a plain echo server, classing echoes with changed bytes as corrupted")
+	// pcap capture of probes and replies
+	pcapFile := fs.String("pcap", "", "write sent and received udp datagrams to this pcap file")
+	// echo server that also measures the probes it receives
+	reverseAddr := fs.String("reverse", "", "listen on this address, echo probes back and summarize them per client on interrupt")
+	// nat traversal testing, see nat.go
+	relayAddr := fs.String("relay", "", "run a relay on this address that reports mapped addresses and pairs -punch sessions")
+	punchRelay := fs.String("punch", "", "test udp hole punching with the peer joining the same -session on this relay")
+	session := fs.String("session", "udping", "session name shared by the two -punch peers")
+	punchTimeout := fs.Duration("punch-timeout", 30*time.Second, "give up -punch after this long")
+	// where aggregate reports go, so per-probe output can stay on its own stream
+	summaryPath := fs.String("summary-out", "stderr", "write aggregate reports to stdout, stderr or the named file")
+	// outage annotations for grafana dashboards
+	grafanaURL := fs.String("grafana-url", "", "post an annotation per outage to this Grafana instance")
+	grafanaToken := fs.String("grafana-token", os.Getenv("GRAFANA_TOKEN"), "Grafana API token, defaults to $GRAFANA_TOKEN")
+	grafanaFile := fs.String("grafana-annotations", "", "write outage annotations as JSON to this file")
+	// post results to a remote collector as they are produced
+	callbackURL := fs.String("results-callback-url", "", "POST each result to this URL as it completes")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "export each result as OpenTelemetry gauge data points to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	gelfEndpoint := fs.String("gelf-endpoint", "", "send each result as a GELF message to this Graylog input, udp://host:12201 or an http(s) URL")
+	// threshold alerts
+	alertLoss := fs.Float64("alert-loss", -1, "alert when a target's loss over -alert-window is above this percentage")
+	alertRTT := fs.Duration("alert-rtt", 0, "alert when a target's average RTT over -alert-window is above this, e.g. 100ms")
+	alertWindow := fs.Duration("alert-window", time.Minute, "rolling window the -alert-loss and -alert-rtt thresholds are judged over")
+	alertExec := fs.String("alert-exec", "", "run this shell command when a target crosses an alert threshold and when it is back under, with the target's window stats as JSON on its standard input")
+	alertWebhook := fs.String("alert-webhook", "", "POST the target's window stats as JSON to this URL when it crosses an alert threshold and when it is back under")
+	callbackBatch := fs.Int("callback-batch", 1, "max results per callback request")
+	// progress messages
+	quiet := fs.Bool("q", false, "quiet, only print results and reports")
+	verbose := fs.Bool("v", false, "verbose, also print resolved addresses, sockets and probe timings")
+	trace := fs.Bool("vv", false, "more verbose, also print every udp datagram sent and received in hex")
+	// Prometheus scrape endpoint
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics on this address under /metrics and 1m/5m/15m loss and rtt as JSON under /windows, e.g. :9100")
+	listen := fs.String("listen", "", "exporter mode: probe the targets until interrupted, serving Prometheus metrics on this address, e.g. :9123")
 
-	var ip string
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s [probe] [flags] <host>:<port>[,<port>|-<port>...] [<host>:<port>...]\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "       %s serve|echo|agent|report|traceroute ...    (see %s <command> -h)\n", os.Args[0], os.Args[0])
+		fs.PrintDefaults()
+	}
+	// flags may come before, between or after the targets
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
 
-	var portStr string
-	if i := strings.LastIndex(ipport, ":"); i > 0 {
-		portStr = ipport[i+1:]
-		ip = ipport[:i]
-	} else {
-		log.Println("Invalid address")
-		return
+	// results are indented for people reading them on stdout
+	indent := isTerminal(os.Stdout)
+	if pretty.set {
+		indent = pretty.value
+	}
+
+	if *serveAddr != "" {
+		if err := serveAPI(*serveAddr); err != nil {
+			log.Println(err)
+			return exitFailed
+		}
+		return exitOK
+	}
+
+	if *echoAddr != "" {
+		serve := udping.ServeEcho
+		if *echoPlain {
+			serve = udping.ServePlainEcho
+		}
+		if err := serve(*echoAddr); err != nil {
+			log.Println(err)
+			return exitFailed
+		}
+		return exitOK
+	}
+
+	if *reverseAddr != "" {
+		clients, err := serveReverse(*reverseAddr)
+		if err != nil {
+			log.Println(err)
+			if clients == nil {
+				// it never started listening
+				return exitFailed
+			}
+		}
+		fmt.Println(encodeJSON(clients, indent))
+		if err != nil {
+			return exitFailed
+		}
+		return exitOK
+	}
+
+	if *relayAddr != "" {
+		if err := serveRelay(*relayAddr); err != nil {
+			log.Println(err)
+			return exitFailed
+		}
+		return exitOK
+	}
+
+	if *punchRelay != "" {
+		rep := punch(*punchRelay, *session, *punchTimeout)
+		fmt.Println(encodeJSON(rep, indent))
+		if !rep.Bidirectional {
+			// logged as well, for scripts that only look at the exit status
+			log.Println(rep.Error)
+			return exitUnreachable
+		}
+		return exitOK
+	}
+
+	// targets from the command line, then from the targets file
+	var targets []target
+	for _, arg := range positional {
+		targets = append(targets, target{Addr: arg})
+	}
+	if *targetsFile != "" {
+		fileTargets, err := readTargets(*targetsFile)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		targets = append(targets, fileTargets...)
+	}
+	if *configFile != "" {
+		configTargets, err := readConfig(*configFile)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		targets = append(targets, configTargets...)
+	}
+	targets, scan, err := expandPorts(targets)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	if len(targets) == 0 {
+		log.Println("no targets given")
+		fs.Usage()
+		return exitUsage
+	}
+
+	if *allIPs {
+		targets = expandAddrs(targets, *ipv4Only, *ipv6Only || *strictIPv6, udping.NewResolver(*resolver))
+	}
+	if *dedupe {
+		var removed int
+		targets, removed = dedupeTargets(targets, *protocol, udping.NewResolver(*resolver))
+		if removed > 0 {
+			log.Printf("removed %d duplicate targets\n", removed)
+		}
+	}
+
+	var pairs [][]int
+	if *dualStack {
+		if *allIPs || *ipv4Only || *ipv6Only || *strictIPv6 || *fastest || *sourcesList != "" {
+			log.Println("-dual-stack picks the addresses itself and cannot be combined with -all-ips, -4, -6, -strict-ipv6-only, -fastest or -sources")
+			return exitUsage
+		}
+		targets, pairs = expandDualStack(targets, udping.NewResolver(*resolver))
+	}
+
+	var sources []string
+	if *sourcesList != "" {
+		var err error
+		if sources, err = parseSources(*sourcesList); err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		targets = expandSources(targets, sources)
+	}
+
+	if *compare {
+		if *dualStack || *sourcesList != "" {
+			log.Println("-compare cannot be combined with -dual-stack or -sources")
+			return exitUsage
+		}
+		if len(targets) != 2 {
+			log.Printf("-compare needs exactly two targets, got %d\n", len(targets))
+			return exitUsage
+		}
+		// both run at once, so that their probes go out interleaved
+		pairs = [][]int{{0, 1}}
+	}
+
+	// runs in a group go in parallel
+	group := 1
+	if len(sources) > 1 {
+		// the runs of one target, one per source, so that their results are comparable
+		group = len(sources)
+	}
+	if *listen != "" {
+		*metricsAddr = *listen
+		*continuous = true
+	}
+	*continuous = *continuous || *count == 0
+	if *continuous && len(targets) > 1 {
+		// every target runs until interrupted
+		group = len(targets)
+	}
+	if *failOnLoss > 100 {
+		log.Printf("loss threshold must be at most 100%%, got %v\n", *failOnLoss)
+		return exitUsage
+	}
+	if *concurrency < 1 {
+		log.Printf("concurrency must be at least 1, got %d\n", *concurrency)
+		return exitUsage
+	}
+	if *top < 0 {
+		log.Printf("-top must not be negative, got %d\n", *top)
+		return exitUsage
+	}
+	shortest := time.Duration(interval)
+	for _, t := range targets {
+		if t.Interval > 0 && t.Interval < shortest {
+			shortest = t.Interval
+		}
+	}
+	if *spacing > 0 {
+		// the spacing replaces every interval
+		shortest = *spacing
+	}
+	if shortest < minUserInterval && os.Geteuid() > 0 {
+		// flood protection, as in ping
+		log.Printf("intervals below %v are only allowed for root\n", minUserInterval)
+		return exitUsage
+	}
+	if timeout <= 0 {
+		// the library would take an unset timeout for its 5s default
+		log.Printf("-t must be positive, got %v\n", time.Duration(timeout))
+		return exitUsage
+	}
+	if *deadline < 0 || *targetDeadline < 0 {
+		log.Printf("deadlines must not be negative, got %v and %v\n", *deadline, *targetDeadline)
+		return exitUsage
+	}
+	if *probeRate < 0 {
+		log.Printf("rate must not be negative, got %v\n", *probeRate)
+		return exitUsage
+	}
+	payloadSet := false
+	fs.Visit(func(f *flag.Flag) { payloadSet = payloadSet || f.Name == "payload" })
+	if (payloadSet && (*payloadFile != "" || *pattern != "")) || (*payloadFile != "" && *pattern != "") {
+		log.Println("only one of -payload, -payload-file and -pattern may be given")
+		return exitUsage
+	}
+	if *payloadFile != "" {
+		b, err := os.ReadFile(*payloadFile)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		if len(b) == 0 {
+			log.Printf("payload file %s is empty\n", *payloadFile)
+			return exitUsage
+		}
+		// hex, so that the contents are sent without placeholders being filled in
+		*payload = "0x" + hex.EncodeToString(b)
+	}
+	if (group > 1 || *concurrency > 1 || pairs != nil) && *sourcePort != 0 {
+		// the runs in parallel would all bind the same port
+		log.Println("a source port cannot be combined with probing several targets or sources in parallel, -dual-stack and -compare included")
+		return exitUsage
+	}
+	if (group > 1 || *concurrency > 1) && *byteBudget != "" {
+		log.Println("a byte budget cannot be combined with probing several targets or sources in parallel")
+		return exitUsage
+	}
+
+	base := udping.Params{
+		Timeout:          time.Duration(timeout),
+		Count:            *count,
+		Continuous:       *continuous,
+		Protocol:         *protocol,
+		ProxyProtocol:    *proxyProtocol,
+		Proxy:            *proxy,
+		Payload:          *payload,
+		Mode:             *mode,
+		DNSName:          *dnsName,
+		SNMPCommunity:    *snmpCommunity,
+		AnyReply:         *anyReply,
+		WireGuardKey:     *wgKey,
+		DNSType:          *dnsTypeName,
+		Spacing:          *spacing,
+		Interval:         time.Duration(interval),
+		ResolveEach:      *resolveEach,
+		Reresolve:        *reresolve,
+		Resolver:         *resolver,
+		NoResolve:        *noResolve,
+		Fastest:          *fastest,
+		DialRetries:      *dialRetries,
+		Retries:          *retries,
+		Backoff:          *backoffStrategy,
+		BackoffBase:      *backoffBase,
+		BackoffCap:       *backoffCap,
+		EscalateTimeout:  *escalate,
+		EscalateStart:    *escalateStart,
+		AdaptiveTimeout:  *adaptive,
+		KernelTimestamps: *kernelStamps,
+		ReplyHeaders:     *replyHeaders,
+		Histogram:        *histogram,
+		ICMPSize:         *icmpSize,
+		ICMPPattern:      *icmpPattern,
+		Size:             *size,
+		Pattern:          *pattern,
+		ReuseSocket:      *reuseSocket,
+		Spray:            *spray,
+		Burst:            *burst,
+		RefusalFails:     *refusalFails,
+		SuccessPolicy:    *successPolicy,
+		SourcePort:       *sourcePort,
+		Interface:        *iface,
+		Randomize:        *randomize,
+		Correlate:        *correlate,
+		Sequence:         *sequence,
+		ExpectLen:        *expectLen,
+		ExpectLenMin:     *expectLenMin,
+		Expect:           *expect,
+		ExpectEcho:       *expectEcho,
+		TTL:              *ttl,
+		DSCP:             *dscp,
+		TOS:              *tos,
+		DontFragment:     *dontFragment,
+		RecvBuffer:       *rcvbuf,
+		SendBuffer:       *sndbuf,
+		ReplyDump:        *replyDump,
+		ReplyEncoding:    *dumpEncoding,
+		ReverseLookup:    !*numeric,
+		IPv4Only:         *ipv4Only,
+		IPv6Only:         *ipv6Only || *strictIPv6,
+	}
+	if *stream {
+		if *format != formatJSON && *format != formatNDJSON {
+			log.Printf("-stream cannot be combined with -format %s\n", *format)
+			return exitUsage
+		}
+		*format = formatNDJSON
+	}
+	if *changesOnly && *format == formatJSON {
+		// a report of every result would hide the changes
+		*format = formatNDJSON
+	}
+	if *stateFile != "" && !*changesOnly {
+		log.Println("-state-file needs -changes-only")
+		return exitUsage
+	}
+	if err := validFormat(*format); err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+
+	if *route && (*protocol != "udp" || *ttl != 0) {
+		log.Println("-traceroute needs -p udp and sets the TTL itself, drop -ttl")
+		return exitUsage
+	}
+	if *mtuDiscover && (*protocol != "udp" || (*mode != "" && *mode != udping.ModeRaw) || *route) {
+		log.Println("-mtu-discover needs -p udp in raw mode and cannot be combined with -traceroute")
+		return exitUsage
+	}
+	if *load && (*probeRate <= 0 || *protocol != "udp" || (*mode != "" && *mode != udping.ModeRaw) || *route || *mtuDiscover) {
+		log.Println("-load needs -rate and -p udp in raw mode, and cannot be combined with -traceroute or -mtu-discover")
+		return exitUsage
+	}
+	if *load && *probeRate > float64(time.Second/minUserInterval) && os.Geteuid() > 0 {
+		// flood protection, as for -i
+		log.Printf("load rates above %d per second are only allowed for root\n", time.Second/minUserInterval)
+		return exitUsage
+	}
+	if *route && (*maxHops < 1 || *maxHops > 255) {
+		log.Printf("-max-hops must be within 1..255, got %d\n", *maxHops)
+		return exitUsage
+	}
+	if *quiet && (*verbose || *trace) {
+		log.Println("-q and -v cannot be combined")
+		return exitUsage
+	}
+	// progress messages go to stderr, keeping stdout for results
+	progress := progressLogger{w: os.Stderr, level: levelNormal}
+	if *quiet || (*format == formatText && !*verbose && !*trace) {
+		// the text format already has a line per probe
+		progress.level = levelQuiet
+	} else if *trace {
+		progress.level = levelTrace
+	} else if *verbose {
+		progress.level = levelVerbose
+	}
+
+	resultsOut, closeResults, err := openSink(*resultsPath, *appendResults)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	defer closeResults()
+
+	var hooks []func(res udping.Result)
+	var changes *changeFilter
+	if *changesOnly {
+		if changes, err = newChangeFilter(*stateFile); err != nil {
+			log.Printf("reading -state-file: %v\n", err)
+			return exitUsage
+		}
+		defer func() {
+			if err := changes.save(); err != nil {
+				log.Printf("saving -state-file: %v\n", err)
+			}
+		}()
+	}
+	if streamed(*format) {
+		// stream results instead of holding them all in memory
+		if *format == formatCSV {
+			// rows appended to an existing file already follow a header
+			header := !*appendResults
+			if csvHeader.set {
+				header = csvHeader.value
+			}
+			hooks = append(hooks, csvWriter(resultsOut, header))
+		} else if *format == formatText {
+			hooks = append(hooks, textWriter(resultsOut))
+		} else if *format == formatTable {
+			hook, flush := tableWriter(resultsOut)
+			hooks = append(hooks, hook)
+			defer flush()
+		} else if isTemplate(*format) {
+			hook, err := templateWriter(resultsOut, *format)
+			if err != nil {
+				log.Println(err)
+				return exitUsage
+			}
+			hooks = append(hooks, hook)
+		} else {
+			hooks = append(hooks, ndjsonWriter(resultsOut))
+		}
+		if changes != nil {
+			// the results output is the only hook filtered, exporters still see every result
+			hooks[len(hooks)-1] = changes.wrap(hooks[len(hooks)-1])
+		}
+	}
+	if *storePath != "" {
+		store, closeStore, err := openSink(*storePath, true)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		defer closeStore()
+		hooks = append(hooks, storeWriter(store))
+	}
+	if *logSink != "" {
+		hook, closeSink, err := newLogSink(*logSink)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		defer closeSink()
+		hooks = append(hooks, hook)
+	}
+	if *alertExec != "" || *alertWebhook != "" {
+		if *alertLoss < 0 && *alertRTT <= 0 {
+			log.Println("-alert-exec and -alert-webhook need -alert-loss or -alert-rtt")
+			return exitUsage
+		}
+		if *alertWindow <= 0 {
+			log.Printf("-alert-window must be positive, got %v\n", *alertWindow)
+			return exitUsage
+		}
+		alerts := newAlerter(*alertLoss, *alertRTT, *alertWindow, *alertExec, *alertWebhook)
+		hooks = append(hooks, alerts.Add)
+		defer alerts.Close()
+	}
+	if *otlpEndpoint != "" {
+		exporter := newOTLPExporter(*otlpEndpoint)
+		hooks = append(hooks, exporter.Send)
+		defer exporter.Close()
+	}
+	if *gelfEndpoint != "" {
+		hook, closeGELF, err := newGELFExporter(*gelfEndpoint)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		hooks = append(hooks, hook)
+		defer closeGELF()
+	}
+	if *callbackURL != "" {
+		poster := newCallbackPoster(*callbackURL, *callbackBatch)
+		hooks = append(hooks, poster.Send)
+		defer poster.Close()
+	}
+	var sla *slaTracker
+	if *slaRTT > 0 {
+		sla = newSLATracker(*slaRTT, *slaPct)
+		hooks = append(hooks, sla.Add)
+	}
+	var asym *asymmetryTracker
+	if *mode == udping.ModeEcho {
+		asym = newAsymmetryTracker(*asymmetryRatio)
+		hooks = append(hooks, asym.Add)
 	}
 
-	port, err := strconv.ParseInt(portStr, 10, 64)
+	summaryOut, closeSummary, err := openSink(*summaryPath, false)
 	if err != nil {
-		panic(err)
+		log.Println(err)
+		return exitUsage
+	}
+	defer closeSummary()
+
+	var capture *udping.PcapWriter
+	if *pcapFile != "" {
+		if capture, err = udping.NewPcapWriter(*pcapFile); err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		defer capture.Close()
+	}
+
+	var ports *portTracker
+	if scan {
+		ports = newPortTracker()
+		hooks = append(hooks, ports.Add)
+	}
+
+	var sprayed *sprayTracker
+	if *spray > 1 {
+		sprayed = newSprayTracker()
+		hooks = append(hooks, sprayed.Add)
+	}
+
+	var limits *rateLimitTracker
+	if *detectRateLimit {
+		limits = newRateLimitTracker()
+		hooks = append(hooks, limits.Add)
+	}
+
+	if *metricsAddr != "" {
+		metrics := newMetricsCollector()
+		if err := serveMetrics(*metricsAddr, metrics); err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		hooks = append(hooks, metrics.Add)
 	}
 
-	params := params{
-		Destination:     ip,
-		DestinationPort: int(port),
-		Timeout:         *timeout,
-		Count:           *count,
-		Protocol:        "udp",
+	var states *stateTracker
+	if *grafanaURL != "" || *grafanaFile != "" {
+		states = newStateTracker()
+		hooks = append(hooks, states.Add)
 	}
-	res := make([]result, *count)
-	// new runner
-	r := &run{
-		Parameters: params,
-		Results:    res,
+
+	// runs from several sources report concurrently
+	var hooksMu sync.Mutex
+	onResult := func(res udping.Result) {
+		hooksMu.Lock()
+		defer hooksMu.Unlock()
+		for _, hook := range hooks {
+			hook(res)
+		}
+	}
+
+	var budget *budgetReport
+	if *byteBudget != "" {
+		n, err := parseByteSize(*byteBudget)
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		budget = &budgetReport{Budget: n, shared: udping.NewBudget(n)}
+	}
+
+	var limiter *rate.Limiter
+	if *probeRate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(*probeRate), 1)
 	}
 
-	// run
-	if err := r.Run(); err != nil {
-		panic(err)
+	// on interrupt, the probe in flight is dropped and what was gathered so far is still printed
+	interrupted, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// a second interrupt kills the process, should printing and cleanup hang
+		<-interrupted.Done()
+		stop()
+	}()
+	// the deadline ends the run the same way, like ping -w
+	ctx := interrupted
+	if *deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(interrupted, *deadline)
+		defer cancel()
 	}
 
+	start := time.Now()
+	if *mtuDiscover {
+		// sizes instead of a ping run
+		var reports []mtuReport
+		for _, t := range targets {
+			params := base
+			host, port, err := splitHostPort(t.Addr)
+			if err != nil {
+				log.Println(err)
+				return exitUsage
+			}
+			params.Destination, params.DestinationPort, params.IP, params.Source = host, port, t.IP, t.Source
+			if t.Timeout > 0 {
+				params.Timeout = t.Timeout
+			}
+			fmt.Fprintf(progress.w, "path mtu discovery to %s\n", t.Addr)
+			rep := discoverMTU(ctx, params, progress.w)
+			if rep.PathMTU > 0 {
+				fmt.Fprintf(progress.w, "path mtu to %s is %d bytes, %d bytes of udp payload\n", t.Addr, rep.PathMTU, rep.LargestPayload)
+			}
+			reports = append(reports, rep)
+		}
+		rep := newReport(start, base)
+		rep.MTU = reports
+		fmt.Fprintln(resultsOut, encodeJSON(rep, indent))
+		code := exitOK
+		for _, rep := range reports {
+			if rep.PathMTU == 0 {
+				code = exitUnreachable
+			}
+		}
+		return code
+	}
+
+	if *load {
+		// counts at a fixed rate instead of a ping run
+		reports := loadTargets(ctx, base, targets, *probeRate, progress)
+		rep := newReport(start, base)
+		rep.Load = reports
+		fmt.Fprintln(resultsOut, encodeJSON(rep, indent))
+		code := exitOK
+		var sent, answered int
+		for _, rep := range reports {
+			sent += rep.Sent
+			answered += rep.Answered
+			if rep.invalid {
+				return exitUsage
+			}
+		}
+		if answered == 0 {
+			code = exitUnreachable
+		} else if loss := 100 * float64(sent-answered) / float64(sent); *failOnLoss >= 0 && loss > *failOnLoss {
+			log.Printf("loss of %.1f%% is above %.1f%%\n", loss, *failOnLoss)
+			code = exitUnreachable
+		}
+		return code
+	}
+
+	if *route {
+		// hop by hop instead of a ping run
+		var reports []traceReport
+		for _, t := range targets {
+			params := base
+			host, port, err := splitHostPort(t.Addr)
+			if err != nil {
+				log.Println(err)
+				return exitUsage
+			}
+			params.Destination, params.DestinationPort, params.IP, params.Source = host, port, t.IP, t.Source
+			if t.Timeout > 0 {
+				params.Timeout = t.Timeout
+			}
+			if t.Mode != "" {
+				params.Mode = t.Mode
+			}
+			fmt.Fprintf(progress.w, "traceroute to %s, %d hops max\n", t.Addr, *maxHops)
+			reports = append(reports, traceroute(ctx, params, *maxHops, progress.w))
+		}
+		rep := newReport(start, base)
+		rep.Traceroute = reports
+		fmt.Fprintln(resultsOut, encodeJSON(rep, indent))
+		code := exitOK
+		for _, rep := range reports {
+			if rep.Error != "" {
+				code = exitUsage
+			} else if !rep.Reached && code == exitOK {
+				code = exitUnreachable
+			}
+		}
+		return code
+	}
+
+	probe := func(t target) targetResult {
+		tr := targetResult{Name: t.Name, Group: t.Group, Target: t.Addr, IP: t.IP, Source: t.Source, weight: t.Weight}
+		// targets that cannot run still get a summary
+		tr.Summary = udping.New(udping.Params{}).Summary()
+		if interrupted.Err() != nil {
+			tr.Error = "interrupted"
+			return tr
+		}
+		if ctx.Err() != nil {
+			tr.Error = "deadline reached"
+			return tr
+		}
+		if budget != nil && (budget.shared.Exhausted() || budget.shared.Remaining() <= 0) {
+			tr.Error = udping.ErrByteBudget.Error()
+			return tr
+		}
+
+		params := base
+		if t.Count > 0 {
+			params.Count = t.Count
+		}
+		if t.Interval > 0 {
+			params.Interval = t.Interval
+		}
+		if t.Timeout > 0 {
+			params.Timeout = t.Timeout
+		}
+		if t.Mode != "" {
+			params.Mode = t.Mode
+		}
+		host, port, err := splitHostPort(t.Addr)
+		if *protocol == "icmp" && err != nil {
+			// icmp targets are plain hosts
+			host, port, err = t.Addr, 0, nil
+		}
+		if err != nil {
+			log.Println(err)
+			tr.Error = err.Error()
+			return tr
+		}
+		params.Destination = host
+		params.DestinationPort = port
+		params.IP = t.IP
+		params.Source = t.Source
+		if budget != nil {
+			// the budget covers all targets, each run gets what is left
+			params.ByteBudget = budget.shared.Remaining()
+		}
+
+		// new runner
+		r := udping.New(params)
+		// stream results instead of holding them all in memory
+		// an exporter runs for good, its results only live on in the metrics
+		r.Discard = streamed(*format) || *listen != ""
+		r.Capture = capture
+		r.Limiter = limiter
+		if budget != nil {
+			// the members of a -dual-stack or -compare pair run side by side and draw from it together
+			r.Budget = budget.shared
+		}
+		r.Logf = progress.Infof
+		r.Debugf = progress.Debugf
+		r.Tracef = progress.Tracef
+		if !r.Discard && params.Continuous {
+			log.Printf("warning: buffering every result in memory until interrupted, consider -format %s\n", formatNDJSON)
+		} else if !r.Discard && params.Count > largeCount {
+			log.Printf("warning: buffering %d results in memory, consider -format %s\n", params.Count, formatNDJSON)
+		}
+		r.OnResult = func(res udping.Result) {
+			tr.sent++
+			if *compare {
+				rtt := -1.0
+				if res.Success && res.Error == "" {
+					rtt = res.RTT
+				}
+				tr.rtts = append(tr.rtts, rtt)
+			}
+			if res.Success {
+				tr.succeeded++
+				if res.ReceivedAt != nil && tr.firstAnswer.IsZero() {
+					tr.firstAnswer = *res.ReceivedAt
+				}
+			}
+			onResult(res)
+		}
+
+		// run, within the target's own deadline
+		runCtx := ctx
+		if d := *targetDeadline; d > 0 || t.Deadline > 0 {
+			if t.Deadline > 0 {
+				d = t.Deadline
+			}
+			var cancel context.CancelFunc
+			runCtx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		if err := r.RunContext(runCtx); err != nil {
+			log.Println(err)
+			tr.Error = err.Error()
+			tr.invalid = errors.Is(err, udping.ErrInvalidParameters)
+		} else if runCtx.Err() != nil && interrupted.Err() == nil && !params.Continuous && tr.sent < params.Count {
+			tr.Error = "deadline reached"
+			if ctx.Err() == nil {
+				// cut short by its own deadline while the run goes on
+				tr.Error = "target deadline reached"
+			}
+		}
+		warnClampedBuffers(t.Addr, r)
+		tr.params = r.Parameters
+		tr.Selection = r.Selection
+		tr.Results = r.Results
+		tr.Summary = r.Summary()
+		return tr
+	}
+
+	// a panic in one run is reported on its target instead of taking down the others
+	safeProbe := func(t target) (tr targetResult) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("%s: panic: %v\n", t.Addr, p)
+				tr = targetResult{Name: t.Name, Group: t.Group, Target: t.Addr, IP: t.IP, Source: t.Source, Error: fmt.Sprintf("panic: %v", p), weight: t.Weight}
+				tr.Summary = udping.New(udping.Params{}).Summary()
+			}
+		}()
+		return probe(t)
+	}
+
+	// up to -concurrency groups run at once, each result goes in its target's slot so the output keeps the target order
+	runs := make([]targetResult, len(targets))
+	workers := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	batches := pairs
+	if batches == nil || group > 1 {
+		batches = batchTargets(len(targets), group)
+	}
+	for _, batch := range batches {
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(batch []int) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			var members sync.WaitGroup
+			for _, j := range batch {
+				members.Add(1)
+				go func(j int) {
+					defer members.Done()
+					runs[j] = safeProbe(targets[j])
+				}(j)
+			}
+			members.Wait()
+		}(batch)
+	}
+	wg.Wait()
+
 	// print results
-	println(prettyPrint(r.Results))
+	if *format == formatJSON {
+		fmt.Fprintln(resultsOut, encodeJSON(newRunReport(start, base, runs), indent))
+	} else if len(runs) == 1 && runs[0].Selection != nil {
+		fmt.Fprintln(summaryOut, encodeJSON(runs[0].Selection, indent))
+	}
+
+	// closing statistics, like ping's
+	if *format == formatText {
+		for _, tr := range runs {
+			writeTextStats(summaryOut, targetStats{Name: tr.Name, Group: tr.Group, Target: tr.Target, IP: tr.IP, Source: tr.Source, Summary: tr.Summary})
+		}
+	} else if len(runs) == 1 {
+		fmt.Fprintln(summaryOut, encodeJSON(runs[0].Summary, indent))
+	} else {
+		summaries := make([]targetStats, 0, len(runs))
+		for _, tr := range runs {
+			summaries = append(summaries, targetStats{Name: tr.Name, Group: tr.Group, Target: tr.Target, IP: tr.IP, Source: tr.Source, Summary: tr.Summary})
+		}
+		fmt.Fprintln(summaryOut, encodeJSON(summaries, indent))
+	}
+
+	if asym != nil {
+		report := asym.Report()
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if report.Asymmetric {
+			log.Printf("warning: path looks asymmetric, one direction is %.1fx slower than the other\n", report.Ratio)
+		}
+	}
+
+	if ports != nil {
+		fmt.Fprintln(summaryOut, encodeJSON(ports.Report(), indent))
+	}
 
+	if sprayed != nil {
+		report := sprayed.Report()
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if report.Suspected {
+			log.Println("warning: some source ports fared worse than others, one of the equal cost paths may be faulty")
+		}
+	}
+
+	if limits != nil {
+		report := limits.Report()
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if report.Suspected {
+			log.Println("warning: some targets look rate limited, see the rate limiting report")
+		}
+	}
+
+	if *maxLoss >= 0 {
+		report := newHealthReport(runs, *maxLoss)
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if !report.Healthy {
+			log.Printf("warning: weighted loss of %.1f%% is above %.1f%%\n", report.WeightedLoss, *maxLoss)
+		}
+	}
+
+	if *groupMaxLoss >= 0 {
+		report := newGroupReport(runs, *groupMaxLoss, *top)
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if !report.Pass {
+			log.Println("warning: some groups lost more probes than -group-max-loss allows, see the group report")
+		}
+	}
+
+	if states != nil {
+		annotations := states.annotations()
+		if *grafanaFile != "" {
+			if err := writeAnnotations(*grafanaFile, annotations); err != nil {
+				log.Println(err)
+			}
+		}
+		if *grafanaURL != "" {
+			if err := postAnnotations(*grafanaURL, *grafanaToken, annotations); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+
+	if budget != nil {
+		budget.Sent = budget.shared.Spent()
+		budget.Exhausted = budget.shared.Exhausted()
+		for _, tr := range runs {
+			// a target skipped because nothing was left
+			budget.Exhausted = budget.Exhausted || tr.Error == udping.ErrByteBudget.Error()
+		}
+		fmt.Fprintln(summaryOut, encodeJSON(budget, indent))
+	}
+
+	code := exitUnreachable
+	for _, tr := range runs {
+		if tr.succeeded > 0 {
+			code = exitOK
+			break
+		}
+	}
+	if *failOnLoss >= 0 && code == exitOK {
+		var sent, received int
+		for _, tr := range runs {
+			sent += tr.Summary.Sent
+			received += tr.Summary.Received
+		}
+		if sent > 0 {
+			if loss := 100 * float64(sent-received) / float64(sent); loss > *failOnLoss {
+				log.Printf("loss of %.1f%% is above %.1f%%\n", loss, *failOnLoss)
+				code = exitUnreachable
+			}
+		}
+	}
+	for _, tr := range runs {
+		if tr.invalid {
+			code = exitUsage
+		}
+	}
+	if *requireAllIPs {
+		report := newAddrReport(runs)
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if !report.AllUp {
+			code = exitAddrDown
+		}
+	}
+
+	if *dualStack {
+		report := newDualStackReport(runs, pairs)
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if !report.Healthy {
+			code = exitAddrDown
+		}
+	}
+
+	if *compare {
+		fmt.Fprintln(summaryOut, encodeJSON(newCompareReport(runs[0], runs[1]), indent))
+	}
+
+	if sla != nil {
+		report := sla.Report()
+		fmt.Fprintln(summaryOut, encodeJSON(report, indent))
+		if !report.Met && (code == exitOK || code == exitUnreachable) {
+			// invalid targets and down addresses take precedence
+			code = exitSLAFailed
+		}
+	}
+
+	// interrupting is how a continuous run ends, for any other run it cut the results short
+	if interrupted.Err() != nil && !*continuous && code == exitOK {
+		return exitInterrupted
+	}
+	return code
+}
+
+// warnClampedBuffers warns when the kernel applied smaller socket buffers to
+// the run of target than -rcvbuf or -sndbuf asked for, replies then being
+// dropped sooner than expected
+func warnClampedBuffers(target string, r *udping.Runner) {
+	if r.BuffersClamped {
+		log.Printf("warning: %s: the kernel capped the socket buffers at %d bytes to receive and %d to send, raise net.core.rmem_max and wmem_max for more\n", target, r.RecvBufferSize, r.SendBufferSize)
+	}
 }
 
 func prettyPrint(i interface{}) string {
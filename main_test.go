@@ -0,0 +1,301 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// echoServer runs a plain udp echo server on a free loopback port, closed
+// when the test ends, and returns its port
+func echoServer(t *testing.T) int {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestParseInterspersed(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		args       []string
+		positional []string
+		count      int
+		protocol   string
+		verbose    bool
+	}{
+		{"flags first", []string{"-c", "5", "a:1", "b:2"}, []string{"a:1", "b:2"}, 5, "udp", false},
+		{"flags after positionals", []string{"a:1", "-c", "5", "b:2", "-p", "tcp"}, []string{"a:1", "b:2"}, 5, "tcp", false},
+		{"flag values are not positionals", []string{"-p", "tcp", "-c=2", "a:1"}, []string{"a:1"}, 2, "tcp", false},
+		{"bool flag before a positional", []string{"-v", "a:1"}, []string{"a:1"}, 3, "udp", true},
+		{"double dash", []string{"a:1", "--", "-c", "5"}, []string{"a:1", "-c", "5"}, 3, "udp", false},
+		{"double dash after flags", []string{"-c", "4", "--", "-v"}, []string{"-v"}, 4, "udp", false},
+		{"no positionals", []string{"-v"}, nil, 3, "udp", true},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		count := fs.Int("c", 3, "")
+		protocol := fs.String("p", "udp", "")
+		verbose := fs.Bool("v", false, "")
+		positional, err := parseInterspersed(fs, tc.args)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(positional, tc.positional) {
+			t.Errorf("%s: positional %q, want %q", tc.name, positional, tc.positional)
+		}
+		if *count != tc.count || *protocol != tc.protocol || *verbose != tc.verbose {
+			t.Errorf("%s: -c %d -p %s -v %v, want -c %d -p %s -v %v", tc.name, *count, *protocol, *verbose, tc.count, tc.protocol, tc.verbose)
+		}
+	}
+}
+
+func TestParseInterspersedErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"a:1", "-c"},        // a value flag at the end, missing its value
+		{"a:1", "-c", "b:2"}, // the positional after it is taken for its value
+		{"-nope", "a:1"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		fs.Int("c", 3, "")
+		if _, err := parseInterspersed(fs, args); err == nil {
+			t.Errorf("%q parsed", args)
+		}
+	}
+}
+
+func TestSplitPorts(t *testing.T) {
+	for _, tc := range []struct {
+		addr  string
+		host  string
+		ports []int
+		err   string
+	}{
+		{"h:53", "h", []int{53}, ""},
+		{"h:53,123,5000-5002", "h", []int{53, 123, 5000, 5001, 5002}, ""},
+		{"[::1]:1-2", "::1", []int{1, 2}, ""},
+		{"h:7,5-8,7", "h", []int{7, 5, 6, 8}, ""},
+		{"h:10-10", "h", []int{10}, ""},
+		{"h:10-5", "", nil, "invalid port range"},
+		{"h:5-", "", nil, "invalid port range"},
+		{"h:-5", "", nil, "invalid port"},
+		{"h:53,,54", "", nil, "invalid port"},
+		{"h:70000", "", nil, "invalid port"},
+		{"h:1-70000", "", nil, "invalid port range"},
+		{"h:dns", "", nil, "invalid port"},
+		{":53", "", nil, "invalid address"},
+		{"h", "", nil, "invalid address"},
+	} {
+		host, ports, err := splitPorts(tc.addr)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.addr, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || host != tc.host || !reflect.DeepEqual(ports, tc.ports) {
+			t.Errorf("%s: %s %v %v, want %s %v", tc.addr, host, ports, err, tc.host, tc.ports)
+		}
+	}
+}
+
+func TestExpandPorts(t *testing.T) {
+	targets, expanded, err := expandPorts([]target{{Addr: "a:1"}, {Addr: "b:2,3", Count: 2}})
+	if err != nil || !expanded {
+		t.Fatalf("expanded %v, error %v", expanded, err)
+	}
+	want := []target{{Addr: "a:1"}, {Addr: "b:2", Count: 2}, {Addr: "b:3", Count: 2}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets %+v, want %+v", targets, want)
+	}
+	if _, _, err := expandPorts([]target{{Addr: "b:3-2"}}); err == nil {
+		t.Error("a reversed range expanded")
+	}
+}
+
+func TestParseTargetLine(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want target
+		err  string
+	}{
+		{"a:1", target{Addr: "a:1"}, ""},
+		{"a:1 5 weight=2 group=dc1", target{Addr: "a:1", Count: 5, Weight: 2, Group: "dc1"}, ""},
+		{"a:1,2 group=x 3", target{Addr: "a:1,2", Count: 3, Group: "x"}, ""},
+		{"a", target{}, "invalid address"},
+		{"a:2-1", target{}, "invalid port range"},
+		{"a:1 0", target{}, "invalid count"},
+		{"a:1 five", target{}, "invalid count"},
+		{"a:1 2 3", target{}, "invalid count"},
+		{"a:1 weight=0", target{}, "invalid weight"},
+		{"a:1 weight=x", target{}, "invalid weight"},
+		{"a:1 group=", target{}, "invalid group"},
+		{"a:1 group=a group=b", target{}, "invalid group"},
+		{"a:1 1 weight=1 group=g extra", target{}, "expected"},
+	} {
+		got, err := parseTargetLine(tc.line)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%q: error %v, want %q", tc.line, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q: %+v %v, want %+v", tc.line, got, err, tc.want)
+		}
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	content := "# comment\n\na:1 2\nnot-an-address\n  b:2  \nc:3 weight=-1\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	// malformed lines are skipped, the others kept
+	targets, err := readTargets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []target{{Addr: "a:1", Count: 2}, {Addr: "b:2"}}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("targets %+v, want %+v", targets, want)
+	}
+	if _, err := readTargets(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing targets file was read")
+	}
+}
+
+func TestSourcePortInParallel(t *testing.T) {
+	for _, args := range [][]string{
+		{"-compare", "-sport", "40000", "127.0.0.1:9", "127.0.0.1:10"},
+		{"-dual-stack", "-sport", "40000", "localhost:9"},
+	} {
+		// refused before any socket binds the port twice
+		if code := probeCommand(args); code != exitUsage {
+			t.Errorf("%v: exit code %d, want %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	echo := strconv.Itoa(echoServer(t))
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	lost := strconv.Itoa(silent.LocalAddr().(*net.UDPAddr).Port)
+	config := filepath.Join(t.TempDir(), "config.json")
+	doc := `{"targets":[{"addr":"127.0.0.1:` + echo + `"},{"addr":"127.0.0.1:` + echo + `","mode":"bogus"}]}`
+	if err := os.WriteFile(config, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"127.0.0.1:" + echo}, exitOK},
+		{[]string{"127.0.0.1:" + echo, "127.0.0.1:" + lost}, exitUnreachable},
+		{[]string{"-fail-on-loss", "60", "127.0.0.1:" + echo, "127.0.0.1:" + lost}, exitOK},
+		{[]string{"-fail-on-loss", "-1", "127.0.0.1:" + echo, "127.0.0.1:" + lost}, exitOK},
+		{[]string{"-fail-on-loss", "-1", "127.0.0.1:" + lost}, exitUnreachable},
+		{[]string{"-fail-on-loss", "101", "127.0.0.1:" + echo}, exitUsage},
+		{[]string{"-sla-rtt", "1ns", "127.0.0.1:" + echo}, exitSLAFailed},
+		// an invalid target outranks the missed objective
+		{[]string{"-sla-rtt", "1ns", "-config", config}, exitUsage},
+	} {
+		args := append([]string{"-q", "-c", "2", "-i", "200ms", "-t", "100ms"}, tc.args...)
+		if code := probeCommand(args); code != tc.code {
+			t.Errorf("%v: exit code %d, want %d", tc.args, code, tc.code)
+		}
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	// the echo server holds the port, so every server below fails to listen
+	busy := "127.0.0.1:" + strconv.Itoa(echoServer(t))
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, args := range [][]string{
+		{"-echo", busy},
+		{"-reverse", busy},
+		{"-relay", busy},
+		{"echo", busy},
+	} {
+		if code := realMainArgs(args); code != exitFailed {
+			t.Errorf("%v: exit code %d, want %d", args, code, exitFailed)
+		}
+	}
+}
+
+// realMainArgs runs realMain with args as the command line
+func realMainArgs(args []string) int {
+	saved := os.Args
+	defer func() { os.Args = saved }()
+	os.Args = append([]string{"udping"}, args...)
+	return realMain()
+}
+
+func TestPunchFailure(t *testing.T) {
+	// a relay that never answers
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	if code := probeCommand([]string{"-punch", silent.LocalAddr().String(), "-punch-timeout", "300ms"}); code != exitUnreachable {
+		t.Errorf("no relay: exit code %d, want %d", code, exitUnreachable)
+	}
+
+	// two peers on loopback, no NAT in between, always get through
+	relay := closedUDPAddr(t)
+	go serveRelay(relay)
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() { codes <- probeCommand([]string{"-punch", relay, "-session", "test", "-punch-timeout", "5s"}) }()
+	}
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != exitOK {
+			t.Errorf("peer: exit code %d, want %d", code, exitOK)
+		}
+	}
+}
+
+// closedUDPAddr returns a loopback address with a free udp port
+func closedUDPAddr(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	return pc.LocalAddr().String()
+}
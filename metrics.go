@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// labelEscaper escapes label values as the text exposition format requires
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsBuckets are the upper bounds, in seconds, of the RTT histogram buckets
+var metricsBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type (
+	// targetMetrics are the counters of one target
+	targetMetrics struct {
+		probes      int
+		failures    map[string]int // failures by error type
+		buckets     []int          // successful probes per RTT bucket, not cumulative
+		rttCount    int
+		rttSum      float64
+		received    int       // probes that succeeded
+		lastSuccess time.Time // completion of the latest successful probe
+		history     history   // latest results, for the rolling windows
+	}
+
+	// metricsCollector holds the Prometheus metrics of a run, updated as
+	// results arrive and served in the text exposition format
+	metricsCollector struct {
+		mu      sync.Mutex
+		targets map[string]*targetMetrics
+	}
+)
+
+func newMetricsCollector() *metricsCollector {
+	return &metricsCollector{targets: map[string]*targetMetrics{}}
+}
+
+// errorType returns the metric label of a result's error
+func errorType(res udping.Result) string {
+	switch res.Class {
+	case udping.ClassTimeout:
+		return "timeout"
+	case udping.ClassRefused:
+		return "connrefused"
+	case udping.ClassCorrupted:
+		return "corrupted"
+	}
+	return "other"
+}
+
+// Add counts a result. Every result with an error counts as a failure of
+// that type, including refusals that still count as reachable.
+func (m *metricsCollector) Add(res udping.Result) {
+	key := net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.targets[key]
+	if t == nil {
+		t = &targetMetrics{failures: map[string]int{}, buckets: make([]int, len(metricsBuckets))}
+		m.targets[key] = t
+	}
+	now := time.Now()
+	t.probes++
+	t.history.add(res, now)
+	if res.Success {
+		t.received++
+		t.lastSuccess = now
+	}
+	if res.Error != "" {
+		t.failures[errorType(res)]++
+	}
+	if res.Success && res.Error == "" && res.RTT > 0 {
+		t.rttCount++
+		t.rttSum += res.RTT
+		if i := sort.SearchFloat64s(metricsBuckets, res.RTT); i < len(metricsBuckets) {
+			t.buckets[i]++
+		}
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *metricsCollector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metricsCollector) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]string, 0, len(m.targets))
+	for k := range m.targets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	labels := make(map[string]string, len(keys))
+	for _, k := range keys {
+		labels[k] = labelEscaper.Replace(k)
+	}
+
+	fmt.Fprintln(w, "# HELP udping_probes_total Probes sent.")
+	fmt.Fprintln(w, "# TYPE udping_probes_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "udping_probes_total{target=\"%s\"} %d\n", labels[k], m.targets[k].probes)
+	}
+
+	fmt.Fprintln(w, "# HELP udping_probe_failures_total Probes that got an error, by error type.")
+	fmt.Fprintln(w, "# TYPE udping_probe_failures_total counter")
+	for _, k := range keys {
+		for _, typ := range []string{"timeout", "connrefused", "corrupted", "other"} {
+			fmt.Fprintf(w, "udping_probe_failures_total{target=\"%s\",error=\"%s\"} %d\n", labels[k], typ, m.targets[k].failures[typ])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP udping_loss_ratio Share of probes that failed, from 0 to 1.")
+	fmt.Fprintln(w, "# TYPE udping_loss_ratio gauge")
+	for _, k := range keys {
+		t := m.targets[k]
+		fmt.Fprintf(w, "udping_loss_ratio{target=\"%s\"} %s\n", labels[k], strconv.FormatFloat(float64(t.probes-t.received)/float64(t.probes), 'g', -1, 64))
+	}
+
+	fmt.Fprintln(w, "# HELP udping_last_success_timestamp_seconds Unix time of the latest successful probe, 0 before the first.")
+	fmt.Fprintln(w, "# TYPE udping_last_success_timestamp_seconds gauge")
+	for _, k := range keys {
+		var ts float64
+		if t := m.targets[k]; !t.lastSuccess.IsZero() {
+			ts = float64(t.lastSuccess.UnixNano()) / 1e9
+		}
+		fmt.Fprintf(w, "udping_last_success_timestamp_seconds{target=\"%s\"} %s\n", labels[k], strconv.FormatFloat(ts, 'f', 3, 64))
+	}
+
+	now := time.Now()
+	windows := make(map[string][]windowStats, len(keys))
+	for _, k := range keys {
+		windows[k] = m.targets[k].history.windows(now)
+	}
+	fmt.Fprintln(w, "# HELP udping_window_loss_ratio Share of the probes of the last window that failed, from 0 to 1.")
+	fmt.Fprintln(w, "# TYPE udping_window_loss_ratio gauge")
+	for _, k := range keys {
+		for _, ws := range windows[k] {
+			fmt.Fprintf(w, "udping_window_loss_ratio{target=\"%s\",window=\"%s\"} %s\n", labels[k], ws.Window, strconv.FormatFloat(ws.Loss/100, 'g', -1, 64))
+		}
+	}
+	fmt.Fprintln(w, "# HELP udping_window_rtt_avg_seconds Mean round trip time of the answered probes of the last window.")
+	fmt.Fprintln(w, "# TYPE udping_window_rtt_avg_seconds gauge")
+	for _, k := range keys {
+		for _, ws := range windows[k] {
+			fmt.Fprintf(w, "udping_window_rtt_avg_seconds{target=\"%s\",window=\"%s\"} %s\n", labels[k], ws.Window, strconv.FormatFloat(ws.AvgRTT, 'g', -1, 64))
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP udping_rtt_seconds Round trip time of successful probes.")
+	fmt.Fprintln(w, "# TYPE udping_rtt_seconds histogram")
+	for _, k := range keys {
+		t := m.targets[k]
+		cumulative := 0
+		for i, le := range metricsBuckets {
+			cumulative += t.buckets[i]
+			fmt.Fprintf(w, "udping_rtt_seconds_bucket{target=\"%s\",le=\"%s\"} %d\n", labels[k], strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "udping_rtt_seconds_bucket{target=\"%s\",le=\"+Inf\"} %d\n", labels[k], t.rttCount)
+		fmt.Fprintf(w, "udping_rtt_seconds_sum{target=\"%s\"} %s\n", labels[k], strconv.FormatFloat(t.rttSum, 'g', -1, 64))
+		fmt.Fprintf(w, "udping_rtt_seconds_count{target=\"%s\"} %d\n", labels[k], t.rttCount)
+	}
+}
+
+// serveMetrics serves the metrics of m on addr under /metrics, and their
+// rolling windows as JSON under /windows, in the background
+func serveMetrics(addr string, m *metricsCollector) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	mux.HandleFunc("/windows", m.serveWindows)
+	go func() {
+		log.Println(http.Serve(ln, mux))
+	}()
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// udp over IPv4 and IPv6 headers, without options or extension headers
+const (
+	udp4Overhead = 20 + 8
+	udp6Overhead = 40 + 8
+
+	// maxPayload is the largest payload of an IPv4 udp datagram, the ceiling of the search
+	maxPayload = 65535 - udp4Overhead
+)
+
+// mtuReport is the outcome of a path MTU discovery
+type mtuReport struct {
+	Target         string `json:"target"`
+	IP             string `json:"ip,omitempty"`
+	PathMTU        int    `json:"path_mtu,omitempty"`        // PathMTU is the largest packet that got through, headers included
+	LargestPayload int    `json:"largest_payload,omitempty"` // LargestPayload is the largest udp payload that got through
+	Probes         int    `json:"probes"`
+	Error          string `json:"error,omitempty"`
+}
+
+// discoverMTU binary searches the largest udp payload that reaches the
+// destination of params with the DF bit set, sending the probes of params at
+// each size tried. A size gets through when the destination answers or
+// refuses it; fragmentation needed errors narrow the search to the mtu they
+// report, silent drops count as too big. Each size tried is written to w.
+func discoverMTU(ctx context.Context, params udping.Params, w io.Writer) mtuReport {
+	rep := mtuReport{Target: params.Destination}
+	params.DontFragment = true
+
+	// whether size gets through, and the mtu a fragmentation needed error reported
+	try := func(size int) (bool, int, error) {
+		p := params
+		p.Size = size
+		r := udping.New(p)
+		if err := r.RunContext(ctx); err != nil {
+			return false, 0, err
+		}
+		mtu := 0
+		for _, res := range r.Results {
+			rep.Probes++
+			rep.IP = res.IP
+			if res.State == udping.StateOpen || res.State == udping.StateClosed {
+				if !strings.HasPrefix(res.Error, udping.E_FragNeeded) {
+					return true, 0, nil
+				}
+			}
+			if res.MTU > 0 {
+				mtu = res.MTU
+			}
+		}
+		return false, mtu, nil
+	}
+
+	// lo got through, hi did not
+	lo, hi := 1, maxPayload+1
+	ok, _, err := try(lo)
+	if err != nil {
+		rep.Error = err.Error()
+		return rep
+	}
+	if !ok {
+		rep.Error = "no answer to the smallest probe, drops cannot be told apart from packets too big"
+		return rep
+	}
+	overhead := udp4Overhead
+	if ip := net.ParseIP(rep.IP); ip != nil && ip.To4() == nil {
+		overhead = udp6Overhead
+	}
+	for hi-lo > 1 && ctx.Err() == nil {
+		size := lo + (hi-lo)/2
+		ok, mtu, err := try(size)
+		if err != nil {
+			rep.Error = err.Error()
+			return rep
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		verdict := "too big"
+		if ok {
+			lo, verdict = size, "ok"
+		} else {
+			hi = size
+			if fit := mtu - overhead; mtu > 0 && fit > lo && fit < hi {
+				// the router told us what fits, try that right away
+				hi = fit + 1
+			}
+		}
+		if mtu > 0 {
+			verdict += fmt.Sprintf(", mtu %d reported", mtu)
+		}
+		fmt.Fprintf(w, "%5d bytes: %s\n", size, verdict)
+	}
+	if ctx.Err() != nil {
+		rep.Error = "interrupted"
+		return rep
+	}
+	rep.LargestPayload = lo
+	rep.PathMTU = lo + overhead
+	return rep
+}
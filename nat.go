@@ -0,0 +1,173 @@
+package main
+
+// NAT traversal testing with two hosts behind NATs and a relay both can reach:
+//
+//	relay$  udping -relay :3478
+//	hostA$  udping -punch relay.example.com:3478 -session test1
+//	hostB$  udping -punch relay.example.com:3478 -session test1
+//
+// Each client tells the relay which session it joins. The relay answers with
+// the public address it saw the client's datagram come from (its NAT mapping)
+// and, once both peers of a session have joined, with the other peer's mapped
+// address. Both clients then send punch datagrams to each other from the same
+// socket, which opens the mapping in each NAT, and acknowledge the punches
+// they receive. The report shows the mapped addresses and whether datagrams
+// got through in each direction.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	natJoin   = "udping-join"   // client to relay: "udping-join <session>"
+	natMapped = "udping-mapped" // relay to client: "udping-mapped <address>"
+	natPeer   = "udping-peer"   // relay to client: "udping-peer <address>"
+	natPunch  = "udping-punch"  // peer to peer, opens the NAT mapping
+	natAck    = "udping-ack"    // peer to peer, confirms a punch arrived
+
+	// natInterval is how often joins and punches are resent until answered
+	natInterval = 200 * time.Millisecond
+)
+
+// natReport is the outcome of a hole punching attempt
+type natReport struct {
+	Session       string `json:"session"`
+	Mapped        string `json:"mapped,omitempty"` // Mapped is our address as seen by the relay
+	Peer          string `json:"peer,omitempty"`   // Peer is the peer's address as seen by the relay
+	Received      bool   `json:"received"`         // Received is true when a datagram from the peer got through
+	Acked         bool   `json:"acked"`            // Acked is true when the peer confirmed our datagrams got through
+	Bidirectional bool   `json:"bidirectional"`    // Bidirectional is true when datagrams flowed both ways
+	Error         string `json:"error,omitempty"`
+}
+
+// serveRelay answers joins on addr with the sender's mapped address and pairs
+// up the two peers of each session. It runs until the socket fails.
+func serveRelay(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	log.Printf("relay listening on %v\n", pc.LocalAddr())
+
+	// peers of each session in join order, at most two are kept
+	sessions := map[string][]net.Addr{}
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		session, ok := natArg(buf[:n], natJoin)
+		if !ok || session == "" {
+			continue
+		}
+		pc.WriteTo([]byte(natMapped+" "+from.String()), from)
+
+		peers := sessions[session]
+		known := false
+		for _, p := range peers {
+			known = known || p.String() == from.String()
+		}
+		if !known {
+			if len(peers) == 2 {
+				// a new pair reuses the session name
+				peers = peers[1:]
+			}
+			peers = append(peers, from)
+			sessions[session] = peers
+		}
+		if len(peers) == 2 {
+			pc.WriteTo([]byte(natPeer+" "+peers[1].String()), peers[0])
+			pc.WriteTo([]byte(natPeer+" "+peers[0].String()), peers[1])
+		}
+	}
+}
+
+// punch joins session on the relay and tries to exchange datagrams with the
+// other peer of the session, giving up after timeout
+func punch(relay, session string, timeout time.Duration) natReport {
+	rep := natReport{Session: session}
+	raddr, err := net.ResolveUDPAddr("udp", relay)
+	if err != nil {
+		rep.Error = err.Error()
+		return rep
+	}
+	// one socket for everything, so the peer sees the mapping the relay reported
+	pc, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		rep.Error = err.Error()
+		return rep
+	}
+	defer pc.Close()
+
+	var peer net.Addr
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 1500)
+	for time.Now().Before(deadline) && !rep.Bidirectional {
+		if peer == nil {
+			pc.WriteTo([]byte(natJoin+" "+session), raddr)
+		} else {
+			pc.WriteTo([]byte(natPunch), peer)
+		}
+
+		// read everything that arrives until it is time to resend
+		pc.SetReadDeadline(time.Now().Add(natInterval))
+		for !rep.Bidirectional {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				var ne net.Error
+				if !errors.As(err, &ne) || !ne.Timeout() {
+					rep.Error = err.Error()
+					return rep
+				}
+				break
+			}
+			msg := buf[:n]
+			if mapped, ok := natArg(msg, natMapped); ok {
+				rep.Mapped = mapped
+			} else if addr, ok := natArg(msg, natPeer); ok {
+				if peer, err = net.ResolveUDPAddr("udp", addr); err != nil {
+					rep.Error = err.Error()
+					return rep
+				}
+				rep.Peer = addr
+			} else if string(msg) == natPunch {
+				rep.Received = true
+				// answer where it came from, the peer's NAT may have picked another port
+				pc.WriteTo([]byte(natAck), from)
+			} else if string(msg) == natAck {
+				rep.Acked = true
+			}
+			rep.Bidirectional = rep.Received && rep.Acked
+		}
+	}
+	if rep.Bidirectional {
+		// the peer may still be waiting for an ack of its last punch
+		pc.WriteTo([]byte(natPunch), peer)
+		return rep
+	}
+	switch {
+	case rep.Mapped == "":
+		rep.Error = fmt.Sprintf("no answer from relay %s", relay)
+	case rep.Peer == "":
+		rep.Error = fmt.Sprintf("no peer joined session %q", session)
+	default:
+		rep.Error = "hole punching did not open both directions"
+	}
+	return rep
+}
+
+// natArg returns the argument of msg when it is the command cmd
+func natArg(msg []byte, cmd string) (string, bool) {
+	s := string(msg)
+	if !strings.HasPrefix(s, cmd+" ") {
+		return "", false
+	}
+	return strings.TrimSpace(s[len(cmd)+1:]), true
+}
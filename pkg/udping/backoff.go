@@ -0,0 +1,50 @@
+package udping
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	BackoffFixed       = "fixed"       // wait base between every attempt
+	BackoffLinear      = "linear"      // wait base, 2*base, 3*base, ...
+	BackoffExponential = "exponential" // wait base, 2*base, 4*base, ...
+
+	DefaultBackoffBase = 100 * time.Millisecond
+	DefaultBackoffCap  = 2 * time.Second
+)
+
+// backoffFunc returns how long to wait before retry number attempt, starting at 1
+type backoffFunc func(attempt int) time.Duration
+
+// newBackoff returns the backoff for strategy, with delays starting at base and never exceeding max.
+// An empty strategy means exponential; zero base and max take the defaults of 100ms and 2s.
+func newBackoff(strategy string, base, max time.Duration) (backoffFunc, error) {
+	if base <= 0 {
+		base = DefaultBackoffBase
+	}
+	if max <= 0 {
+		max = DefaultBackoffCap
+	}
+	capped := func(d time.Duration) time.Duration {
+		if d > max || d <= 0 {
+			return max
+		}
+		return d
+	}
+
+	switch strategy {
+	case BackoffFixed:
+		return func(int) time.Duration { return capped(base) }, nil
+	case BackoffLinear:
+		return func(attempt int) time.Duration { return capped(time.Duration(attempt) * base) }, nil
+	case BackoffExponential, "":
+		return func(attempt int) time.Duration {
+			if attempt > 30 {
+				return max
+			}
+			return capped(base << (attempt - 1))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown backoff strategy %q, expected %s, %s or %s", strategy, BackoffFixed, BackoffLinear, BackoffExponential)
+}
@@ -0,0 +1,55 @@
+package udping
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// loadBatch is the most datagrams a load run sends or receives in one system call
+const loadBatch = 32
+
+// batchConn sends and receives several datagrams at a time over a connected
+// udp socket. Messages carry no address and a single buffer each.
+type batchConn interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// singleConn is the batchConn of platforms without sendmmsg and recvmmsg,
+// which moves one datagram per system call
+type singleConn struct {
+	net.Conn
+}
+
+func (c singleConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, err := c.Read(ms[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	ms[0].N = n
+	return 1, nil
+}
+
+func (c singleConn) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	for i := range ms {
+		if _, err := c.Write(ms[i].Buffers[0]); err != nil {
+			return i, err
+		}
+	}
+	return len(ms), nil
+}
+
+// newMessages returns n messages with a buffer of size bytes each, or
+// without buffers when size is 0
+func newMessages(n, size int) []ipv4.Message {
+	ms := make([]ipv4.Message, n)
+	for i := range ms {
+		if size > 0 {
+			ms[i].Buffers = [][]byte{make([]byte, size)}
+		} else {
+			ms[i].Buffers = make([][]byte, 1)
+		}
+	}
+	return ms
+}
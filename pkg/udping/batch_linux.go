@@ -0,0 +1,43 @@
+package udping
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// newBatchConn returns a batchConn over c built on sendmmsg and recvmmsg
+func newBatchConn(c net.Conn, v6 bool) batchConn {
+	pc, ok := c.(net.PacketConn)
+	if !ok {
+		return singleConn{c}
+	}
+	if v6 {
+		return mmsgConn{ipv6.NewPacketConn(pc)}
+	}
+	return mmsgConn{ipv4.NewPacketConn(pc)}
+}
+
+// mmsgConn is the batchConn of Linux. x/net reports -1 datagrams alongside
+// the error of a failed sendmmsg or recvmmsg, which mmsgConn turns into
+// none, the count singleConn reports, so that callers can slice by it.
+type mmsgConn struct {
+	bc batchConn
+}
+
+func (c mmsgConn) ReadBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, err := c.bc.ReadBatch(ms, flags)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (c mmsgConn) WriteBatch(ms []ipv4.Message, flags int) (int, error) {
+	n, err := c.bc.WriteBatch(ms, flags)
+	if n < 0 {
+		n = 0
+	}
+	return n, err
+}
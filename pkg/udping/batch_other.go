@@ -0,0 +1,11 @@
+//go:build !linux
+
+package udping
+
+import "net"
+
+// newBatchConn returns a batchConn over c; batches are sent and received one
+// datagram at a time on this platform
+func newBatchConn(c net.Conn, v6 bool) batchConn {
+	return singleConn{c}
+}
@@ -0,0 +1,57 @@
+package udping
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ErrByteBudget stops a run once sending the next probe would exceed the byte budget
+var ErrByteBudget = errors.New("byte budget exhausted")
+
+// Budget is a byte budget several runners draw from, so that runs in
+// parallel stay within it together rather than each spending all of it
+type Budget struct {
+	limit     int64
+	spent     int64 // accessed atomically
+	exhausted int32 // accessed atomically, 1 once bytes were refused
+}
+
+// NewBudget returns a budget of limit probe bytes
+func NewBudget(limit int64) *Budget { return &Budget{limit: limit} }
+
+// Spent returns the number of bytes drawn from the budget so far
+func (b *Budget) Spent() int64 { return atomic.LoadInt64(&b.spent) }
+
+// Remaining returns the number of bytes left to draw
+func (b *Budget) Remaining() int64 { return b.limit - b.Spent() }
+
+// Exhausted reports whether bytes were refused because they would have gone over the budget
+func (b *Budget) Exhausted() bool { return atomic.LoadInt32(&b.exhausted) == 1 }
+
+// take draws n bytes, refusing them all if they would go over the budget
+func (b *Budget) take(n int64) bool {
+	for {
+		spent := atomic.LoadInt64(&b.spent)
+		if spent+n > b.limit {
+			atomic.StoreInt32(&b.exhausted, 1)
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&b.spent, spent, spent+n) {
+			return true
+		}
+	}
+}
+
+// spend accounts for n bytes about to be sent, refusing them if they would go over the budget
+func (r *Runner) spend(n int) error {
+	if r.Budget != nil && !r.Budget.take(int64(n)) {
+		r.BudgetExhausted = true
+		return ErrByteBudget
+	}
+	if r.Parameters.ByteBudget > 0 && r.BytesSent+int64(n) > r.Parameters.ByteBudget {
+		r.BudgetExhausted = true
+		return ErrByteBudget
+	}
+	r.BytesSent += int64(n)
+	return nil
+}
@@ -0,0 +1,53 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+)
+
+// setSocketBuffers sets the receive and send buffers of c to the RecvBuffer
+// and SendBuffer bytes asked for, reading back the sizes the kernel applied,
+// which it caps at its limits, net.core.rmem_max and wmem_max on Linux,
+// without failing. BuffersClamped records a buffer that came out smaller.
+func (r *Runner) setSocketBuffers(c net.Conn) error {
+	if r.Parameters.RecvBuffer == 0 && r.Parameters.SendBuffer == 0 {
+		return nil
+	}
+	uc, ok := c.(*net.UDPConn)
+	if !ok {
+		return fmt.Errorf("socket buffers cannot be set on a %T", c)
+	}
+	if n := r.Parameters.RecvBuffer; n > 0 {
+		if err := uc.SetReadBuffer(n); err != nil {
+			return fmt.Errorf("setting receive buffer: %v", err)
+		}
+	}
+	if n := r.Parameters.SendBuffer; n > 0 {
+		if err := uc.SetWriteBuffer(n); err != nil {
+			return fmt.Errorf("setting send buffer: %v", err)
+		}
+	}
+	rcv, snd, ok := socketBuffers(uc)
+	if !ok {
+		return nil
+	}
+	r.RecvBufferSize, r.SendBufferSize = rcv, snd
+	r.debugf("socket buffers: receive %d bytes, send %d bytes\n", rcv, snd)
+	if rcv < r.Parameters.RecvBuffer || snd < r.Parameters.SendBuffer {
+		r.BuffersClamped = true
+	}
+	return nil
+}
+
+// validateBuffers checks the socket buffer sizes asked for
+func (r *Runner) validateBuffers(v *ValidationError) {
+	if r.Parameters.RecvBuffer < 0 {
+		v.add("RecvBuffer", fmt.Errorf("receive buffer must not be negative, got %d", r.Parameters.RecvBuffer))
+	}
+	if r.Parameters.SendBuffer < 0 {
+		v.add("SendBuffer", fmt.Errorf("send buffer must not be negative, got %d", r.Parameters.SendBuffer))
+	}
+	if r.Parameters.Protocol != "udp" && (r.Parameters.RecvBuffer != 0 || r.Parameters.SendBuffer != 0) {
+		v.add("RecvBuffer", fmt.Errorf("socket buffers are only supported for udp pings"))
+	}
+}
@@ -0,0 +1,25 @@
+package udping
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketBuffers returns the receive and send buffer sizes of c. Linux
+// reports twice the size set, the rest being room for its bookkeeping, so
+// they are halved to compare with the sizes asked for.
+func socketBuffers(c *net.UDPConn) (rcv, snd int, ok bool) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+	var rerr, serr error
+	err = rc.Control(func(fd uintptr) {
+		rcv, rerr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		snd, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil || rerr != nil || serr != nil {
+		return 0, 0, false
+	}
+	return rcv / 2, snd / 2, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package udping
+
+import "net"
+
+// socketBuffers is not available on this platform, the sizes applied are unknown
+func socketBuffers(c *net.UDPConn) (rcv, snd int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,23 @@
+package udping
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestSocketBuffers(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("applied buffer sizes are only read back on linux")
+	}
+	port := echoServer(t, false)
+	r := run(t, port, Params{RecvBuffer: 32768, SendBuffer: 32768})
+	if !r.Results[0].Success || r.RecvBufferSize != 32768 || r.SendBufferSize != 32768 || r.BuffersClamped {
+		t.Errorf("success %v, buffers %d/%d, clamped %v, want 32768/32768", r.Results[0].Success, r.RecvBufferSize, r.SendBufferSize, r.BuffersClamped)
+	}
+
+	// far above any default net.core.rmem_max
+	r = run(t, port, Params{RecvBuffer: 1 << 30})
+	if !r.BuffersClamped || r.RecvBufferSize >= 1<<30 {
+		t.Errorf("buffer %d, clamped %v, want a clamped buffer", r.RecvBufferSize, r.BuffersClamped)
+	}
+}
@@ -0,0 +1,208 @@
+package udping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// burstProbe is a probe of a burst waiting for its reply
+type burstProbe struct {
+	res   Result
+	state probeState // what the probe learnt so far, r.last while its reply is checked
+	check func([]byte) error
+	rtt   time.Duration
+	err   error
+	done  bool
+}
+
+// runBursts runs the probes of the run in rounds of Burst probes sent back
+// to back over one socket, all of a round waiting for their replies at once.
+// Replies are told apart by the sequence number every probe carries, so a
+// round takes as long as its slowest probe rather than the sum of them, and
+// the losses within a round show how the path copes with bursts.
+func (r *Runner) runBursts(ctx context.Context) error {
+	first := time.Now()
+	for i := 0; r.Parameters.Continuous || i < r.Parameters.Count; i += r.Parameters.Burst {
+		if i > 0 && r.Parameters.Interval > 0 && r.Parameters.Spacing == 0 {
+			// between rounds, never after the last one; a spacing replaces the interval
+			sleepContext(ctx, r.Parameters.Interval)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		r.waitSpacing(ctx, first, i)
+
+		n := r.Parameters.Burst
+		if !r.Parameters.Continuous && r.Parameters.Count-i < n {
+			n = r.Parameters.Count - i
+		}
+		r.logf("[%v] sending a burst of %d to %s\n", i, n, r.dialAddr())
+		results, err := r.burst(ctx, n)
+		if ctx.Err() != nil {
+			// the round in flight was abandoned
+			break
+		}
+		for _, res := range results {
+			r.record(res)
+		}
+		if errors.Is(err, ErrByteBudget) {
+			// the budget is a planned stop, not a failure
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// burst sends n probes over one socket and waits up to the probe timeout
+// for all of their replies, returning their results in the order they were
+// sent. The returned error is only set when the run cannot continue; the
+// results of the probes sent before it are still returned.
+func (r *Runner) burst(ctx context.Context, n int) ([]Result, error) {
+	var timeout float64
+	if r.timeouts != nil {
+		timeout = r.timeouts.next().Seconds()
+	}
+	r.last = probeState{deadline: time.Now().Add(r.probeTimeout())}
+	c, release, err := r.udpSocket(ctx, r.dialAddr())
+	if err != nil {
+		if isFDExhausted(err) {
+			return nil, fdLimitError()
+		}
+		r.logf("%v\n", err)
+		// every probe of the round fails alike
+		results := make([]Result, n)
+		for k := range results {
+			res := r.newResult()
+			res.Timeout = timeout
+			results[k] = r.complete(res, 0, err)
+		}
+		return results, nil
+	}
+	defer release()
+	local, deadline := c.LocalAddr().String(), r.last.deadline
+	c.SetDeadline(deadline)
+	defer watchContext(ctx, c)()
+
+	var stop error
+	probes := make([]*burstProbe, 0, n)
+	bySeq := map[int]*burstProbe{}
+	for k := 0; k < n; k++ {
+		if r.Limiter != nil {
+			if err := r.Limiter.Wait(ctx); err != nil {
+				return nil, ctx.Err()
+			}
+		}
+		r.last = probeState{localAddr: local, deadline: deadline}
+		p := &burstProbe{res: r.newResult()}
+		p.res.Timeout = timeout
+		payload, check, err := r.probe()
+		if err == nil {
+			err = r.spend(len(payload))
+		}
+		if errors.Is(err, ErrByteBudget) {
+			stop = err
+			break
+		}
+		probes = append(probes, p)
+		if err == nil {
+			r.last.sentAt = time.Now()
+			_, err = c.Write(payload)
+		}
+		if err != nil {
+			r.debugf("send to %v failed: %v\n", c.RemoteAddr(), err)
+			if os.IsTimeout(err) {
+				err = fmt.Errorf(E_Timeout)
+			}
+			p.state, p.err, p.done = r.last, err, true
+			continue
+		}
+		r.tracef("sent %d bytes to %v: %x\n", len(payload), c.RemoteAddr(), payload)
+		if r.Capture != nil {
+			r.Capture.WriteDatagram(r.last.sentAt, c.LocalAddr(), c.RemoteAddr(), payload)
+		}
+		p.state, p.check = r.last, check
+		bySeq[r.last.seq] = p
+	}
+
+	rb := r.readBuffer()
+	for waiting := len(bySeq); waiting > 0; {
+		r.last = probeState{}
+		m, at, err := r.readReply(c, rb)
+		if err != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			if !os.IsTimeout(err) {
+				// a refusal or failed read says nothing of which probe caused
+				// it, so it is the answer to all of those left
+				if isRefused(err) {
+					err = fmt.Errorf(E_ConnRefused)
+				} else {
+					err = fmt.Errorf("read Error: %w", err)
+				}
+				for _, p := range probes {
+					if !p.done {
+						p.rtt, p.err, p.done = at.Sub(p.state.sentAt), err, true
+					}
+				}
+			}
+			break
+		}
+		r.tracef("received %d bytes from %v: %x\n", m, c.RemoteAddr(), rb[:m])
+		if r.Capture != nil {
+			r.Capture.WriteDatagram(time.Now(), c.RemoteAddr(), c.LocalAddr(), rb[:m])
+		}
+		seq, _, ok := parseSeq(rb[:m])
+		p := bySeq[seq]
+		if !ok || p == nil {
+			// a late reply to an earlier round, or not a reply at all
+			r.debugf("reply to no probe of the burst\n")
+			continue
+		}
+		if p.done {
+			p.state.duplicates++
+			r.debugf("duplicate reply to probe %d\n", seq)
+			continue
+		}
+		// check the reply in the state of its probe
+		ttl, tos := r.last.replyTTL, r.last.replyTOS
+		r.last = p.state
+		r.last.replyTTL, r.last.replyTOS = ttl, tos
+		r.last.reply = append([]byte(nil), rb[:m]...)
+		err = p.check(r.last.reply)
+		if err != nil && !errors.Is(err, errUncorrelated) {
+			err = replyError{err}
+		}
+		if err == nil {
+			err = r.checkReplyLength(m)
+		}
+		if err == nil {
+			err = r.checkExpect(r.last.reply)
+		}
+		p.state = r.last
+		if errors.Is(err, errUncorrelated) {
+			continue
+		}
+		p.rtt, p.err, p.done = at.Sub(p.state.sentAt), err, true
+		if p.rtt < 0 {
+			p.rtt = time.Since(p.state.sentAt)
+		}
+		waiting--
+	}
+
+	results := make([]Result, len(probes))
+	for k, p := range probes {
+		if !p.done {
+			p.err = fmt.Errorf(E_Timeout)
+		}
+		r.last = p.state
+		results[k] = r.complete(p.res, p.rtt, p.err)
+	}
+	return results, stop
+}
@@ -0,0 +1,29 @@
+package udping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBurst(t *testing.T) {
+	r := run(t, echoServer(t, false), Params{Burst: 4, Count: 6})
+	seen := map[int]bool{}
+	for _, res := range r.Results {
+		if !res.Success || res.RTT <= 0 || seen[res.Seq] {
+			t.Errorf("probe %d: success %v, rtt %v, want a distinct answered probe", res.Seq, res.Success, res.RTT)
+		}
+		seen[res.Seq] = true
+	}
+
+	// the probes of a round wait for their replies together
+	start := time.Now()
+	r = run(t, silentServer(t), Params{Burst: 4, Count: 4, Timeout: 200 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+		t.Errorf("a burst of 4 timed out after %v, want about one 200ms timeout", elapsed)
+	}
+	for _, res := range r.Results {
+		if res.Error != E_Timeout {
+			t.Errorf("probe %d: error %q, want %q", res.Seq, res.Error, E_Timeout)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// replyHeader is what the kernel tells of a datagram received, alongside its data
+type replyHeader struct {
+	at  time.Time // when the kernel received it, zero without KernelTimestamps
+	ttl int       // its IP TTL or IPv6 hop limit, -1 when unknown
+	tos int       // its ToS byte or IPv6 traffic class, -1 when unknown
+}
+
+// setKernelTimestamps asks the kernel to stamp the datagrams received on c
+// with their arrival time, for KernelTimestamps
+func (r *Runner) setKernelTimestamps(c net.Conn) error {
+	if !r.Parameters.KernelTimestamps {
+		return nil
+	}
+	if err := kernelTimestamps(c); err != nil {
+		return fmt.Errorf("enabling kernel timestamps: %v", err)
+	}
+	return nil
+}
+
+// setReplyHeaders asks the kernel to report the TTL and ToS of the datagrams
+// received on c, for ReplyHeaders
+func (r *Runner) setReplyHeaders(c net.Conn) error {
+	if !r.Parameters.ReplyHeaders {
+		return nil
+	}
+	if err := recvReplyHeaders(c, isIPv6(r.Parameters.ipDest)); err != nil {
+		return fmt.Errorf("asking for reply headers: %v", err)
+	}
+	return nil
+}
+
+// readReply reads a reply on c into b and returns when it arrived: the
+// kernel's timestamp with KernelTimestamps, which leaves out how long the
+// runtime took to wake the reader, or else the time it was read. With
+// ReplyHeaders, the TTL and ToS of the reply are recorded in r.last.
+func (r *Runner) readReply(c net.Conn, b []byte) (int, time.Time, error) {
+	if !r.Parameters.KernelTimestamps && !r.Parameters.ReplyHeaders {
+		n, err := c.Read(b)
+		return n, time.Now(), err
+	}
+	n, h, err := readMsg(c, b)
+	at := h.at
+	if at.IsZero() {
+		if err == nil && r.Parameters.KernelTimestamps {
+			r.tracef("no kernel timestamp on the reply\n")
+		}
+		at = time.Now()
+	}
+	if err == nil && h.ttl > 0 {
+		r.last.replyTTL = h.ttl
+	}
+	if err == nil && h.tos >= 0 {
+		tos := h.tos
+		r.last.replyTOS = &tos
+	}
+	return n, at, err
+}
+
+// initialTTLs are the TTLs operating systems send with: 64 for Linux and
+// macOS, 128 for Windows and 255 for network equipment
+var initialTTLs = []int{64, 128, 255}
+
+// reverseHops infers how many routers a reply with ttl crossed, assuming it
+// was sent with the smallest common initial TTL at least as large
+func reverseHops(ttl int) int {
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return initial - ttl
+		}
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package udping
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// cmsgBuffer is room for the control messages of a reply: a timestamp, a TTL and a ToS
+const cmsgBuffer = 128
+
+// kernelTimestamps turns on SO_TIMESTAMPNS, so that every datagram read from
+// c comes with the time the kernel received it, in nanoseconds
+func kernelTimestamps(c net.Conn) error {
+	return setsockoptInts(c, [][2]int{{syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS}})
+}
+
+// recvReplyHeaders turns on IP_RECVTTL and IP_RECVTOS, or their IPv6
+// counterparts, so that every datagram read from c comes with its TTL and ToS
+func recvReplyHeaders(c net.Conn, v6 bool) error {
+	if v6 {
+		return setsockoptInts(c, [][2]int{{syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT}, {syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS}})
+	}
+	return setsockoptInts(c, [][2]int{{syscall.IPPROTO_IP, syscall.IP_RECVTTL}, {syscall.IPPROTO_IP, syscall.IP_RECVTOS}})
+}
+
+// setsockoptInts turns on every level and option of opts on the socket of c
+func setsockoptInts(c net.Conn, opts [][2]int) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return syscall.EINVAL
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		for _, opt := range opts {
+			if serr = syscall.SetsockoptInt(int(fd), opt[0], opt[1], 1); serr != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// readMsg reads a datagram from c into b alongside what its control
+// messages tell of it
+func readMsg(c net.Conn, b []byte) (int, replyHeader, error) {
+	h := replyHeader{ttl: -1, tos: -1}
+	uc, ok := c.(*net.UDPConn)
+	if !ok {
+		n, err := c.Read(b)
+		return n, h, err
+	}
+	oob := make([]byte, cmsgBuffer)
+	n, oobn, _, _, err := uc.ReadMsgUDP(b, oob)
+	if err != nil {
+		return n, h, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, h, nil
+	}
+	for _, m := range msgs {
+		switch level, typ := m.Header.Level, m.Header.Type; {
+		case level == syscall.SOL_SOCKET && typ == syscall.SO_TIMESTAMPNS && len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})):
+			// SCM_TIMESTAMPNS has the value of SO_TIMESTAMPNS
+			ts := (*syscall.Timespec)(unsafe.Pointer(&m.Data[0]))
+			h.at = time.Unix(ts.Unix())
+		case level == syscall.IPPROTO_IP && typ == syscall.IP_TTL && len(m.Data) >= 4,
+			level == syscall.IPPROTO_IPV6 && typ == syscall.IPV6_HOPLIMIT && len(m.Data) >= 4:
+			h.ttl = int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		case level == syscall.IPPROTO_IP && typ == syscall.IP_TOS && len(m.Data) >= 1:
+			// a single byte, unlike the other options
+			h.tos = int(m.Data[0])
+		case level == syscall.IPPROTO_IPV6 && typ == syscall.IPV6_TCLASS && len(m.Data) >= 4:
+			h.tos = int(*(*int32)(unsafe.Pointer(&m.Data[0])))
+		}
+	}
+	return n, h, nil
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package udping
+
+import (
+	"errors"
+	"net"
+)
+
+// kernelTimestamps is not available on this platform
+func kernelTimestamps(c net.Conn) error {
+	return errors.New("not supported on this platform")
+}
+
+// recvReplyHeaders is not available on this platform
+func recvReplyHeaders(c net.Conn, v6 bool) error {
+	return errors.New("not supported on this platform")
+}
+
+// readMsg reads from c without control messages on this platform
+func readMsg(c net.Conn, b []byte) (int, replyHeader, error) {
+	n, err := c.Read(b)
+	return n, replyHeader{ttl: -1, tos: -1}, err
+}
@@ -0,0 +1,45 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// errUncorrelated marks a reply carrying another probe's token. It is not
+// an outcome: the probe keeps waiting for its own reply until it times out.
+var errUncorrelated = errors.New("reply does not match probe")
+
+// correlate appends a fresh random token to payload when Correlate is set
+// and records it for the probe's result. Services that echo the payload then
+// return the token, so a reply can be tied to the probe that caused it even
+// when several probes are in flight. Without Correlate the payload is unchanged.
+func (r *Runner) correlate(payload []byte) ([]byte, []byte) {
+	if !r.Parameters.Correlate {
+		return payload, nil
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return payload, nil
+	}
+	token := []byte(hex.EncodeToString(b))
+	r.last.correlationID = string(token)
+
+	out := make([]byte, 0, len(payload)+len(token))
+	return append(append(out, payload...), token...), token
+}
+
+// matchToken wraps check so that replies not containing token are rejected
+// with errUncorrelated. A nil token accepts every reply.
+func matchToken(token []byte, check func([]byte) error) func([]byte) error {
+	if token == nil {
+		return check
+	}
+	return func(reply []byte) error {
+		if !bytes.Contains(reply, token) {
+			return errUncorrelated
+		}
+		return check(reply)
+	}
+}
@@ -0,0 +1,133 @@
+package udping
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	ModeRaw = "raw" // send a fixed payload and accept any reply
+	ModeDNS = "dns" // send a DNS query and require a matching answer
+
+	E_DNSReply = "invalid dns reply"
+
+	// DefaultDNSName is queried in dns mode when no name is given
+	DefaultDNSName = "example.com."
+)
+
+// dnsTypes are the query types accepted by -dns-type
+var dnsTypes = map[string]dnsmessage.Type{
+	"A":     dnsmessage.TypeA,
+	"AAAA":  dnsmessage.TypeAAAA,
+	"NS":    dnsmessage.TypeNS,
+	"CNAME": dnsmessage.TypeCNAME,
+	"SOA":   dnsmessage.TypeSOA,
+	"PTR":   dnsmessage.TypePTR,
+	"MX":    dnsmessage.TypeMX,
+	"TXT":   dnsmessage.TypeTXT,
+}
+
+// dnsType returns the query type for name, which is matched case-insensitively. An empty name means A.
+func dnsType(name string) (dnsmessage.Type, error) {
+	if name == "" {
+		return dnsmessage.TypeA, nil
+	}
+	t, ok := dnsTypes[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported dns query type %q, expected A, AAAA, NS, CNAME, SOA, PTR, MX or TXT", name)
+	}
+	return t, nil
+}
+
+// dnsQuestion returns the question asked by a dns mode probe
+func (r *Runner) dnsQuestion() (dnsmessage.Question, error) {
+	name := r.Parameters.DNSName
+	if name == "" {
+		name = DefaultDNSName
+	}
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return dnsmessage.Question{}, fmt.Errorf("invalid dns query name %q: %v", r.Parameters.DNSName, err)
+	}
+	qtype, err := dnsType(r.Parameters.DNSType)
+	if err != nil {
+		return dnsmessage.Question{}, &FieldError{"DNSType", err}
+	}
+	return dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}, nil
+}
+
+// dnsProbe builds a DNS query with a random ID and returns it alongside a
+// function that checks a reply answers that query
+func (r *Runner) dnsProbe() ([]byte, func([]byte) error, error) {
+	q, err := r.dnsQuestion()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var idb [2]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return nil, nil, err
+	}
+	id := binary.BigEndian.Uint16(idb[:])
+
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	check := func(reply []byte) error {
+		var p dnsmessage.Parser
+		h, err := p.Start(reply)
+		if err != nil {
+			return fmt.Errorf("%s: %v", E_DNSReply, err)
+		}
+		if h.ID != id {
+			return fmt.Errorf("%s: id %d does not match query id %d", E_DNSReply, h.ID, id)
+		}
+		if !h.Response {
+			return fmt.Errorf("%s: not a response", E_DNSReply)
+		}
+		if h.RCode != dnsmessage.RCodeSuccess {
+			return r.serviceError(fmt.Errorf("%s: %v", E_DNSReply, h.RCode))
+		}
+		// the question is echoed back, a reply to anything else is not ours
+		rq, err := p.Question()
+		if err != nil {
+			return fmt.Errorf("%s: %v", E_DNSReply, err)
+		}
+		if !strings.EqualFold(rq.Name.String(), q.Name.String()) || rq.Type != q.Type {
+			return fmt.Errorf("%s: question %v %v does not match query %v %v", E_DNSReply, rq.Name, rq.Type, q.Name, q.Type)
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return fmt.Errorf("%s: %v", E_DNSReply, err)
+		}
+		for {
+			ah, err := p.AnswerHeader()
+			if err == dnsmessage.ErrSectionDone {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", E_DNSReply, err)
+			}
+			if ah.Type == q.Type {
+				return nil
+			}
+			if err := p.SkipAnswer(); err != nil {
+				return fmt.Errorf("%s: %v", E_DNSReply, err)
+			}
+		}
+		return r.serviceError(fmt.Errorf("%s: no %v answer for %v", E_DNSReply, q.Type, q.Name))
+	}
+	return query, check, nil
+}
@@ -0,0 +1,39 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// maxDSCP is the largest value of the 6 bit DSCP field
+	maxDSCP = 63
+	// maxTOS is the largest value of the whole ToS byte
+	maxTOS = 255
+)
+
+// setDSCP marks the datagrams sent on c with the DSCP parameter, in the upper
+// six bits of the IPv4 ToS byte or the IPv6 traffic class, or sets the whole
+// byte, ECN bits included, to the TOS parameter
+func (r *Runner) setDSCP(c net.Conn) error {
+	if r.Parameters.DSCP == 0 && r.Parameters.TOS == 0 {
+		return nil
+	}
+	tos := r.Parameters.DSCP << 2
+	if r.Parameters.TOS != 0 {
+		tos = r.Parameters.TOS
+	}
+	var err error
+	if isIPv6(r.Parameters.ipDest) {
+		err = ipv6.NewConn(c).SetTrafficClass(tos)
+	} else {
+		err = ipv4.NewConn(c).SetTOS(tos)
+	}
+	if err != nil {
+		return fmt.Errorf("setting dscp: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package udping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	ModeEcho = "echo" // send to a udping echo server and measure one-way delays
+
+	E_EchoReply = "invalid echo reply"
+	E_NotEcho   = "reply is not an echo of the probe"
+	E_Corrupted = "echoed payload corrupted"
+
+	// echoStampLen is the size of the server receive timestamp appended to every echoed datagram
+	echoStampLen = 8
+)
+
+// echoPayload is sent in echo mode; the server returns it unchanged followed by its timestamp
+var echoPayload = []byte("udping-echo")
+
+type (
+	// oneWay holds the one-way delays of a single echo probe. They are only
+	// meaningful when the client and server clocks are synchronized.
+	oneWay struct {
+		forward time.Duration // client send to server receive
+		reverse time.Duration // server receive to client receive
+	}
+)
+
+// ServeEcho answers every datagram received on addr with the datagram itself
+// followed by the server receive time, in nanoseconds since the Unix epoch.
+// It is the companion to the client's echo mode and runs until the socket fails.
+func ServeEcho(addr string) error {
+	return serveEcho(addr, true)
+}
+
+// ServePlainEcho answers every datagram received on addr with the datagram
+// itself, like an RFC 862 echo server, for raw mode clients checking the
+// echo with ExpectEcho. It runs until the socket fails.
+func ServePlainEcho(addr string) error {
+	return serveEcho(addr, false)
+}
+
+func serveEcho(addr string, stamp bool) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	log.Printf("echo server listening on %v\n", pc.LocalAddr())
+	return echoLoop(pc, nil, stamp)
+}
+
+// EchoLoop echoes datagrams on pc until reading fails, calling onPacket, if
+// set, with the sender, size and arrival time of each datagram
+func EchoLoop(pc net.PacketConn, onPacket func(from net.Addr, n int, at time.Time)) error {
+	return echoLoop(pc, onPacket, true)
+}
+
+// echoLoop is EchoLoop, appending the receive timestamp only when stamp is set
+func echoLoop(pc net.PacketConn, onPacket func(from net.Addr, n int, at time.Time), stamp bool) error {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		recv := time.Now()
+		if onPacket != nil {
+			onPacket(from, n, recv)
+		}
+		if !stamp {
+			if _, err := pc.WriteTo(buf[:n], from); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		if n+echoStampLen > len(buf) {
+			// no room left for the timestamp, echo what we can
+			n = len(buf) - echoStampLen
+		}
+		binary.BigEndian.PutUint64(buf[n:], uint64(recv.UnixNano()))
+		if _, err := pc.WriteTo(buf[:n+echoStampLen], from); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// echoCheck returns a function checking the echo of payload, which is about
+// to be sent. A valid reply records the one-way delays of the probe in r.last.
+func (r *Runner) echoCheck(payload []byte) (func([]byte) error, error) {
+	sent := time.Now()
+	return func(reply []byte) error {
+		recv := time.Now()
+		if len(reply) != len(payload)+echoStampLen {
+			return fmt.Errorf("%s: not sent by a udping echo server", E_EchoReply)
+		}
+		if err := corruption(reply[:len(payload)], payload); err != nil {
+			return err
+		}
+		if !bytes.Equal(reply[:len(payload)], payload) {
+			return fmt.Errorf("%s: not sent by a udping echo server", E_EchoReply)
+		}
+		stamp := time.Unix(0, int64(binary.BigEndian.Uint64(reply[len(payload):])))
+		r.last.oneWay = &oneWay{forward: stamp.Sub(sent), reverse: recv.Sub(stamp)}
+		return nil
+	}, nil
+}
+
+// plainEchoCheck returns a function checking that a reply is payload itself,
+// as a plain echo server returns it
+func plainEchoCheck(payload []byte) func([]byte) error {
+	return func(reply []byte) error {
+		if err := corruption(reply, payload); err != nil {
+			return err
+		}
+		if !bytes.Equal(reply, payload) {
+			return fmt.Errorf("%s: got %d bytes for %d sent", E_NotEcho, len(reply), len(payload))
+		}
+		return nil
+	}
+}
+
+// corruption returns an E_Corrupted error when echo looks like payload
+// mangled on the way: as long, with at most half of its bytes changed. A
+// middlebox rewriting an address it finds in the payload, or a broken NIC
+// offload, changes a few bytes and keeps the rest; a reply that differs more
+// is something other than an echo. It returns nil otherwise, an exact echo
+// included. Bytes changed in a correlation token or sequence number keep the
+// reply from being matched to its probe instead, which then times out.
+func corruption(echo, payload []byte) error {
+	if len(echo) != len(payload) || len(payload) == 0 {
+		return nil
+	}
+	first, changed := -1, 0
+	for i := range payload {
+		if echo[i] != payload[i] {
+			if first < 0 {
+				first = i
+			}
+			changed++
+		}
+	}
+	if changed == 0 || 2*changed > len(payload) {
+		return nil
+	}
+	return fmt.Errorf("%s: %d of %d bytes changed, the first at offset %d", E_Corrupted, changed, len(payload), first)
+}
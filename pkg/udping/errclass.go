@@ -0,0 +1,79 @@
+package udping
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// ErrorClass is the kind of failure a probe ended with. Unlike the error
+// message, which carries platform and locale dependent text, it is the same
+// on every platform.
+type ErrorClass string
+
+const (
+	ClassTimeout     ErrorClass = "timeout"      // nothing answered in time
+	ClassRefused     ErrorClass = "refused"      // the connection was refused, or a udp probe answered with an ICMP port unreachable
+	ClassUnreachable ErrorClass = "unreachable"  // no route to the network or host
+	ClassTTLExceeded ErrorClass = "ttl_exceeded" // a router dropped the probe once its TTL ran out
+	ClassFragNeeded  ErrorClass = "frag_needed"  // the probe was too big for the path with DontFragment set
+	ClassBadReply    ErrorClass = "bad_reply"    // a reply came that failed the checks of the mode or of Expect
+	ClassCorrupted   ErrorClass = "corrupted"    // an echo came back with some of the probe's payload bytes changed
+	ClassLocal       ErrorClass = "local"        // the probe could not be sent for a local reason, such as permissions or resources
+	ClassOther       ErrorClass = "other"
+)
+
+// replyError is the error of a probe whose reply failed a check: an answer,
+// just not the one expected
+type replyError struct{ err error }
+
+func (e replyError) Error() string { return e.err.Error() }
+func (e replyError) Unwrap() error { return e.err }
+
+// classify returns the class of the error a probe failed with, empty for nil
+func classify(err error) ErrorClass {
+	var re replyError
+	switch {
+	case err == nil:
+		return ""
+	case err.Error() == E_Timeout || os.IsTimeout(err):
+		return ClassTimeout
+	case err.Error() == E_ConnRefused || isRefused(err):
+		return ClassRefused
+	case strings.HasPrefix(err.Error(), E_TTLExceeded):
+		return ClassTTLExceeded
+	case strings.HasPrefix(err.Error(), E_FragNeeded):
+		return ClassFragNeeded
+	case strings.HasPrefix(err.Error(), E_Corrupted):
+		return ClassCorrupted
+	case errors.As(err, &re):
+		return ClassBadReply
+	case isUnreachable(err):
+		return ClassUnreachable
+	case isFDExhausted(err) || errors.Is(err, ErrFDLimit) || errors.Is(err, ErrByteBudget) || errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EADDRINUSE):
+		return ClassLocal
+	}
+	return ClassOther
+}
+
+// isRefused reports whether err is a refusal, whatever errno the platform reports it with
+func isRefused(err error) bool {
+	for _, errno := range refusedErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnreachable reports whether err says there is no route to the destination
+func isUnreachable(err error) bool {
+	for _, errno := range unreachableErrnos {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
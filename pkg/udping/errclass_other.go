@@ -0,0 +1,11 @@
+//go:build !windows
+
+package udping
+
+import "syscall"
+
+// the errnos of refusals, for ECONNREFUSED covers both the tcp reset and
+// the ICMP port unreachable of udp
+var refusedErrnos = []syscall.Errno{syscall.ECONNREFUSED}
+
+var unreachableErrnos = []syscall.Errno{syscall.ENETUNREACH, syscall.EHOSTUNREACH}
@@ -0,0 +1,40 @@
+package udping
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// opError wraps errno as the net package returns it from a socket call
+func opError(op string, errno syscall.Errno) error {
+	return &net.OpError{Op: op, Net: "udp", Err: os.NewSyscallError(op, errno)}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want ErrorClass
+	}{
+		{nil, ""},
+		{errors.New(E_Timeout), ClassTimeout},
+		{errors.New(E_ConnRefused), ClassRefused},
+		{opError("read", syscall.ECONNREFUSED), ClassRefused},
+		{fmt.Errorf("%s at 192.0.2.1", E_TTLExceeded), ClassTTLExceeded},
+		{fmt.Errorf("%s, local mtu 1500", E_FragNeeded), ClassFragNeeded},
+		{replyError{fmt.Errorf("%s: not a dns message", E_DNSReply)}, ClassBadReply},
+		{replyError{fmt.Errorf("%s: 1 of 15 bytes changed, the first at offset 0", E_Corrupted)}, ClassCorrupted},
+		{fmt.Errorf("read Error: %w", opError("read", syscall.EHOSTUNREACH)), ClassUnreachable},
+		{opError("dial", syscall.ENETUNREACH), ClassUnreachable},
+		{opError("write", syscall.EPERM), ClassLocal},
+		{fmt.Errorf("budget: %w", ErrByteBudget), ClassLocal},
+		{errors.New("something else"), ClassOther},
+	} {
+		if got := classify(tc.err); got != tc.want {
+			t.Errorf("classify(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package udping
+
+import "syscall"
+
+// Winsock error codes the syscall package has no names for
+const (
+	wsaENETUNREACH  syscall.Errno = 10051
+	wsaECONNREFUSED syscall.Errno = 10061
+	wsaEHOSTUNREACH syscall.Errno = 10065
+)
+
+// Windows reports the ICMP port unreachable answering a udp datagram as a
+// reset of the connection on the next read, WSAECONNRESET
+var refusedErrnos = []syscall.Errno{syscall.ECONNREFUSED, wsaECONNREFUSED, syscall.WSAECONNRESET}
+
+var unreachableErrnos = []syscall.Errno{syscall.ENETUNREACH, syscall.EHOSTUNREACH, wsaENETUNREACH, wsaEHOSTUNREACH}
@@ -0,0 +1,75 @@
+package udping
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+type (
+	// Candidate is one resolved address tried while selecting the fastest
+	Candidate struct {
+		IP    string  `json:"ip"`
+		RTT   float64 `json:"rtt,omitempty"`
+		Error string  `json:"error,omitempty"`
+	}
+
+	// Selection is the record of the addresses tried when Parameters.Fastest is set and of why one was chosen
+	Selection struct {
+		Candidates []Candidate `json:"candidates"`
+		Chosen     string      `json:"chosen"`
+		Reason     string      `json:"reason"`
+	}
+)
+
+// selectFastest probes every address the destination resolves to once and
+// pins the run to the one that answered fastest. Addresses whose probe failed
+// are only chosen when none succeeded, in which case the first one is kept.
+func (r *Runner) selectFastest(ctx context.Context) error {
+	ips, err := r.lookupHost(ctx)
+	if err != nil {
+		// a literal IP, there is nothing to choose from
+		ips = []string{r.Parameters.ipDest}
+	}
+	ips = r.filterFamily(ips)
+
+	sel := &Selection{}
+	best := -1
+	for _, ip := range ips {
+		if net.ParseIP(ip) == nil {
+			continue
+		}
+		r.Parameters.ipDest = ip
+		res, err := r.measure(ctx, r.newResult())
+		if err != nil {
+			return err
+		}
+		c := Candidate{IP: ip}
+		if res.Success && res.Error == "" {
+			c.RTT = res.RTT
+			if best < 0 || c.RTT < sel.Candidates[best].RTT {
+				best = len(sel.Candidates)
+			}
+		} else if res.Error != "" {
+			c.Error = res.Error
+		} else {
+			c.Error = "no response"
+		}
+		sel.Candidates = append(sel.Candidates, c)
+	}
+	if len(sel.Candidates) == 0 {
+		return fmt.Errorf("FQDN does not resolve to any known ip")
+	}
+
+	switch {
+	case best >= 0:
+		sel.Chosen = sel.Candidates[best].IP
+		sel.Reason = fmt.Sprintf("lowest rtt (%.3fms) of %d addresses", sel.Candidates[best].RTT*1000, len(sel.Candidates))
+	default:
+		sel.Chosen = sel.Candidates[0].IP
+		sel.Reason = fmt.Sprintf("none of %d addresses answered, using the first", len(sel.Candidates))
+	}
+	r.Parameters.ipDest = sel.Chosen
+	r.Selection = sel
+	return nil
+}
@@ -0,0 +1,25 @@
+package udping
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// ErrFDLimit is returned when the process runs out of file descriptors.
+// Every further probe would fail the same way, so Run stops instead of
+// recording the same error for each one.
+var ErrFDLimit = errors.New("too many open files")
+
+// isFDExhausted reports whether err was caused by hitting the process or system file descriptor limit
+func isFDExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// fdLimitError wraps ErrFDLimit with the current soft limit, when known, and a hint on how to fix it
+func fdLimitError() error {
+	if limit, ok := softFDLimit(); ok {
+		return fmt.Errorf("%w (soft limit %d): reduce the number of concurrent probes or raise the limit with ulimit -n", ErrFDLimit, limit)
+	}
+	return fmt.Errorf("%w: reduce the number of concurrent probes or raise the open file limit", ErrFDLimit)
+}
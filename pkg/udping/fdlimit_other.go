@@ -0,0 +1,8 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package udping
+
+// softFDLimit is not available on this platform
+func softFDLimit() (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,39 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package udping
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+// TestFDLimitStops checks a run out of file descriptors stops with
+// ErrFDLimit after its first probe instead of recording one failure each
+func TestFDLimitStops(t *testing.T) {
+	port := echoServer(t, false)
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		t.Skip(err)
+	}
+	// every descriptor the test has open now, and nothing more
+	pipe := make([]int, 2)
+	if err := syscall.Pipe(pipe); err != nil {
+		t.Fatal(err)
+	}
+	syscall.Close(pipe[0])
+	syscall.Close(pipe[1])
+	low := rl
+	low.Cur = uint64(pipe[0])
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &low); err != nil {
+		t.Skip(err)
+	}
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp", Count: 5})
+	err := r.Run()
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(err, ErrFDLimit) || len(r.Results) != 0 {
+		t.Errorf("got %v and %d results, want ErrFDLimit and none", err, len(r.Results))
+	}
+}
@@ -0,0 +1,111 @@
+package udping
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// histogramPrecision is the number of sub-bucket bits of a Histogram: each
+// power of two range of RTTs is split into 16 buckets, for a relative error
+// of at most 1/16
+const histogramPrecision = 4
+
+type (
+	// Histogram counts RTTs in log-linear buckets, as HDR histograms do:
+	// one microsecond wide below 2^Precision microseconds, then 2^Precision
+	// buckets per power of two. The bucket boundaries are fixed, so
+	// histograms of separate runs, or of runs on separate hosts, merge by
+	// adding up the counts of equal buckets without keeping any sample.
+	Histogram struct {
+		Unit      string            `json:"unit"`      // Unit of the bucket bounds, always "us"
+		Precision int               `json:"precision"` // Precision is the number of sub-bucket bits
+		Count     int               `json:"count"`     // Count is the number of RTTs counted
+		Buckets   []HistogramBucket `json:"buckets"`   // Buckets are sorted and only the ones counting an RTT are listed
+	}
+
+	// HistogramBucket counts the RTTs from Low up to, but not including, High microseconds
+	HistogramBucket struct {
+		Low   int64 `json:"low"`
+		High  int64 `json:"high"`
+		Count int   `json:"count"`
+	}
+)
+
+// NewHistogram returns an empty Histogram
+func NewHistogram() *Histogram {
+	return &Histogram{Unit: "us", Precision: histogramPrecision, Buckets: []HistogramBucket{}}
+}
+
+// histogramBucket returns the bucket of an RTT of us microseconds
+func histogramBucket(us int64, precision int) HistogramBucket {
+	if us < 1 {
+		us = 1
+	}
+	top := bits.Len64(uint64(us)) - 1
+	if top < precision {
+		return HistogramBucket{Low: us, High: us + 1}
+	}
+	shift := top - precision
+	low := us >> shift << shift
+	return HistogramBucket{Low: low, High: low + 1<<shift}
+}
+
+// Add counts an RTT of rtt seconds
+func (h *Histogram) Add(rtt float64) {
+	b := histogramBucket(int64(math.Round(rtt*1e6)), h.Precision)
+	b.Count = 1
+	h.add(b)
+}
+
+// add adds the count of b to its bucket
+func (h *Histogram) add(b HistogramBucket) {
+	h.Count += b.Count
+	i := sort.Search(len(h.Buckets), func(i int) bool { return h.Buckets[i].Low >= b.Low })
+	if i < len(h.Buckets) && h.Buckets[i].Low == b.Low {
+		h.Buckets[i].Count += b.Count
+		return
+	}
+	h.Buckets = append(h.Buckets, HistogramBucket{})
+	copy(h.Buckets[i+1:], h.Buckets[i:])
+	h.Buckets[i] = b
+}
+
+// Merge adds the counts of o to h. Both must have the same unit and precision.
+func (h *Histogram) Merge(o *Histogram) error {
+	if o.Unit != h.Unit || o.Precision != h.Precision {
+		return fmt.Errorf("cannot merge a histogram of %d bits in %s into one of %d bits in %s", o.Precision, o.Unit, h.Precision, h.Unit)
+	}
+	for _, b := range o.Buckets {
+		h.add(b)
+	}
+	return nil
+}
+
+// Percentile returns the p-th percentile RTT, in seconds: the upper bound of
+// the bucket it falls in, which overstates it by at most 1/2^Precision. It
+// is 0 for an empty histogram.
+func (h *Histogram) Percentile(p float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for _, b := range h.Buckets {
+		if seen += b.Count; seen >= rank {
+			return float64(b.High) / 1e6
+		}
+	}
+	return float64(h.Buckets[len(h.Buckets)-1].High) / 1e6
+}
+
+// clone returns a copy of h that does not share its buckets
+func (h *Histogram) clone() *Histogram {
+	c := *h
+	c.Buckets = append([]HistogramBucket{}, h.Buckets...)
+	return &c
+}
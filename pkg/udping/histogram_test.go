@@ -0,0 +1,51 @@
+package udping
+
+import "testing"
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, tc := range []struct {
+		us        int64
+		low, high int64
+	}{
+		{0, 1, 2},
+		{15, 15, 16},
+		{16, 16, 17},
+		{31, 31, 32},
+		{32, 32, 34},
+		{1000, 992, 1024},
+		{1023, 992, 1024},
+		{1024, 1024, 1088},
+	} {
+		if b := histogramBucket(tc.us, histogramPrecision); b.Low != tc.low || b.High != tc.high {
+			t.Errorf("%dus in [%d, %d), want [%d, %d)", tc.us, b.Low, b.High, tc.low, tc.high)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	for i := 0; i < 90; i++ {
+		a.Add(0.001)
+	}
+	for i := 0; i < 10; i++ {
+		b.Add(0.1)
+	}
+	b.Add(0.001)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.Count != 101 || len(a.Buckets) != 2 || a.Buckets[0].Count != 91 {
+		t.Fatalf("merged %+v, want 91 and 10 RTTs in two buckets", a)
+	}
+	if p50, p99 := a.Percentile(50), a.Percentile(99); p50 != 0.001024 || p99 != 0.102400 {
+		t.Errorf("p50 %v and p99 %v, want the upper bounds of the 1ms and 100ms buckets", p50, p99)
+	}
+	if err := a.Merge(&Histogram{Unit: "us", Precision: 7}); err == nil {
+		t.Error("merged histograms of different precisions")
+	}
+
+	r := run(t, echoServer(t, false), Params{Count: 3, Histogram: true})
+	if h := r.Summary().Histogram; h == nil || h.Count != 3 {
+		t.Errorf("run histogram %+v, want 3 RTTs", h)
+	}
+}
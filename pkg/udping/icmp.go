@@ -0,0 +1,186 @@
+package udping
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	// DefaultICMPSize is the echo data size used by ping(8)
+	DefaultICMPSize = 56
+	// maxICMPSize is the largest echo data that fits in an IPv4 datagram
+	maxICMPSize = 65535 - 20 - 8
+	// maxICMPPattern is the longest fill pattern accepted, as in ping(8)
+	maxICMPPattern = 16
+)
+
+// icmpData returns the ICMP echo body: ICMPSize bytes, 56 when unset,
+// filled by repeating ICMPPattern, or with an incrementing byte sequence
+// when no pattern is given
+func (r *Runner) icmpData() ([]byte, error) {
+	size := r.Parameters.ICMPSize
+	if size == 0 {
+		size = DefaultICMPSize
+	}
+	if size < 0 || size > maxICMPSize {
+		return nil, fmt.Errorf("icmp data size must be between 0 and %d bytes, got %d", maxICMPSize, size)
+	}
+
+	var pattern []byte
+	if r.Parameters.ICMPPattern != "" {
+		var err error
+		if pattern, err = hex.DecodeString(r.Parameters.ICMPPattern); err != nil {
+			return nil, &FieldError{"ICMPPattern", fmt.Errorf("icmp pattern must be hex bytes: %v", err)}
+		}
+		if len(pattern) > maxICMPPattern {
+			return nil, &FieldError{"ICMPPattern", fmt.Errorf("icmp pattern is limited to %d bytes, got %d", maxICMPPattern, len(pattern))}
+		}
+	}
+
+	data := make([]byte, size)
+	for i := range data {
+		if len(pattern) > 0 {
+			data[i] = pattern[i%len(pattern)]
+		} else {
+			data[i] = byte(i)
+		}
+	}
+	return data, nil
+}
+
+// icmpSeq numbers the echo requests of the process so replies can be told apart
+var icmpSeq uint32
+
+// pingIcmp sends an ICMP echo request to the destination IP and waits for the
+// matching echo reply. It needs a raw socket, which usually requires root or
+// CAP_NET_RAW; without one it falls back to the unprivileged datagram ICMP
+// sockets Linux and macOS offer, and fails with an explanation when neither
+// is available. It returns the time from sending the request to its reply.
+func (r *Runner) pingIcmp(ctx context.Context) (time.Duration, error) {
+	dst := net.ParseIP(r.Parameters.ipDest)
+	if dst == nil {
+		return 0, fmt.Errorf("icmp ping requires a destination IP, got %q", r.Parameters.ipDest)
+	}
+	v6 := dst.To4() == nil
+
+	c, privileged, err := r.listenIcmp(v6)
+	if err != nil {
+		return 0, err
+	}
+	defer c.Close()
+	if privileged {
+		r.debugf("raw icmp socket %v -> %v\n", c.LocalAddr(), dst)
+	} else {
+		r.debugf("datagram icmp socket %v -> %v\n", c.LocalAddr(), dst)
+	}
+
+	data, err := r.icmpData()
+	if err != nil {
+		return 0, err
+	}
+	id := os.Getpid() & 0xffff
+	seq := int(atomic.AddUint32(&icmpSeq, 1) & 0xffff)
+	r.last.seq = seq
+	msg := icmp.Message{Code: 0, Body: &icmp.Echo{ID: id, Seq: seq, Data: data}}
+	msg.Type = ipv4.ICMPTypeEcho
+	proto := 1
+	if v6 {
+		msg.Type, proto = ipv6.ICMPTypeEchoRequest, 58
+	}
+	// the kernel fills in the ICMPv6 checksum
+	wb, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.spend(len(wb)); err != nil {
+		return 0, err
+	}
+
+	var to net.Addr = &net.IPAddr{IP: dst}
+	if !privileged {
+		to = &net.UDPAddr{IP: dst}
+	}
+	c.SetDeadline(r.probeDeadline())
+	start := time.Now()
+	if _, err := c.WriteTo(wb, to); err != nil {
+		if isFDExhausted(err) {
+			return 0, fdLimitError()
+		}
+		if os.IsTimeout(err) {
+			return 0, fmt.Errorf(E_Timeout)
+		}
+		return 0, err
+	}
+	r.last.sentAt = start
+	defer watchContext(ctx, c)()
+
+	rb := make([]byte, 65535)
+	for {
+		n, _, err := c.ReadFrom(rb)
+		if err != nil && ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if err != nil {
+			if os.IsTimeout(err) {
+				return 0, fmt.Errorf(E_Timeout)
+			}
+			return 0, err
+		}
+		reply, err := icmp.ParseMessage(proto, rb[:n])
+		if err != nil {
+			continue
+		}
+		echo, ok := reply.Body.(*icmp.Echo)
+		if !ok || (reply.Type != ipv4.ICMPTypeEchoReply && reply.Type != ipv6.ICMPTypeEchoReply) {
+			continue
+		}
+		// datagram sockets only see their own replies and the kernel rewrites the ID
+		if echo.Seq != seq || (privileged && echo.ID != id) {
+			continue
+		}
+		rtt := time.Since(start)
+		r.logf("%v bytes from %v: icmp_seq=%d\n", len(echo.Data), dst, seq)
+		r.last.reply = echo.Data
+		return rtt, r.checkReplyLength(len(echo.Data))
+	}
+}
+
+// listenIcmp opens a raw ICMP socket, or an unprivileged datagram ICMP socket
+// when raw sockets are not permitted. privileged reports which one it is.
+func (r *Runner) listenIcmp(v6 bool) (c *icmp.PacketConn, privileged bool, err error) {
+	raw, dgram, local := "ip4:icmp", "udp4", "0.0.0.0"
+	if v6 {
+		raw, dgram, local = "ip6:ipv6-icmp", "udp6", "::"
+	}
+	if r.Parameters.Source != "" {
+		local = r.Parameters.Source
+	} else if r.Parameters.Interface != "" {
+		// icmp sockets are not dialed, bind them to the interface's address
+		ip, err := interfaceIP(r.Parameters.Interface, r.Parameters.ipDest)
+		if err != nil {
+			return nil, false, err
+		}
+		local = ip.String()
+	}
+	if c, err = icmp.ListenPacket(raw, local); err == nil {
+		return c, true, nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return nil, false, err
+	}
+	if c, err = icmp.ListenPacket(dgram, local); err == nil {
+		return c, false, nil
+	}
+	return nil, false, fmt.Errorf("icmp ping needs raw socket privileges (run as root or grant CAP_NET_RAW) "+
+		"or unprivileged ping sockets (sysctl net.ipv4.ping_group_range): %v", err)
+}
@@ -0,0 +1,68 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+)
+
+// interfaceIP returns the first address of the interface name of the same
+// family as the destination, for the platforms probes cannot be bound to an
+// interface itself on
+func interfaceIP(name, destination string) (net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("interface %q: %v", name, err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %v", name, err)
+	}
+	v6 := false
+	if dst := net.ParseIP(destination); dst != nil {
+		v6 = dst.To4() == nil
+	}
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || (ipn.IP.To4() == nil) != v6 || ipn.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		return ipn.IP, nil
+	}
+	family := "IPv4"
+	if v6 {
+		family = "IPv6"
+	}
+	return nil, fmt.Errorf("interface %s has no %s address", name, family)
+}
+
+// validateInterface checks that the interface probes are bound to exists and
+// can send to the destination. Where probes are sent from the address of the
+// interface instead, a source address must be one of its own.
+func (r *Runner) validateInterface() error {
+	name := r.Parameters.Interface
+	if name == "" {
+		return nil
+	}
+	if bindsToDevice && r.Parameters.Protocol != "icmp" {
+		if _, err := net.InterfaceByName(name); err != nil {
+			return fmt.Errorf("interface %q: %v", name, err)
+		}
+		return nil
+	}
+	ip, err := interfaceIP(name, r.Parameters.ipDest)
+	if err != nil {
+		return err
+	}
+	if r.Parameters.Source == "" {
+		return nil
+	}
+	ifi, _ := net.InterfaceByName(name)
+	addrs, _ := ifi.Addrs()
+	src := net.ParseIP(r.Parameters.Source)
+	for _, a := range addrs {
+		if ipn, ok := a.(*net.IPNet); ok && ipn.IP.Equal(src) {
+			return nil
+		}
+	}
+	return &FieldError{"Source", fmt.Errorf("source address %s is not an address of interface %s, which has %s", src, name, ip)}
+}
@@ -0,0 +1,29 @@
+package udping
+
+import (
+	"os"
+	"syscall"
+)
+
+// bindsToDevice is true where sockets are bound to an interface with
+// SO_BINDTODEVICE, which routes them through the VRF the interface is
+// enslaved to as well
+const bindsToDevice = true
+
+// bindDevice returns a dialer control function binding sockets to the
+// interface name, or nil when name is empty
+func bindDevice(name string) func(network, address string, c syscall.RawConn) error {
+	if name == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+		})
+		if err != nil {
+			return err
+		}
+		return os.NewSyscallError("setsockopt SO_BINDTODEVICE", serr)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package udping
+
+import "syscall"
+
+// bindsToDevice is false where sockets cannot be bound to an interface,
+// probes are sent from the interface's address instead
+const bindsToDevice = false
+
+// bindDevice is not available on this platform
+func bindDevice(name string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package udping
+
+import (
+	"net"
+	"testing"
+)
+
+// loopbackInterface returns the name of the loopback interface, skipping the test without one
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagLoopback != 0 && ifi.Flags&net.FlagUp != 0 {
+			if _, err := interfaceIP(ifi.Name, "127.0.0.1"); err == nil {
+				return ifi.Name
+			}
+		}
+	}
+	t.Skip("no loopback interface with an IPv4 address")
+	return ""
+}
+
+func TestInterface(t *testing.T) {
+	name := loopbackInterface(t)
+	port := echoServer(t, false)
+	r := run(t, port, Params{Count: 2, Payload: "hello", Interface: name})
+	for i, res := range r.Results {
+		if !res.Success || res.Error != "" {
+			t.Errorf("probe %d over %s: success %v, error %q", i, name, res.Success, res.Error)
+		}
+		if res.Interface != name {
+			t.Errorf("probe %d: interface %q, want %q", i, res.Interface, name)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package udping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// loadBurstShare is the share of a second of probes a load run may send back
+// to back, to catch up when the sender was scheduled late
+const loadBurstShare = 0.01
+
+type (
+	// LoadReport is the outcome of a Load run. RTTs are in seconds and only
+	// cover answered probes; they are zero when none was.
+	LoadReport struct {
+		Destination  string  `json:"destination"`
+		IP           string  `json:"ip,omitempty"`
+		Rate         float64 `json:"rate"`                 // Rate is the packet rate asked for, per second
+		AchievedRate float64 `json:"achievedrate"`         // AchievedRate is the rate probes actually went out at, per second
+		Duration     float64 `json:"duration"`             // Duration is how long sending took, in seconds
+		Sent         int     `json:"sent"`                 // Sent is the number of probes written to the socket
+		Answered     int     `json:"answered"`             // Answered is the number of probes that got a reply
+		Refused      int     `json:"refused,omitempty"`    // Refused counts the ICMP port unreachables received
+		SendErrors   int     `json:"senderrors,omitempty"` // SendErrors counts the probes the system failed to send, such as when its buffers were full
+		Duplicates   int     `json:"duplicates,omitempty"` // Duplicates counts repeated replies to a probe
+		Loss         float64 `json:"loss"`                 // Loss is the percentage of probes sent that got no reply
+		MinRTT       float64 `json:"minrtt,omitempty"`
+		AvgRTT       float64 `json:"avgrtt,omitempty"`
+		MaxRTT       float64 `json:"maxrtt,omitempty"`
+	}
+
+	// loadCounter tallies a load run, shared by its sender and receiver
+	loadCounter struct {
+		mu       sync.Mutex
+		rep      LoadReport
+		issued   int      // the last sequence number about to be written
+		answered []uint64 // a bit per sequence number, set once it got a reply
+		rttSum   float64
+		finished bool          // no more probes will be sent
+		allIn    chan struct{} // closed once every probe sent was answered after finished
+	}
+)
+
+// issue notes that probe seq is about to be written, so that its reply is
+// recognized even when it arrives before the write returns
+func (lc *loadCounter) issue(seq int) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.issued = seq
+}
+
+// sent accounts for a probe written with the outcome err
+func (lc *loadCounter) sent(err error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	switch {
+	case err == nil:
+		lc.rep.Sent++
+	case isRefused(err):
+		// an earlier probe's refusal, reported on this write instead of sending it
+		lc.rep.Refused++
+	default:
+		lc.rep.SendErrors++
+	}
+}
+
+// reply accounts for a reply to probe seq that took rtt
+func (lc *loadCounter) reply(seq int, rtt time.Duration) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if seq < 1 || seq > lc.issued {
+		// not one of ours
+		return
+	}
+	word, bit := (seq-1)/64, uint64(1)<<((seq-1)%64)
+	for len(lc.answered) <= word {
+		lc.answered = append(lc.answered, 0)
+	}
+	if lc.answered[word]&bit != 0 {
+		lc.rep.Duplicates++
+		return
+	}
+	lc.answered[word] |= bit
+	lc.rep.Answered++
+	lc.checkAllIn()
+	s := rtt.Seconds()
+	lc.rttSum += s
+	if lc.rep.MinRTT == 0 || s < lc.rep.MinRTT {
+		lc.rep.MinRTT = s
+	}
+	if s > lc.rep.MaxRTT {
+		lc.rep.MaxRTT = s
+	}
+}
+
+// finish notes that no more probes will be sent
+func (lc *loadCounter) finish() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.finished = true
+	lc.checkAllIn()
+}
+
+// checkAllIn closes allIn once sending finished and every probe sent was answered
+func (lc *loadCounter) checkAllIn() {
+	if lc.finished && lc.rep.Answered >= lc.rep.Sent && lc.allIn != nil {
+		close(lc.allIn)
+		lc.allIn = nil
+	}
+}
+
+// refused accounts for an ICMP port unreachable
+func (lc *loadCounter) refused() {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	lc.rep.Refused++
+}
+
+// report returns the tally so far
+func (lc *loadCounter) report() LoadReport {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	rep := lc.rep
+	if rep.Answered > 0 {
+		rep.AvgRTT = lc.rttSum / float64(rep.Answered)
+	}
+	if rep.Sent > 0 {
+		rep.Loss = 100 * float64(rep.Sent-rep.Answered) / float64(rep.Sent)
+	}
+	return rep
+}
+
+// Load sends udp probes to the destination at a fixed rate of pps per second
+// over one socket, without waiting for their replies, and counts the probes
+// answered, for load and soak tests of a service rather than latency
+// measurements. It sends Count probes, or keeps going until ctx is done when
+// Continuous is set, then waits up to Timeout for the replies still on their
+// way, or only until the last of them arrived. Each probe carries the
+// sequence token of the Sequence parameter, which the service must echo back
+// for its reply to be counted. Results are not recorded; OnResult is not
+// called. At high rates probes are sent and replies received in batches, with
+// sendmmsg and recvmmsg on Linux, to save system calls.
+func (r *Runner) Load(ctx context.Context, pps float64) (LoadReport, error) {
+	if err := r.validate(ctx, !r.Strict); err != nil {
+		if ctx.Err() != nil {
+			return LoadReport{}, nil
+		}
+		return LoadReport{}, err
+	}
+	if pps <= 0 {
+		return LoadReport{}, paramError{fmt.Errorf("load rate must be positive, got %v", pps)}
+	}
+	if r.Parameters.Protocol != "udp" || (r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw) || r.Prober != nil {
+		return LoadReport{}, paramError{fmt.Errorf("load runs are only supported for udp pings in raw mode")}
+	}
+	defer r.closeSocket()
+
+	c, release, err := r.udpSocket(ctx, r.dialAddr())
+	if err != nil {
+		return LoadReport{}, err
+	}
+	defer release()
+	stop := watchContext(ctx, c)
+	defer stop()
+
+	allIn := make(chan struct{})
+	lc := &loadCounter{rep: LoadReport{Destination: r.Parameters.Destination, IP: r.Parameters.ipDest, Rate: pps}, allIn: allIn}
+	bc := newBatchConn(c, isIPv6(r.Parameters.ipDest))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ms := newMessages(loadBatch, udpReadBuffer)
+		for {
+			n, err := bc.ReadBatch(ms, 0)
+			if err != nil {
+				if os.IsTimeout(err) || errors.Is(err, net.ErrClosed) {
+					return
+				}
+				if isRefused(err) {
+					lc.refused()
+				}
+				continue
+			}
+			now := time.Now()
+			for _, m := range ms[:n] {
+				if seq, sent, ok := parseSeq(m.Buffers[0][:m.N]); ok {
+					lc.reply(seq, now.Sub(sent))
+				}
+			}
+		}
+	}()
+
+	burst := int(pps * loadBurstShare)
+	if burst < 1 {
+		burst = 1
+	}
+	// a batch is at most the burst, so that probes are not sent further ahead of their time
+	batch := loadBatch
+	if batch > burst {
+		batch = burst
+	}
+	ms := newMessages(batch, 0)
+	limiter := rate.NewLimiter(rate.Limit(pps), burst)
+	base := r.resize(r.rawPayload())
+	r.debugf("load run to %s at %v probes per second\n", c.RemoteAddr(), pps)
+
+	start := time.Now()
+	logged := start
+	for seq := 1; r.Parameters.Continuous || seq <= r.Parameters.Count; {
+		k := batch
+		if !r.Parameters.Continuous && k > r.Parameters.Count-seq+1 {
+			k = r.Parameters.Count - seq + 1
+		}
+		if limiter.WaitN(ctx, k) != nil {
+			break
+		}
+		now := time.Now()
+		out := ms[:0]
+		for i := 0; i < k; i++ {
+			body := r.randomize(base)
+			payload := make([]byte, 0, len(body)+len(seqMarker)+seqDigits)
+			payload = append(append(payload, body...), seqToken(seq+i, now)...)
+			if r.spend(len(payload)) != nil {
+				break
+			}
+			ms[i].Buffers[0] = payload
+			out = ms[:i+1]
+		}
+		if len(out) == 0 {
+			// the budget is a planned stop
+			break
+		}
+		spent := len(out) < k
+		lc.issue(seq + len(out) - 1)
+		for first := seq; len(out) > 0; {
+			n, err := bc.WriteBatch(out, 0)
+			for i := 0; i < n; i++ {
+				lc.sent(nil)
+			}
+			out, first = out[n:], first+n
+			if err != nil && len(out) > 0 {
+				// the first probe left failed, the next ones are tried again
+				lc.sent(err)
+				if !isRefused(err) {
+					r.debugf("probe %d not sent: %v\n", first, err)
+				}
+				out, first = out[1:], first+1
+			}
+		}
+		if spent {
+			break
+		}
+		seq += k
+		if now := time.Now(); now.Sub(logged) >= time.Second {
+			logged = now
+			rep := lc.report()
+			r.logf("%s: sent %d, answered %d\n", r.Parameters.Destination, rep.Sent, rep.Answered)
+		}
+	}
+	elapsed := time.Since(start)
+
+	if ctx.Err() == nil {
+		// the replies still on their way
+		lc.finish()
+		t := time.NewTimer(r.Parameters.Timeout)
+		select {
+		case <-allIn:
+		case <-t.C:
+		case <-ctx.Done():
+		}
+		t.Stop()
+		c.SetReadDeadline(time.Now())
+	}
+	<-done
+
+	rep := lc.report()
+	rep.Duration = elapsed.Seconds()
+	if elapsed > 0 {
+		rep.AchievedRate = float64(rep.Sent) / elapsed.Seconds()
+	}
+	return rep, nil
+}
@@ -0,0 +1,109 @@
+package udping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		port         int
+		wantAnswered bool
+	}{
+		{"echo", echoServer(t, false), true},
+		{"closed", closedPort(t), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := New(Params{Destination: "127.0.0.1", DestinationPort: tc.port, Protocol: "udp", Count: 200, Timeout: 500 * time.Millisecond})
+			rep, err := r.Load(context.Background(), 2000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Sent+rep.Refused < 200 || rep.SendErrors != 0 {
+				t.Errorf("sent %d, refused %d and %d send errors, want 200 probes out", rep.Sent, rep.Refused, rep.SendErrors)
+			}
+			if tc.wantAnswered && (rep.Answered != rep.Sent || rep.Loss != 0 || rep.MaxRTT <= 0) {
+				t.Errorf("answered %d of %d, loss %v, max rtt %v", rep.Answered, rep.Sent, rep.Loss, rep.MaxRTT)
+			}
+			if !tc.wantAnswered && (rep.Answered != 0 || rep.Refused == 0) {
+				t.Errorf("answered %d and refused %d, want only refusals", rep.Answered, rep.Refused)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, p := range []Params{
+		{Destination: "127.0.0.1", DestinationPort: 9, Protocol: "tcp"},
+		{Destination: "127.0.0.1", DestinationPort: 9, Protocol: "udp", Mode: ModeDNS},
+	} {
+		if _, err := New(p).Load(context.Background(), 100); !errors.Is(err, ErrInvalidParameters) {
+			t.Errorf("%s %s: got %v, want an ErrInvalidParameters error", p.Protocol, p.Mode, err)
+		}
+	}
+}
+
+// TestBatchWriteError checks a failed batch write reports no datagrams sent,
+// never a negative count: a datagram too big for udp fails the batch at its
+// first message, and a closed socket fails it before any system call
+func TestBatchWriteError(t *testing.T) {
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", fmt.Sprint(silentServer(t))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bc := newBatchConn(c, false)
+	ms := newMessages(4, 0)
+	for i := range ms {
+		ms[i].Buffers[0] = []byte("Ping!Ping!Ping!")
+	}
+	ms[0].Buffers[0] = make([]byte, 70000)
+	if n, err := bc.WriteBatch(ms, 0); n != 0 || err == nil {
+		t.Errorf("oversized datagram: wrote %d, error %v, want none and an error", n, err)
+	}
+	c.Close()
+	if n, err := bc.WriteBatch(ms[1:], 0); n != 0 || err == nil {
+		t.Errorf("closed socket: wrote %d, error %v, want none and an error", n, err)
+	}
+}
+
+// BenchmarkBatchWrite compares sending probes in batches with sending them
+// one at a time, the load run's two ways of writing; an op is one probe
+func BenchmarkBatchWrite(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		conn func(net.Conn) batchConn
+	}{
+		{"batch", func(c net.Conn) batchConn { return newBatchConn(c, false) }},
+		{"single", func(c net.Conn) batchConn { return singleConn{c} }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			l, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer l.Close()
+			l.SetReadBuffer(1 << 20)
+			c, err := net.Dial("udp", l.LocalAddr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close()
+			bc := bm.conn(c)
+			ms := newMessages(loadBatch, 0)
+			for i := range ms {
+				ms[i].Buffers[0] = []byte("Ping!Ping!Ping!")
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i += loadBatch {
+				if _, err := bc.WriteBatch(ms, 0); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+)
+
+const (
+	ModeNTP = "ntp" // send an NTP client request and require a synchronized server's answer
+
+	E_NTPReply = "invalid ntp reply"
+
+	// ntpPacketLen is the size of an NTP packet without extensions
+	ntpPacketLen = 48
+	// ntpClient is the first byte of a request: no leap warning, version 4, client mode
+	ntpClient = 0<<6 | 4<<3 | 3
+	// ntpServer is the mode of a server's answer to a client
+	ntpServer = 4
+	// ntpUnsynchronized is the leap indicator, and ntpMaxStratum the stratum,
+	// of a server whose clock is not synchronized
+	ntpUnsynchronized = 3
+	ntpMaxStratum     = 16
+)
+
+// ntpProbe builds an NTP client request and returns it alongside a function
+// that checks a reply answers that request. The transmit timestamp is random,
+// as RFC 5905 allows, so that a server's answer carries it back as its origin
+// timestamp without revealing the local clock.
+func (r *Runner) ntpProbe() ([]byte, func([]byte) error, error) {
+	query := make([]byte, ntpPacketLen)
+	query[0] = ntpClient
+	if _, err := rand.Read(query[40:48]); err != nil {
+		return nil, nil, err
+	}
+	transmit := query[40:48]
+
+	check := func(reply []byte) error {
+		if len(reply) < ntpPacketLen {
+			return fmt.Errorf("%s: %d bytes, expected at least %d", E_NTPReply, len(reply), ntpPacketLen)
+		}
+		if mode := reply[0] & 7; mode != ntpServer {
+			return fmt.Errorf("%s: mode %d is not a server answer", E_NTPReply, mode)
+		}
+		if !bytes.Equal(reply[24:32], transmit) {
+			return fmt.Errorf("%s: origin timestamp does not match the request", E_NTPReply)
+		}
+		stratum := reply[1]
+		if stratum == 0 {
+			// a kiss-o'-death packet, its reference id is the reason
+			return r.serviceError(fmt.Errorf("%s: kiss code %q", E_NTPReply, bytes.TrimRight(reply[12:16], "\x00")))
+		}
+		if reply[0]>>6 == ntpUnsynchronized || stratum >= ntpMaxStratum {
+			return fmt.Errorf("%s: server clock is not synchronized", E_NTPReply)
+		}
+		return nil
+	}
+	return query, check, nil
+}
@@ -0,0 +1,70 @@
+package udping
+
+import "time"
+
+// Pinger is the Runner under the name callers of the package look for
+type Pinger = Runner
+
+// Option sets one of the parameters of a Pinger built by NewPinger
+type Option func(p *Params)
+
+// NewPinger returns a Pinger probing destination over udp with the options
+// applied, validated up front so that a bad option is reported before any
+// probe is sent:
+//
+//	p, err := udping.NewPinger("example.com", udping.WithPort(53), udping.WithCount(5))
+//	if err != nil {
+//		return err
+//	}
+//	err = p.Run()
+func NewPinger(destination string, opts ...Option) (*Pinger, error) {
+	params := Params{Destination: destination, Protocol: "udp"}
+	for _, opt := range opts {
+		opt(&params)
+	}
+	r := New(params)
+	if err := r.ValidateParameters(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// WithProtocol probes over udp, tcp or icmp
+func WithProtocol(protocol string) Option {
+	return func(p *Params) { p.Protocol = protocol }
+}
+
+// WithPort sets the destination port of udp and tcp probes
+func WithPort(port int) Option {
+	return func(p *Params) { p.DestinationPort = port }
+}
+
+// WithCount sets how many probes are sent
+func WithCount(count int) Option {
+	return func(p *Params) { p.Count = count }
+}
+
+// WithContinuous keeps probing until the run's context is cancelled
+func WithContinuous() Option {
+	return func(p *Params) { p.Continuous = true }
+}
+
+// WithTimeout sets how long each probe waits for its answer
+func WithTimeout(d time.Duration) Option {
+	return func(p *Params) { p.Timeout = d }
+}
+
+// WithInterval sets the pause between the end of a probe and the start of the next
+func WithInterval(d time.Duration) Option {
+	return func(p *Params) { p.Interval = d }
+}
+
+// WithPayload sets the raw mode payload, literal or 0x-prefixed hex
+func WithPayload(payload string) Option {
+	return func(p *Params) { p.Payload = payload }
+}
+
+// WithMode sets the probe mode: raw, dns, ntp, quic, stun or echo
+func WithMode(mode string) Option {
+	return func(p *Params) { p.Mode = mode }
+}
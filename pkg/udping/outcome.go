@@ -0,0 +1,90 @@
+package udping
+
+import "fmt"
+
+// Outcome is what came of a probe, whatever its protocol and mode. Unlike
+// Success, it does not depend on what the run counts as reachable.
+type Outcome string
+
+const (
+	OutcomeAnswered     Outcome = "answered"      // the probe got the reply it asked for
+	OutcomeOpenFiltered Outcome = "open|filtered" // nothing answered in time, the port may be open and silent or filtered
+	OutcomeClosed       Outcome = "closed"        // the probe was refused, or the network or host is unreachable
+	OutcomeError        Outcome = "error"         // anything else, from a reply failing its checks to a probe that could not be sent
+)
+
+// Success policies, which outcomes a run counts as successful probes
+const (
+	// SuccessReachable counts answered probes, and udp probes refused with an
+	// ICMP port unreachable when no application reply is expected: the host
+	// is up, the port closed. It is the default.
+	SuccessReachable = "reachable"
+	// SuccessAnswered only counts answered probes
+	SuccessAnswered = "answered"
+	// SuccessNotClosed counts every probe that was not closed or failed,
+	// timeouts included, the optimistic view of udp ports that never answer
+	SuccessNotClosed = "not-closed"
+)
+
+// outcome returns the outcome of a probe that failed with an error of class
+func outcome(class ErrorClass) Outcome {
+	switch class {
+	case "":
+		return OutcomeAnswered
+	case ClassTimeout:
+		return OutcomeOpenFiltered
+	case ClassRefused, ClassUnreachable:
+		return OutcomeClosed
+	}
+	return OutcomeError
+}
+
+// serviceError records err, an error a well-formed reply reports, and
+// returns nil in tftp mode or with AnyReply set: the service answered, if
+// only to refuse the request. Otherwise it returns err, failing the probe.
+func (r *Runner) serviceError(err error) error {
+	if r.Parameters.Mode != ModeTFTP && !r.Parameters.AnyReply {
+		return err
+	}
+	r.last.serviceError = err.Error()
+	return nil
+}
+
+// successPolicy returns the success policy of the run, SuccessAnswered when
+// RefusalFails is set
+func (r *Runner) successPolicy() string {
+	if r.Parameters.SuccessPolicy == "" && r.Parameters.RefusalFails {
+		return SuccessAnswered
+	}
+	if r.Parameters.SuccessPolicy == "" {
+		return SuccessReachable
+	}
+	return r.Parameters.SuccessPolicy
+}
+
+// validateSuccessPolicy checks the success policy is a known one that agrees with RefusalFails
+func (r *Runner) validateSuccessPolicy() error {
+	switch r.Parameters.SuccessPolicy {
+	case "", SuccessReachable, SuccessAnswered, SuccessNotClosed:
+	default:
+		return fmt.Errorf("unknown success policy %q, expected %s, %s or %s", r.Parameters.SuccessPolicy, SuccessReachable, SuccessAnswered, SuccessNotClosed)
+	}
+	if r.Parameters.RefusalFails && r.Parameters.SuccessPolicy != "" && r.Parameters.SuccessPolicy != SuccessAnswered {
+		return &FieldError{"RefusalFails", fmt.Errorf("refusals failing is the %s success policy, not %s", SuccessAnswered, r.Parameters.SuccessPolicy)}
+	}
+	return nil
+}
+
+// succeeded tells whether a probe with outcome o and error class counts as
+// successful under the success policy of the run
+func (r *Runner) succeeded(o Outcome, class ErrorClass) bool {
+	switch r.successPolicy() {
+	case SuccessAnswered:
+		return o == OutcomeAnswered
+	case SuccessNotClosed:
+		return o == OutcomeAnswered || o == OutcomeOpenFiltered
+	}
+	// a refusal only counts as reachable for udp when we are not expecting an application reply
+	return o == OutcomeAnswered || (class == ClassRefused && r.Parameters.Protocol == "udp" &&
+		(r.Parameters.Mode == "" || r.Parameters.Mode == ModeRaw) && r.Parameters.Expect == "" && !r.Parameters.ExpectEcho && r.Prober == nil)
+}
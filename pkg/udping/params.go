@@ -0,0 +1,109 @@
+package udping
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationType is the type of the duration fields of Params
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// jsonSeconds is a duration encoded as a number of seconds, the type the
+// duration fields of Params take in their JSON encoding
+type jsonSeconds time.Duration
+
+var jsonSecondsType = reflect.TypeOf(jsonSeconds(0))
+
+func (d jsonSeconds) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.FormatFloat(time.Duration(d).Seconds(), 'f', -1, 64)), nil
+}
+
+// parseSeconds reads a duration written as a bare number of seconds, as on
+// the command line, or as a string such as "250ms" or "2s"
+func parseSeconds(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	d := time.Duration(math.Round(secs * float64(time.Second)))
+	if d == 0 && secs != 0 {
+		return 0, fmt.Errorf("%s seconds is shorter than a nanosecond", s)
+	}
+	return d, nil
+}
+
+// MarshalJSON encodes parameters with their durations as a number of
+// seconds, such as 5 or 0.25, which UnmarshalJSON reads back
+func (p Params) MarshalJSON() ([]byte, error) {
+	v := reflect.ValueOf(p)
+	t := v.Type()
+	var fields []reflect.StructField
+	var index []int
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported, encoding/json leaves it out anyway
+			continue
+		}
+		if f.Type == durationType {
+			f.Type = jsonSecondsType
+		}
+		fields = append(fields, f)
+		index = append(index, i)
+	}
+	out := reflect.New(reflect.StructOf(fields)).Elem()
+	for i, j := range index {
+		out.Field(i).Set(v.Field(j).Convert(fields[i].Type))
+	}
+	return json.Marshal(out.Interface())
+}
+
+// UnmarshalJSON decodes parameters as they are encoded, durations as a
+// number of seconds, also taking durations as strings such as "250ms" or
+// "2s" to be written by hand
+func (p *Params) UnmarshalJSON(b []byte) error {
+	// plain has the fields of Params without this method
+	type plain Params
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	t := reflect.TypeOf(*p)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type != durationType {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		for key, raw := range fields {
+			// keys match fields case-insensitively, as encoding/json matches them
+			if !strings.EqualFold(key, name) || len(raw) == 0 || string(raw) == "null" {
+				continue
+			}
+			s := string(raw)
+			if raw[0] == '"' {
+				if err := json.Unmarshal(raw, &s); err != nil {
+					return err
+				}
+			}
+			d, err := parseSeconds(s)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fields[key] = json.RawMessage(strconv.FormatInt(int64(d), 10))
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, (*plain)(p))
+}
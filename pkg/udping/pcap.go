@@ -0,0 +1,86 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// pcapSnapLen is the largest packet recorded in a capture file
+const pcapSnapLen = 65536
+
+// PcapWriter records probe and reply datagrams to a pcap file readable by
+// Wireshark. The socket only exposes payloads, so IP and UDP headers are
+// rebuilt from the connection's 5-tuple for each packet.
+type PcapWriter struct {
+	mu sync.Mutex
+	f  *os.File
+	w  *pcapgo.Writer
+}
+
+// NewPcapWriter creates the capture file at path
+func NewPcapWriter(path string) (*PcapWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := pcapgo.NewWriter(f)
+	if err := w.WriteFileHeader(pcapSnapLen, layers.LinkTypeRaw); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &PcapWriter{f: f, w: w}, nil
+}
+
+// WriteDatagram records a UDP datagram with payload sent from src to dst at ts
+func (p *PcapWriter) WriteDatagram(ts time.Time, src, dst net.Addr, payload []byte) error {
+	s, ok := src.(*net.UDPAddr)
+	if !ok {
+		return fmt.Errorf("pcap: not a udp address: %v", src)
+	}
+	d, ok := dst.(*net.UDPAddr)
+	if !ok {
+		return fmt.Errorf("pcap: not a udp address: %v", dst)
+	}
+
+	udp := &layers.UDP{SrcPort: layers.UDPPort(s.Port), DstPort: layers.UDPPort(d.Port)}
+	var ip gopacket.SerializableLayer
+	if s.IP.To4() != nil && d.IP.To4() != nil {
+		ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: s.IP.To4(), DstIP: d.IP.To4()}
+		udp.SetNetworkLayerForChecksum(ip4)
+		ip = ip4
+	} else {
+		ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP, SrcIP: s.IP.To16(), DstIP: d.IP.To16()}
+		udp.SetNetworkLayerForChecksum(ip6)
+		ip = ip6
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(payload)); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	ci := gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)}
+	if len(data) > pcapSnapLen {
+		ci.CaptureLength = pcapSnapLen
+		data = data[:pcapSnapLen]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.w.WritePacket(ci, data)
+}
+
+// Close flushes and closes the capture file
+func (p *PcapWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.f.Close()
+}
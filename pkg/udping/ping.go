@@ -0,0 +1,1370 @@
+// Package udping probes udp, tcp and icmp destinations and reports whether
+// they answered and how fast. Build a Runner with New, call Run and read back
+// its Results:
+//
+//	r := udping.New(udping.Params{Destination: "example.com", DestinationPort: 53, Protocol: "udp", Count: 3, Timeout: 5 * time.Second})
+//	if err := r.Run(); err != nil {
+//		return err
+//	}
+//	for _, res := range r.Results {
+//		...
+//	}
+//
+// RunWithCallback and Stream hand each result over as soon as its probe
+// completes instead, as continuous runs need.
+package udping
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	E_Timeout     = "timeout"
+	E_ConnRefused = "connection refused (no response)"
+	E_ReplyLength = "unexpected reply length"
+	E_Mismatch    = "reply does not match expected pattern"
+
+	// DefaultPayload is sent in raw mode when no payload is given
+	DefaultPayload = "Ping!Ping!Ping!"
+
+	// Encodings of the reply bytes kept with ReplyDump
+	DumpHex    = "hex"
+	DumpBase64 = "base64"
+
+	// maxUDPPayload is the largest payload of an IPv4 udp datagram
+	maxUDPPayload = 65535 - 20 - 8
+
+	// maxPrealloc caps how many results Run allocates room for before probing
+	maxPrealloc = 4096
+)
+
+type (
+	// Runner runs the probes described by its Parameters
+	Runner struct {
+		Parameters      Params
+		Results         []Result
+		OnResult        func(res Result)                         // OnResult, if set, is called with each result as soon as its probe completes
+		Logf            func(format string, args ...interface{}) // Logf, if set, receives progress messages such as each probe starting
+		Debugf          func(format string, args ...interface{}) // Debugf, if set, receives details such as resolved addresses, sockets and probe timings
+		Tracef          func(format string, args ...interface{}) // Tracef, if set, receives every udp datagram sent and received, hex encoded
+		Discard         bool                                     // Discard drops results after OnResult instead of keeping them in Results
+		Strict          bool                                     // Strict makes Run use ValidateStrict, so unset parameters are rejected rather than defaulted
+		Capture         *PcapWriter                              // Capture, if set, records every udp datagram sent and received
+		Limiter         *rate.Limiter                            // Limiter, if set, is waited on before each probe is sent; share it to cap the rate of several runners
+		Budget          *Budget                                  // Budget, if set, is drawn from for each probe sent; share it to cap the bytes of several runners
+		Selection       *Selection                               // Selection explains which address was picked when Parameters.Fastest is set
+		BytesSent       int64                                    // BytesSent counts the probe bytes written so far
+		BudgetExhausted bool                                     // BudgetExhausted is set when the run stopped early because of Parameters.ByteBudget or Budget
+		RecvBufferSize  int                                      // RecvBufferSize is the receive buffer the kernel applied to the latest udp socket, when Parameters.RecvBuffer or SendBuffer is set. Linux only.
+		SendBufferSize  int                                      // SendBufferSize is the send buffer the kernel applied to the latest udp socket, as RecvBufferSize
+		BuffersClamped  bool                                     // BuffersClamped is set when the kernel applied a smaller buffer than Parameters.RecvBuffer or SendBuffer, capped by its limits
+		Prober          Prober                                   // Prober, if set, builds the payloads of udp probes and validates their replies in place of Parameters.Mode
+		timeouts        timeoutAdapter                           // adapts the per-probe timeout when Parameters.EscalateTimeout or AdaptiveTimeout is set
+		backoff         backoffFunc                              // delay between dial retries
+		last            probeState                               // details of the probe in flight, copied into its result
+		stats           statsAccumulator                         // summary of the results recorded so far
+		names           map[string]string                        // reverse DNS names of the addresses probed, by address
+		proberSeq       int                                      // payloads built by Prober so far
+		udpConn         net.Conn                                 // socket kept for the run when Parameters.ReuseSocket is set
+		udpAddr         string                                   // destination udpConn is connected to
+		sprayConns      []net.Conn                               // sockets probes rotate over when Parameters.Spray is set, dialled as needed
+		sprayAddr       string                                   // destination the sprayConns are connected to
+		sprayNext       int                                      // probes sent over the sprayConns so far
+		rbuf            []byte                                   // receive buffer of udp probes
+		seq             int                                      // sequence number of the last probe when Parameters.Sequence is set
+		answered        map[int]bool                             // recent sequence numbers that got a reply
+		lookup          *net.Resolver                            // resolver of Parameters.Resolver
+		resolveTime     time.Duration                            // how long the latest lookup of the destination took
+		resolvedAt      time.Time                                // when the destination was last looked up
+		answers         []string                                 // sorted addresses of the latest lookup, of the family probed
+	}
+
+	// probeState holds what a probe learns beyond its outcome and RTT
+	probeState struct {
+		oneWay        *oneWay   // one-way delays measured by an echo probe
+		correlationID string    // token embedded in the payload to match the reply
+		reply         []byte    // the reply that answered the probe
+		seq           int       // sequence number of the icmp echo request, or of the udp probe with Sequence set
+		late          int       // replies to earlier probes received while waiting
+		duplicates    int       // repeated replies to earlier probes received while waiting
+		mappedAddress string    // reflexive address reported by a stun server
+		mtu           int       // mtu reported for a probe too big for the path
+		fragRejected  string    // where a probe too big for the path was rejected, FragLocal or FragPath
+		sipStatus     int       // status code of a sip final response
+		serviceError  string    // error a well-formed reply reported, counted as an answer
+		localAddr     string    // ip:port the udp probe is sent from
+		spraySlot     int       // which of the Spray sockets the udp probe is sent over
+		replyTTL      int       // TTL or hop limit of the reply, 0 when unknown
+		replyTOS      *int      // ToS byte or traffic class of the reply
+		sentAt        time.Time // when the probe went out, the start of its RTT
+		deadline      time.Time // when the probe gives up, its dial and send included
+	}
+
+	// Params is the struct that is sent to the agent for each module run
+	Params struct {
+		Destination      string        `json:"destination"`                // ipv4, ipv6 or fqdn.
+		DestinationPort  int           `json:"destinationport,omitempty"`  // 16 bits integer. Throws an error when used with icmp. Defaults to 80 otherwise.
+		Protocol         string        `json:"protocol"`                   // icmp, tcp, udp
+		Count            int           `json:"count,omitempty"`            // Number of tests
+		Continuous       bool          `json:"continuous,omitempty"`       // Probe until the run is cancelled, ignoring Count.
+		Timeout          time.Duration `json:"timeout,omitempty"`          // Timeout for individual test, from its dial to its reply. defaults to 5s.
+		Spacing          time.Duration `json:"spacing,omitempty"`          // Minimum time from one send to the next, however long each probe waits for its reply. Replaces Interval when set.
+		Interval         time.Duration `json:"interval,omitempty"`         // Pause between the end of a probe and the start of the next one. Ignored when Spacing is set.
+		Payload          string        `json:"payload,omitempty"`          // Payload sent in raw mode, literal or 0x-prefixed hex. {{host}} in a literal payload is replaced with Destination.
+		Mode             string        `json:"mode,omitempty"`             // raw, dns, ntp, quic, stun, snmp, sip, tftp, wireguard or echo. Empty means raw.
+		DNSName          string        `json:"dnsname,omitempty"`          // Name queried in dns mode. Defaults to example.com.
+		DNSType          string        `json:"dnstype,omitempty"`          // Query type in dns mode: A, AAAA or NS. Defaults to A.
+		SNMPCommunity    string        `json:"snmpcommunity,omitempty"`    // Community sent in snmp mode. Defaults to public.
+		WireGuardKey     string        `json:"wireguardkey,omitempty"`     // Base64 public key of the server in wireguard mode, which its handshake initiations are authenticated with.
+		AnyReply         bool          `json:"anyreply,omitempty"`         // Count a well-formed error reply of a service mode as an answer, the service being up, and record its error as ServiceError.
+		IP               string        `json:"ip,omitempty"`               // Probe this address instead of resolving Destination, which is kept for display.
+		ProxyProtocol    string        `json:"proxyprotocol,omitempty"`    // PROXY protocol header sent on tcp pings: v1 or v2. Empty disables it.
+		Proxy            string        `json:"proxy,omitempty"`            // SOCKS5 proxy, socks5://[user:password@]host:port, whose UDP ASSOCIATE relay udp probes are sent through.
+		ResolveEach      bool          `json:"resolveeach,omitempty"`      // Resolve the destination again before every probe instead of once up front.
+		Reresolve        time.Duration `json:"reresolve,omitempty"`        // Resolve the destination again before the first probe this long after the latest lookup, following address changes of long runs. A failed lookup keeps the last address.
+		Resolver         string        `json:"resolver,omitempty"`         // DNS server, ip or ip:port, to resolve the destination with instead of the system's.
+		NoResolve        bool          `json:"noresolve,omitempty"`        // Fail destinations that are not IP addresses instead of resolving them.
+		Fastest          bool          `json:"fastest,omitempty"`          // Probe every resolved IP once and run the test against the fastest one.
+		DialRetries      int           `json:"dialretries,omitempty"`      // Number of times a failed dial is retried within a probe.
+		Retries          int           `json:"retries,omitempty"`          // Number of times a probe that timed out or failed to send with a transient error is sent again before it counts as failed.
+		Backoff          string        `json:"backoff,omitempty"`          // Delay strategy between retries: fixed, linear or exponential. Defaults to exponential.
+		BackoffBase      time.Duration `json:"backoffbase,omitempty"`      // First retry delay. Defaults to 100ms.
+		BackoffCap       time.Duration `json:"backoffcap,omitempty"`       // Longest retry delay. Defaults to 2s.
+		ByteBudget       int64         `json:"bytebudget,omitempty"`       // Maximum number of probe bytes to send. 0 means unlimited.
+		EscalateTimeout  bool          `json:"escalatetimeout,omitempty"`  // Start with a short per-probe timeout and grow it up to Timeout as probes time out.
+		EscalateStart    time.Duration `json:"escalatestart,omitempty"`    // First per-probe timeout when escalating. Defaults to 250ms.
+		AdaptiveTimeout  bool          `json:"adaptivetimeout,omitempty"`  // Start with Timeout and tighten the per-probe timeout to a multiple of the smoothed RTT once replies come in, as TCP computes its retransmission timeout.
+		ICMPSize         int           `json:"icmpsize,omitempty"`         // Size of the icmp echo data in bytes. Defaults to 56.
+		ICMPPattern      string        `json:"icmppattern,omitempty"`      // Hex bytes repeated to fill the icmp echo data.
+		Size             int           `json:"size,omitempty"`             // Pad or truncate the raw payload to this many bytes, before any correlation token. 0 keeps its own length.
+		Pattern          string        `json:"pattern,omitempty"`          // Hex bytes sent in raw mode in place of Payload, repeated to fill Size bytes.
+		Randomize        bool          `json:"randomize,omitempty"`        // Send random bytes of the raw payload's size instead of the payload, fresh for every probe.
+		Correlate        bool          `json:"correlate,omitempty"`        // Append a random token to each payload and only accept replies that contain it.
+		Sequence         bool          `json:"sequence,omitempty"`         // Append a sequence number and send time to each raw or echo payload, only accept replies carrying the probe's own and count late and duplicate ones.
+		ExpectLen        int           `json:"expectlen,omitempty"`        // Expected reply length in bytes. 0 disables the check.
+		ExpectLenMin     bool          `json:"expectlenmin,omitempty"`     // Treat ExpectLen as a minimum instead of an exact length.
+		Expect           string        `json:"expect,omitempty"`           // Substring, or 0x-prefixed hex bytes, a udp reply must contain to count as a success.
+		ExpectEcho       bool          `json:"expectecho,omitempty"`       // Only count a raw mode reply that is the probe's payload itself, as from a plain echo server. An echo with some bytes changed fails with E_Corrupted.
+		ReplyDump        int           `json:"replydump,omitempty"`        // Number of leading reply bytes to include in results, hex encoded. 0 leaves them out.
+		ReplyEncoding    string        `json:"replyencoding,omitempty"`    // Encoding of the reply bytes of ReplyDump: DumpHex or DumpBase64. Empty means hex.
+		TTL              int           `json:"ttl,omitempty"`              // IP TTL, or IPv6 hop limit, of udp probes. 0 keeps the system default.
+		DSCP             int           `json:"dscp,omitempty"`             // DSCP code point marked on udp probes, 0 to 63.
+		TOS              int           `json:"tos,omitempty"`              // Whole ToS byte, or IPv6 traffic class, of udp probes, ECN bits included. Cannot be combined with DSCP.
+		DontFragment     bool          `json:"dontfragment,omitempty"`     // Send udp probes with the DF bit set, failing those too big for the path with E_FragNeeded. Linux only.
+		KernelTimestamps bool          `json:"kerneltimestamps,omitempty"` // Time udp replies by the kernel's receive timestamps, leaving scheduling delays out of RTTs. Linux only.
+		ReplyHeaders     bool          `json:"replyheaders,omitempty"`     // Record the TTL and ToS of udp replies in results, to infer reverse path hop counts and spot remarking. Linux only.
+		RecvBuffer       int           `json:"rcvbuf,omitempty"`           // SO_RCVBUF of udp sockets in bytes, room for the replies of high-rate runs. 0 keeps the system default.
+		SendBuffer       int           `json:"sndbuf,omitempty"`           // SO_SNDBUF of udp sockets in bytes. 0 keeps the system default.
+		Histogram        bool          `json:"histogram,omitempty"`        // Add the distribution of the RTTs to the summary as a mergeable Histogram.
+		Source           string        `json:"source,omitempty"`           // Local address probes are sent from. Empty lets the system choose.
+		SourcePort       int           `json:"sourceport,omitempty"`       // Local port udp probes are sent from. 0 lets the system choose.
+		Interface        string        `json:"interface,omitempty"`        // Network interface probes are sent through, or VRF device. Bound with SO_BINDTODEVICE on Linux; elsewhere, and for icmp pings, probes are sent from the interface's address.
+		RefusalFails     bool          `json:"refusalfails,omitempty"`     // Count a udp probe refused with an ICMP port unreachable as a failure rather than as reachable, the answered SuccessPolicy.
+		SuccessPolicy    string        `json:"successpolicy,omitempty"`    // Outcomes that count as success: reachable, answered or not-closed. Defaults to reachable.
+		Burst            int           `json:"burst,omitempty"`            // Send udp probes in rounds of this many back to back over one socket, matching the replies of a round by sequence number. Implies Sequence; 0 or 1 sends them one at a time.
+		Spray            int           `json:"spray,omitempty"`            // Rotate udp probes over this many source ports, each kept on its own socket for the run, so that they hash onto different ECMP paths. The ports follow SourcePort when it is set. A late reply may be taken for the answer to a later probe from the same port, as with ReuseSocket.
+		ReuseSocket      bool          `json:"reusesocket,omitempty"`      // Send every udp probe of the run over one socket instead of a fresh one each. A late reply to a probe may then be taken for the answer to the next, unless Correlate or Sequence is set or the mode matches replies to their probe.
+		ReverseLookup    bool          `json:"reverselookup,omitempty"`    // Look up the reverse DNS name of the address each probe is sent to.
+		IPv4Only         bool          `json:"ipv4only,omitempty"`         // Reject IPv6 addresses and only probe the IPv4 addresses of Destination.
+		IPv6Only         bool          `json:"ipv6only,omitempty"`         // Reject IPv4 addresses and only probe the IPv6 addresses of Destination.
+		ipDest           string
+	}
+
+	// Result is the struct that is returned to the scheduler with the results of a module run
+	Result struct {
+		Success         bool       `json:"success"`                   // Success is true if the module was able to connect to the destination, as the SuccessPolicy of the run has it
+		Outcome         Outcome    `json:"outcome,omitempty"`         // Outcome is what came of the probe: answered, open|filtered, closed or error
+		Error           string     `json:"error,omitempty"`           // Error contains any error that occurred during the module run
+		Destination     string     `json:"destination"`               // Destination is the IP address or hostname of the destination
+		IP              string     `json:"ip,omitempty"`              // IP is the address the probe was sent to, Destination resolved
+		ResolvedName    string     `json:"resolvedname,omitempty"`    // ResolvedName is the reverse DNS name of IP, when ReverseLookup is set and it has one
+		ResolveTime     float64    `json:"resolvetime,omitempty"`     // ResolveTime is how long looking up IP took, in seconds, 0 for an IP destination
+		AnswersChanged  bool       `json:"answerschanged,omitempty"`  // AnswersChanged is set when the lookup before this probe, with ResolveEach or Reresolve, returned other addresses than the one before
+		Answers         []string   `json:"answers,omitempty"`         // Answers are the addresses the destination resolved to, when AnswersChanged is set
+		Source          string     `json:"source,omitempty"`          // Source is the local address the probe was sent from, when one was configured
+		Interface       string     `json:"interface,omitempty"`       // Interface is the network interface the probe was bound to, when one was configured
+		SourcePort      int        `json:"sourceport,omitempty"`      // SourcePort is the local port the udp probe was sent from, when SourcePort or Spray was set
+		DestinationPort float64    `json:"destinationport,omitempty"` // DestinationPort is the port number of the destination
+		Protocol        string     `json:"protocol"`                  // Protocol is the protocol used for the ping
+		State           string     `json:"state,omitempty"`           // State is what the probe tells of the port: open, closed, filtered or, for udp without a reply, open|filtered
+		Class           ErrorClass `json:"class,omitempty"`           // Class is the kind of failure Error is, the same on every platform; empty on success
+		CorrelationID   string     `json:"correlationid,omitempty"`   // CorrelationID is the token embedded in the probe payload to match its reply
+		Seq             int        `json:"seq,omitempty"`             // Seq is the sequence number of an icmp echo request, or of a udp probe with Sequence set
+		Late            int        `json:"late,omitempty"`            // Late counts replies to earlier probes, out of order, received while waiting for this one's
+		Duplicates      int        `json:"duplicates,omitempty"`      // Duplicates counts repeated replies to earlier probes received while waiting for this one's
+		MappedAddress   string     `json:"mappedaddress,omitempty"`   // MappedAddress is the ip:port a stun server saw the probe come from, the public address behind a NAT
+		MTU             int        `json:"mtu,omitempty"`             // MTU is the largest packet the path or local interface takes, when a DontFragment probe was too big
+		FragRejected    string     `json:"fragrejected,omitempty"`    // FragRejected is where a DontFragment probe too big for the path was rejected: FragLocal before it left this host, FragPath by a router on the way
+		SIPStatus       int        `json:"sipstatus,omitempty"`       // SIPStatus is the status code of the final response to a sip mode probe, such as 200
+		ServiceError    string     `json:"serviceerror,omitempty"`    // ServiceError is the error a well-formed reply reported while counting as an answer: the ERROR of a tftp probe, or any service error with AnyReply set
+		ReplyTTL        int        `json:"replyttl,omitempty"`        // ReplyTTL is the IP TTL, or IPv6 hop limit, the reply arrived with, when ReplyHeaders is set
+		ReplyTOS        *int       `json:"replytos,omitempty"`        // ReplyTOS is the ToS byte, or IPv6 traffic class, the reply arrived with, when ReplyHeaders is set
+		ReverseHops     int        `json:"reversehops,omitempty"`     // ReverseHops is how many routers the reply crossed, inferred from ReplyTTL and the usual initial TTLs
+		BytesReceived   int        `json:"bytesreceived,omitempty"`   // BytesReceived is the size of the reply
+		Reply           string     `json:"reply,omitempty"`           // Reply holds the first ReplyDump bytes of the reply, encoded as ReplyEncoding says
+		Timeout         float64    `json:"timeout,omitempty"`         // Timeout is the per-probe timeout used, in seconds, when it adapts during the run
+		Attempts        int        `json:"attempts,omitempty"`        // Attempts is how many times the probe was sent, when Retries is set
+		RTT             float64    `json:"rtt,omitempty"`             // RTT is the round trip time of the packet, in seconds, from the payload being written to its answer
+		RTTMs           float64    `json:"rtt_ms,omitempty"`          // RTTMs is RTT in milliseconds
+		SentAt          *time.Time `json:"sent_at,omitempty"`         // SentAt is when the probe was sent, the start of its RTT; unset when it never was
+		ReceivedAt      *time.Time `json:"received_at,omitempty"`     // ReceivedAt is when its answer arrived, SentAt plus RTT
+		ForwardDelay    float64    `json:"forwarddelay,omitempty"`    // ForwardDelay is the one-way delay to an echo server, in seconds
+		ReverseDelay    float64    `json:"reversedelay,omitempty"`    // ReverseDelay is the one-way delay back from an echo server, in seconds
+	}
+)
+
+// New returns a Runner for the given parameters
+func New(p Params) *Runner {
+	return &Runner{Parameters: p}
+}
+
+// logf passes a progress message to Logf, if set
+func (r *Runner) logf(format string, args ...interface{}) {
+	if r.Logf != nil {
+		r.Logf(format, args...)
+	}
+}
+
+// ErrInvalidParameters matches, with errors.Is, every error returned for
+// parameters that failed validation, including destinations that do not resolve
+var ErrInvalidParameters = errors.New("invalid parameters")
+
+// paramError is a validation error. Its message is the underlying error's alone.
+type paramError struct{ err error }
+
+func (e paramError) Error() string        { return e.err.Error() }
+func (e paramError) Unwrap() error        { return e.err }
+func (e paramError) Is(target error) bool { return target == ErrInvalidParameters }
+
+// debugf passes a detail message to Debugf, if set
+func (r *Runner) debugf(format string, args ...interface{}) {
+	if r.Debugf != nil {
+		r.Debugf(format, args...)
+	}
+}
+
+// tracef passes a datagram dump to Tracef, if set
+func (r *Runner) tracef(format string, args ...interface{}) {
+	if r.Tracef != nil {
+		r.Tracef(format, args...)
+	}
+}
+
+// ValidateParameters validates the parameters that are sent to the module.
+// Unset fields are given their defaults: Timeout becomes 5 seconds and Count 3.
+// The error is a *ValidationError listing every problem found, field by field.
+func (r *Runner) ValidateParameters() error {
+	return r.validate(context.Background(), true)
+}
+
+// ValidateStrict validates the parameters like ValidateParameters, but
+// never changes them: an unset Timeout or Count is an error instead.
+func (r *Runner) ValidateStrict() error {
+	return r.validate(context.Background(), false)
+}
+
+// validate checks the parameters, filling in defaults when lenient is set,
+// and returns every problem found as a *ValidationError. Resolving the
+// destination gives up once ctx is done.
+func (r *Runner) validate(ctx context.Context, lenient bool) error {
+	v := &ValidationError{}
+	if r.Parameters.IPv4Only && r.Parameters.IPv6Only {
+		v.add("IPv6Only", fmt.Errorf("IPv4 only and IPv6 only cannot both be set"))
+	}
+	// tcp and udp pings must have a destination port
+	if r.Parameters.Protocol != "icmp" && (r.Parameters.DestinationPort < 0 || r.Parameters.DestinationPort > 65535) {
+		v.add("DestinationPort", fmt.Errorf("%s ping requires a valid destination port between 0 and 65535, got %d",
+			r.Parameters.Protocol, r.Parameters.DestinationPort))
+	}
+	if r.Parameters.Protocol == "icmp" && r.Parameters.DestinationPort != 0 {
+		v.add("DestinationPort", fmt.Errorf("icmp ping does not use a destination port, got %d", r.Parameters.DestinationPort))
+	}
+	// a bracketed IPv6 literal, as written in URLs and host:port pairs
+	if d := r.Parameters.Destination; strings.HasPrefix(d, "[") && strings.HasSuffix(d, "]") {
+		r.Parameters.Destination = d[1 : len(d)-1]
+	}
+	resolvable := !r.Parameters.IPv4Only || !r.Parameters.IPv6Only
+	if r.Parameters.Resolver != "" {
+		resolver, err := resolverAddr(r.Parameters.Resolver)
+		if err != nil {
+			v.add("Resolver", err)
+			resolvable = false
+		} else {
+			r.Parameters.Resolver = resolver
+		}
+	}
+	if resolvable {
+		ip, err := r.resolve(ctx)
+		if err != nil && ctx.Err() != nil {
+			// cancelled, the other problems do not matter
+			return ctx.Err()
+		}
+		if err != nil {
+			v.add("Destination", err)
+		} else {
+			r.Parameters.ipDest = ip
+			r.debugf("%s resolved to %s in %v\n", r.Parameters.Destination, ip, r.resolveTime)
+		}
+	}
+
+	v.add("Source", r.validateSource())
+	v.add("Interface", r.validateInterface())
+
+	if r.Parameters.Spacing < 0 {
+		v.add("Spacing", fmt.Errorf("probe spacing must not be negative, got %v", r.Parameters.Spacing))
+	}
+	if _, _, err := hexPayload(r.Parameters.Payload); err != nil {
+		v.add("Payload", err)
+	}
+	if r.Parameters.Pattern != "" {
+		pattern, err := hex.DecodeString(r.Parameters.Pattern)
+		if err != nil || len(pattern) == 0 {
+			v.add("Pattern", fmt.Errorf("payload pattern must be hex bytes, got %q", r.Parameters.Pattern))
+		}
+	}
+	if n := len(r.rawPayload()); r.Parameters.Size == 0 && n > maxUDPPayload {
+		v.add("Payload", fmt.Errorf("payload is limited to %d bytes, got %d", maxUDPPayload, n))
+	}
+	if r.Parameters.Interval < 0 {
+		v.add("Interval", fmt.Errorf("probe interval must not be negative, got %v", r.Parameters.Interval))
+	}
+
+	switch r.Parameters.Mode {
+	case "", ModeRaw, ModeEcho, ModeNTP, ModeQUIC, ModeSTUN, ModeSNMP:
+	case ModeDNS:
+		if _, err := r.dnsQuestion(); err != nil {
+			v.add("DNSName", err)
+		}
+	case ModeWireGuard:
+		if _, err := wgPublicKey(r.Parameters.WireGuardKey); err != nil {
+			v.add("WireGuardKey", err)
+		}
+	case ModeSIP:
+		if r.Parameters.DestinationPort == 0 {
+			r.Parameters.DestinationPort = SIPPort
+		}
+	case ModeTFTP:
+		if r.Parameters.DestinationPort == 0 {
+			r.Parameters.DestinationPort = TFTPPort
+		}
+		if r.Parameters.Proxy != "" || r.Parameters.KernelTimestamps || r.Parameters.ReplyHeaders {
+			v.add("Mode", fmt.Errorf("tftp probes cannot be sent through a proxy or with kernel timestamps or reply headers, their replies come from another port"))
+		}
+	default:
+		v.add("Mode", fmt.Errorf("unknown probe mode %q, expected %s, %s, %s, %s, %s, %s, %s, %s, %s or %s", r.Parameters.Mode, ModeRaw, ModeDNS, ModeNTP, ModeQUIC, ModeSTUN, ModeSNMP, ModeSIP, ModeTFTP, ModeWireGuard, ModeEcho))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw {
+		// tcp and icmp pings only check that the destination answers
+		v.add("Mode", fmt.Errorf("probe mode %s is only supported for udp pings", r.Parameters.Mode))
+	}
+	if r.Prober != nil && (r.Parameters.Protocol != "udp" || (r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw)) {
+		v.add("Mode", fmt.Errorf("a prober is only supported for udp pings in raw mode"))
+	}
+	if r.Parameters.Randomize && r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw {
+		v.add("Randomize", fmt.Errorf("random payloads are only supported in raw mode"))
+	}
+	if r.Parameters.Size != 0 && r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw {
+		v.add("Size", fmt.Errorf("payload size is only supported in raw mode"))
+	}
+	if r.Parameters.Pattern != "" && r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw {
+		v.add("Pattern", fmt.Errorf("payload patterns are only supported in raw mode"))
+	}
+	if r.Parameters.Size < 0 || r.Parameters.Size > maxUDPPayload {
+		v.add("Size", fmt.Errorf("payload size must be between 0 and %d bytes, got %d", maxUDPPayload, r.Parameters.Size))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.Expect != "" {
+		v.add("Expect", fmt.Errorf("expected reply patterns are only supported for udp pings"))
+	}
+	if r.Parameters.ExpectEcho && (r.Parameters.Protocol != "udp" || (r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw)) {
+		v.add("ExpectEcho", fmt.Errorf("expecting an echo is only supported for udp pings in raw mode, echo mode checks its own server's replies"))
+	}
+	if r.Parameters.Sequence && (r.Parameters.Protocol != "udp" || (r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw && r.Parameters.Mode != ModeEcho)) {
+		v.add("Sequence", fmt.Errorf("sequence numbers are only supported for udp pings in raw or echo mode"))
+	}
+	if r.Parameters.Burst < 0 {
+		v.add("Burst", fmt.Errorf("burst must not be negative, got %d", r.Parameters.Burst))
+	}
+	if r.Parameters.Burst > 1 {
+		if r.Parameters.Protocol != "udp" || (r.Parameters.Mode != "" && r.Parameters.Mode != ModeRaw && r.Parameters.Mode != ModeEcho) || r.Prober != nil {
+			v.add("Burst", fmt.Errorf("bursts are only supported for udp pings in raw or echo mode, whose replies carry sequence numbers"))
+		}
+		if r.Parameters.Retries > 0 || r.reresolves() {
+			v.add("Burst", fmt.Errorf("bursts cannot be combined with retries or resolving before each probe"))
+		}
+		// replies of a round are told apart by their sequence number
+		r.Parameters.Sequence = true
+	}
+	v.add("Spray", r.validateSpray())
+	if r.Parameters.Protocol != "udp" && r.Parameters.ReuseSocket {
+		v.add("ReuseSocket", fmt.Errorf("socket reuse is only supported for udp pings"))
+	}
+	r.validateBuffers(v)
+	if r.Parameters.Protocol != "udp" && r.Parameters.DontFragment {
+		v.add("DontFragment", fmt.Errorf("don't fragment is only supported for udp pings"))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.KernelTimestamps {
+		v.add("KernelTimestamps", fmt.Errorf("kernel timestamps are only supported for udp pings"))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.ReplyHeaders {
+		v.add("ReplyHeaders", fmt.Errorf("reply headers are only supported for udp pings"))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.TTL != 0 {
+		v.add("TTL", fmt.Errorf("ttl is only supported for udp pings"))
+	}
+	if r.Parameters.TTL < 0 || r.Parameters.TTL > maxTTL {
+		v.add("TTL", fmt.Errorf("ttl must be between 0 and %d, got %d", maxTTL, r.Parameters.TTL))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.DSCP != 0 {
+		v.add("DSCP", fmt.Errorf("dscp is only supported for udp pings"))
+	}
+	if r.Parameters.DSCP < 0 || r.Parameters.DSCP > maxDSCP {
+		v.add("DSCP", fmt.Errorf("dscp must be between 0 and %d, got %d", maxDSCP, r.Parameters.DSCP))
+	}
+	if r.Parameters.Protocol != "udp" && r.Parameters.TOS != 0 {
+		v.add("TOS", fmt.Errorf("tos is only supported for udp pings"))
+	}
+	if r.Parameters.TOS < 0 || r.Parameters.TOS > maxTOS {
+		v.add("TOS", fmt.Errorf("tos must be between 0 and %d, got %d", maxTOS, r.Parameters.TOS))
+	}
+	if r.Parameters.TOS != 0 && r.Parameters.DSCP != 0 {
+		v.add("TOS", fmt.Errorf("tos and dscp cannot both be set"))
+	}
+
+	if r.Parameters.Reresolve < 0 {
+		v.add("Reresolve", fmt.Errorf("re-resolution interval must not be negative, got %v", r.Parameters.Reresolve))
+	}
+	if r.Parameters.Fastest && r.reresolves() {
+		v.add("Fastest", fmt.Errorf("fastest address selection cannot be combined with resolving before each probe"))
+	}
+	if r.Parameters.IP != "" && (r.Parameters.Fastest || r.reresolves()) {
+		v.add("IP", fmt.Errorf("a fixed destination IP cannot be combined with address selection or re-resolution"))
+	}
+
+	if r.Parameters.ProxyProtocol != "" {
+		if r.Parameters.Protocol != "tcp" {
+			v.add("ProxyProtocol", fmt.Errorf("proxy protocol is only supported for tcp pings, got %s", r.Parameters.Protocol))
+		}
+		if r.Parameters.ProxyProtocol != ProxyV1 && r.Parameters.ProxyProtocol != ProxyV2 {
+			v.add("ProxyProtocol", fmt.Errorf("unknown proxy protocol version %q, expected %s or %s", r.Parameters.ProxyProtocol, ProxyV1, ProxyV2))
+		}
+	}
+
+	if r.Parameters.Proxy != "" {
+		if r.Parameters.Protocol != "udp" {
+			v.add("Proxy", fmt.Errorf("socks proxies are only supported for udp pings, got %s", r.Parameters.Protocol))
+		}
+		if _, err := socksProxy(r.Parameters.Proxy); err != nil {
+			v.add("Proxy", err)
+		}
+		if r.Parameters.TTL != 0 || r.Parameters.DSCP != 0 || r.Parameters.TOS != 0 || r.Parameters.DontFragment ||
+			r.Parameters.KernelTimestamps || r.Parameters.ReplyHeaders || r.Parameters.RecvBuffer != 0 || r.Parameters.SendBuffer != 0 {
+			v.add("Proxy", fmt.Errorf("socket options of udp probes cannot be set through a socks proxy, which sends them from its own socket"))
+		}
+	}
+
+	v.add("SuccessPolicy", r.validateSuccessPolicy())
+
+	if r.Parameters.DialRetries < 0 {
+		v.add("DialRetries", fmt.Errorf("dial retries must not be negative, got %d", r.Parameters.DialRetries))
+	}
+	backoff, err := newBackoff(r.Parameters.Backoff, r.Parameters.BackoffBase, r.Parameters.BackoffCap)
+	v.add("Backoff", err)
+	r.backoff = backoff
+
+	if r.Parameters.Protocol == "icmp" {
+		if _, err := r.icmpData(); err != nil {
+			v.add("ICMPSize", err)
+		}
+	}
+
+	if r.Parameters.Retries < 0 {
+		v.add("Retries", fmt.Errorf("retries must not be negative, got %d", r.Parameters.Retries))
+	}
+
+	if r.Parameters.ByteBudget < 0 {
+		v.add("ByteBudget", fmt.Errorf("byte budget must not be negative, got %d", r.Parameters.ByteBudget))
+	}
+
+	if r.Parameters.ReplyDump < 0 {
+		v.add("ReplyDump", fmt.Errorf("reply dump size must not be negative, got %d", r.Parameters.ReplyDump))
+	}
+	switch r.Parameters.ReplyEncoding {
+	case "", DumpHex, DumpBase64:
+	default:
+		v.add("ReplyEncoding", fmt.Errorf("unknown reply encoding %q, expected %s or %s", r.Parameters.ReplyEncoding, DumpHex, DumpBase64))
+	}
+
+	if _, _, err := hexPayload(r.Parameters.Expect); err != nil {
+		v.add("Expect", fmt.Errorf("invalid expected pattern: %v", err))
+	}
+
+	if r.Parameters.ExpectLen < 0 {
+		v.add("ExpectLen", fmt.Errorf("expected reply length must not be negative, got %d", r.Parameters.ExpectLen))
+	}
+
+	// if timeout is not set, default to 5 seconds
+	if r.Parameters.Timeout < 0 {
+		v.add("Timeout", fmt.Errorf("timeout must not be negative, got %v", r.Parameters.Timeout))
+	}
+	if r.Parameters.Timeout == 0 {
+		if !lenient {
+			v.add("Timeout", fmt.Errorf("timeout is required"))
+		} else {
+			r.Parameters.Timeout = 5 * time.Second
+		}
+	}
+
+	if r.Parameters.EscalateTimeout && r.Parameters.AdaptiveTimeout {
+		v.add("EscalateTimeout", fmt.Errorf("escalating and adaptive timeouts cannot be combined"))
+	}
+	if r.Parameters.EscalateStart < 0 {
+		v.add("EscalateStart", fmt.Errorf("escalation start must not be negative, got %v", r.Parameters.EscalateStart))
+	}
+
+	// if count of pings is not set, default to 3
+	if r.Parameters.Count < 0 {
+		v.add("Count", fmt.Errorf("count must be at least 1, got %d", r.Parameters.Count))
+	}
+	if r.Parameters.Count == 0.0 && !r.Parameters.Continuous {
+		if !lenient {
+			v.add("Count", fmt.Errorf("count is required"))
+		} else {
+			r.Parameters.Count = 3
+		}
+	}
+	return v.err()
+}
+
+// resolve looks up the destination and returns the IP to probe
+func (r *Runner) resolve(ctx context.Context) (string, error) {
+	if r.Parameters.IP != "" {
+		if net.ParseIP(r.Parameters.IP) == nil {
+			return "", fmt.Errorf("destination IP is invalid: %v", r.Parameters.IP)
+		}
+		if err := r.familyError(r.Parameters.IP); err != nil {
+			return "", err
+		}
+		return r.Parameters.IP, nil
+	}
+
+	r.resolveTime = 0
+	if net.ParseIP(r.Parameters.Destination) == nil && r.Parameters.NoResolve {
+		return "", fmt.Errorf("%s is not an IP address and resolving is disabled", r.Parameters.Destination)
+	}
+
+	// if the destination is a FQDN, resolve it and take the first IP returned as the dest
+	ips, err := r.lookupHost(ctx)
+	if err != nil && ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	ip := ""
+	// Get ip based on destination.
+	// if ip == nil, destination may not be a hostname.
+	if err != nil {
+		ip = r.Parameters.Destination
+	} else {
+		if len(ips) == 0 {
+			return "", fmt.Errorf("FQDN does not resolve to any known ip")
+		}
+		if ips = r.filterFamily(ips); len(ips) == 0 {
+			if net.ParseIP(r.Parameters.Destination) != nil {
+				return "", r.familyError(r.Parameters.Destination)
+			}
+			return "", fmt.Errorf("%s has no %s address", r.Parameters.Destination, r.family())
+		}
+		ip = ips[0]
+		r.resolvedAt = time.Now()
+		r.answers = sortedAddrs(ips)
+	}
+
+	// check the format of the destination IP
+	ip_parsed := net.ParseIP(ip)
+	if ip_parsed == nil {
+		return "", fmt.Errorf("destination IP is invalid: %v", ip)
+	}
+	if err := r.familyError(ip); err != nil {
+		return "", err
+	}
+	return ip, nil
+}
+
+// family returns the address family required by IPv4Only or IPv6Only, empty when any will do
+func (r *Runner) family() string {
+	switch {
+	case r.Parameters.IPv4Only:
+		return "IPv4"
+	case r.Parameters.IPv6Only:
+		return "IPv6"
+	}
+	return ""
+}
+
+// network returns base, udp or tcp, narrowed to the family required by IPv4Only or IPv6Only
+func (r *Runner) network(base string) string {
+	switch {
+	case r.Parameters.IPv4Only:
+		return base + "4"
+	case r.Parameters.IPv6Only:
+		return base + "6"
+	}
+	return base
+}
+
+// isIPv6 reports whether ip is an IPv6 address, IPv4-mapped addresses excluded
+func isIPv6(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() == nil
+}
+
+// familyError reports why ip may not be probed under IPv4Only or IPv6Only, nil when it may
+func (r *Runner) familyError(ip string) error {
+	if (r.Parameters.IPv4Only && isIPv6(ip)) || (r.Parameters.IPv6Only && !isIPv6(ip)) {
+		return fmt.Errorf("destination %v is not an %s address", ip, r.family())
+	}
+	return nil
+}
+
+// filterFamily returns the addresses among ips of the family required by IPv4Only or IPv6Only, in order
+func (r *Runner) filterFamily(ips []string) []string {
+	var out []string
+	for _, ip := range ips {
+		if r.familyError(ip) == nil {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// probeTimeout returns how long the current probe waits for an answer
+func (r *Runner) probeTimeout() time.Duration {
+	if r.timeouts != nil {
+		return r.timeouts.next()
+	}
+	return r.Parameters.Timeout
+}
+
+// probeDeadline returns when the current probe gives up. The timeout runs
+// from the start of the probe, so that a slow dial or a blocked send eats
+// into the wait for the reply rather than adding to it. Outside of a probe,
+// as when a load run dials its socket, it is Timeout from now.
+func (r *Runner) probeDeadline() time.Time {
+	if r.last.deadline.IsZero() {
+		return time.Now().Add(r.Parameters.Timeout)
+	}
+	return r.last.deadline
+}
+
+// dialAddr returns the ip:port a probe connects to. It dials the address
+// validated for this probe rather than letting Dial look the name up again,
+// which could pick another address on every probe.
+func (r *Runner) dialAddr() string {
+	host := r.Parameters.ipDest
+	if host == "" {
+		// not validated yet
+		host = r.Parameters.Destination
+	}
+	return net.JoinHostPort(host, fmt.Sprintf("%d", int(r.Parameters.DestinationPort)))
+}
+
+// dial connects to addr, retrying failed attempts up to DialRetries times
+// with the configured backoff, giving up at deadline, retries included.
+// Refused or timed out tcp connections are an answer from the network rather
+// than a transient failure and are not retried.
+func (r *Runner) dial(ctx context.Context, network, addr string, deadline time.Time) (net.Conn, error) {
+	local, err := r.localAddr(network)
+	if err != nil {
+		return nil, err
+	}
+	d := net.Dialer{Deadline: deadline, LocalAddr: local, Control: bindDevice(r.Parameters.Interface)}
+	for attempt := 1; ; attempt++ {
+		c, err := d.DialContext(ctx, network, addr)
+		if err == nil || attempt > r.Parameters.DialRetries || isFDExhausted(err) || os.IsTimeout(err) || isRefused(err) {
+			return c, err
+		}
+		if !deadline.IsZero() && time.Now().Add(r.backoff(attempt)).After(deadline) {
+			// no time left for another attempt
+			return nil, err
+		}
+		if !sleepContext(ctx, r.backoff(attempt)) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// sleepContext sleeps for d, returning early with false once ctx is done
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// watchContext makes blocked reads and writes on c return as soon as ctx is
+// done, even before their own deadline. The returned function stops watching.
+func watchContext(ctx context.Context, c interface{ SetDeadline(time.Time) error }) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// pingUdp sends a UDP packet to a destination ip:port to determine if it is open or closed.
+// Because UDP does not reply to connection requests, a lack of response may indicate that the
+// port is open, or that the packet got dropped. We chose to be optimistic and treat lack of
+// response (connection timeout) as an open port.
+// It returns the time from sending the packet to its reply or refusal.
+func (r *Runner) pingUdp(ctx context.Context) (time.Duration, error) {
+	destination := r.dialAddr()
+
+	c, release, err := r.udpSocket(ctx, destination)
+	if err != nil {
+		if isFDExhausted(err) {
+			return 0, fdLimitError()
+		}
+		r.logf("%v\n", err)
+		return 0, err
+	}
+	r.last.localAddr = c.LocalAddr().String()
+
+	payload, check, err := r.probe()
+	if err == nil {
+		err = r.spend(len(payload))
+	}
+	if err != nil {
+		release()
+		return 0, err
+	}
+
+	deadline := r.probeDeadline()
+	c.SetDeadline(deadline)
+	sent := time.Now()
+	if _, err := c.Write(payload); err != nil {
+		// the socket is needed to read why a probe too big was refused
+		defer release()
+		r.debugf("send to %v failed: %v\n", c.RemoteAddr(), err)
+		if os.IsTimeout(err) {
+			// a send buffer that stayed full until the deadline
+			return 0, fmt.Errorf(E_Timeout)
+		}
+		if r.Parameters.DontFragment && errors.Is(err, syscall.EMSGSIZE) {
+			if err := r.icmpError(c); err != nil {
+				return 0, err
+			}
+			// refused by the send call itself, without an error queued
+			r.last.fragRejected = FragLocal
+			if r.last.mtu = cachedMTU(c, isIPv6(r.Parameters.ipDest)); r.last.mtu > 0 {
+				return 0, fmt.Errorf("%s, local mtu %d", E_FragNeeded, r.last.mtu)
+			}
+			return 0, fmt.Errorf("%s, larger than the local mtu", E_FragNeeded)
+		}
+		return 0, err
+	}
+	r.last.sentAt = sent
+	r.tracef("sent %d bytes to %v: %x\n", len(payload), c.RemoteAddr(), payload)
+	if r.Capture != nil {
+		r.Capture.WriteDatagram(sent, c.LocalAddr(), c.RemoteAddr(), payload)
+	}
+	defer release()
+	defer watchContext(ctx, c)()
+
+	rb := r.readBuffer()
+
+	var n int
+	var rtt time.Duration
+	for {
+		var at time.Time
+		n, at, err = r.readReply(c, rb)
+		rtt = at.Sub(sent)
+		if rtt < 0 {
+			// a kernel timestamp on a wall clock that stepped back
+			rtt = time.Since(sent)
+		}
+		if err != nil && ctx.Err() != nil {
+			// the run was cancelled, this probe has no outcome
+			return 0, ctx.Err()
+		}
+		if err != nil {
+			// If connection timed out, we return E_Timeout. Any other
+			// error, whatever its type, is a failed read.
+			if os.IsTimeout(err) {
+				return 0, fmt.Errorf(E_Timeout)
+			}
+			if isRefused(err) {
+				// the refusal is the answer
+				return rtt, fmt.Errorf(E_ConnRefused)
+			}
+			if r.Parameters.TTL != 0 || r.Parameters.DontFragment {
+				if err := r.icmpError(c); err != nil {
+					// the router's error is the answer
+					return rtt, err
+				}
+			}
+			return 0, fmt.Errorf("read Error: %w", err)
+		} else {
+			r.logf("%v bytes from %v\n", n, destination)
+		}
+		r.tracef("received %d bytes from %v: %x\n", n, c.RemoteAddr(), rb[:n])
+		if r.Capture != nil {
+			r.Capture.WriteDatagram(time.Now(), c.RemoteAddr(), c.LocalAddr(), rb[:n])
+		}
+		// kept even when it fails its check, to debug probe matching
+		r.last.reply = rb[:n]
+		err = check(rb[:n])
+		if errors.Is(err, errUncorrelated) {
+			// a late reply to another probe, keep waiting for ours
+			continue
+		}
+		if err != nil {
+			return 0, replyError{err}
+		}
+		break
+	}
+	if err := r.checkReplyLength(n); err != nil {
+		return rtt, err
+	}
+	return rtt, r.checkExpect(rb[:n])
+}
+
+// pingTcp opens a TCP connection to the destination. An accepted connection means the port is open.
+// When ProxyProtocol is set, a PROXY header is sent right after connecting, for backends that require one.
+// It returns the time the handshake took, or until the connection was refused.
+func (r *Runner) pingTcp(ctx context.Context) (time.Duration, error) {
+	deadline := r.probeDeadline()
+	start := time.Now()
+	r.last.sentAt = start
+	c, err := r.dial(ctx, r.network("tcp"), r.dialAddr(), deadline)
+	// the handshake is the answer, sending the proxy header is not part of the round trip
+	rtt := time.Since(start)
+	if err != nil && ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	if err != nil {
+		if isFDExhausted(err) {
+			return 0, fdLimitError()
+		}
+		if os.IsTimeout(err) {
+			return 0, fmt.Errorf(E_Timeout)
+		}
+		if isRefused(err) {
+			return rtt, fmt.Errorf(E_ConnRefused)
+		}
+		return 0, err
+	}
+	defer c.Close()
+	r.logf("connected to %v\n", c.RemoteAddr())
+	r.debugf("tcp connection %v -> %v\n", c.LocalAddr(), c.RemoteAddr())
+
+	if r.Parameters.ProxyProtocol != "" {
+		h, err := proxyHeader(r.Parameters.ProxyProtocol, c.LocalAddr(), c.RemoteAddr())
+		if err != nil {
+			return 0, err
+		}
+		if err := r.spend(len(h)); err != nil {
+			return 0, err
+		}
+		c.SetWriteDeadline(deadline)
+		if _, err := c.Write(h); err != nil {
+			return 0, fmt.Errorf("sending proxy protocol header: %v", err)
+		}
+	}
+	return rtt, nil
+}
+
+// ping runs a single probe using the configured protocol and returns its
+// round trip time, which is zero when no answer came back
+func (r *Runner) ping(ctx context.Context) (time.Duration, error) {
+	if r.Limiter != nil {
+		if err := r.Limiter.Wait(ctx); err != nil {
+			return 0, ctx.Err()
+		}
+	}
+	r.last.deadline = time.Now().Add(r.probeTimeout())
+	switch r.Parameters.Protocol {
+	case "tcp":
+		return r.pingTcp(ctx)
+	case "icmp":
+		return r.pingIcmp(ctx)
+	}
+	return r.pingUdp(ctx)
+}
+
+// probe returns the payload for the next probe and a function validating its reply
+func (r *Runner) probe() ([]byte, func([]byte) error, error) {
+	if r.Prober != nil {
+		return r.proberProbe()
+	}
+	switch r.Parameters.Mode {
+	case ModeDNS:
+		// the query ID already ties a reply to its query
+		return r.dnsProbe()
+	case ModeNTP:
+		// the origin timestamp ties a reply to its request
+		return r.ntpProbe()
+	case ModeQUIC:
+		// the connection IDs tie a reply to its packet
+		return r.quicProbe()
+	case ModeSTUN:
+		// the transaction ID ties a reply to its request
+		return r.stunProbe()
+	case ModeSNMP:
+		// the request ID ties a reply to its request
+		return r.snmpProbe()
+	case ModeSIP:
+		// the Call-ID ties a reply to its request
+		return r.sipProbe()
+	case ModeTFTP:
+		// a server only answers the port its request came from
+		return r.tftpProbe()
+	case ModeWireGuard:
+		// the sender index ties a reply to its initiation
+		return r.wireguardProbe()
+	case ModeEcho:
+		payload, seq := r.sequence(echoPayload)
+		payload, token := r.correlate(payload)
+		check, err := r.echoCheck(payload)
+		return payload, r.matchSeq(seq, matchToken(token, check)), err
+	}
+	payload, seq := r.sequence(r.randomize(r.resize(r.rawPayload())))
+	payload, token := r.correlate(payload)
+	check := func([]byte) error { return nil }
+	if r.Parameters.ExpectEcho {
+		check = plainEchoCheck(payload)
+	}
+	return payload, r.matchSeq(seq, matchToken(token, check)), nil
+}
+
+// resize pads payload with zero bytes, or truncates it, to Size bytes when
+// Size is set. A Pattern payload is padded by repeating it instead.
+func (r *Runner) resize(payload []byte) []byte {
+	if r.Parameters.Size == 0 || len(payload) == r.Parameters.Size {
+		return payload
+	}
+	b := make([]byte, r.Parameters.Size)
+	n := copy(b, payload)
+	if r.Parameters.Pattern != "" {
+		for n < len(b) {
+			n += copy(b[n:], payload)
+		}
+	}
+	return b
+}
+
+// randomize replaces payload with as many random bytes when Randomize is set,
+// so that services caching identical requests answer every probe afresh
+func (r *Runner) randomize(payload []byte) []byte {
+	if !r.Parameters.Randomize {
+		return payload
+	}
+	b := make([]byte, len(payload))
+	if _, err := rand.Read(b); err != nil {
+		return payload
+	}
+	// the leading bytes are enough to tell probes apart in the log
+	id := b
+	if len(id) > 4 {
+		id = id[:4]
+	}
+	r.debugf("payload %x...\n", id)
+	return b
+}
+
+// rawPayload returns the raw mode payload with its placeholders filled in.
+// {{host}} is replaced by the destination as given, not the resolved IP, for
+// services that key on the name they are addressed by. Hex payloads are sent
+// as they are, as is a Pattern.
+func (r *Runner) rawPayload() []byte {
+	if r.Parameters.Pattern != "" {
+		b, _ := hex.DecodeString(r.Parameters.Pattern)
+		return b
+	}
+	if r.Parameters.Payload == "" {
+		return []byte(DefaultPayload)
+	}
+	if b, ok, _ := hexPayload(r.Parameters.Payload); ok {
+		return b
+	}
+	return []byte(strings.ReplaceAll(r.Parameters.Payload, "{{host}}", r.Parameters.Destination))
+}
+
+// hexPayload decodes a payload written as 0x followed by hex digits. ok is
+// false for literal payloads.
+func hexPayload(p string) (b []byte, ok bool, err error) {
+	if !strings.HasPrefix(p, "0x") && !strings.HasPrefix(p, "0X") {
+		return nil, false, nil
+	}
+	b, err = hex.DecodeString(p[2:])
+	if err != nil {
+		return nil, true, fmt.Errorf("invalid hex payload %q: %v", p, err)
+	}
+	return b, true, nil
+}
+
+// checkReplyLength verifies the length of a reply against the ExpectLen parameter
+func (r *Runner) checkReplyLength(n int) error {
+	want := r.Parameters.ExpectLen
+	if want == 0 {
+		return nil
+	}
+	if r.Parameters.ExpectLenMin {
+		if n < want {
+			return replyError{fmt.Errorf("%s: got %d bytes, want at least %d", E_ReplyLength, n, want)}
+		}
+		return nil
+	}
+	if n != want {
+		return replyError{fmt.Errorf("%s: got %d bytes, want %d", E_ReplyLength, n, want)}
+	}
+	return nil
+}
+
+// checkExpect verifies that a reply contains the Expect pattern
+func (r *Runner) checkExpect(reply []byte) error {
+	if r.Parameters.Expect == "" {
+		return nil
+	}
+	want, ok, _ := hexPayload(r.Parameters.Expect)
+	if !ok {
+		want = []byte(r.Parameters.Expect)
+	}
+	if !bytes.Contains(reply, want) {
+		return replyError{fmt.Errorf("%s %q", E_Mismatch, r.Parameters.Expect)}
+	}
+	return nil
+}
+
+// Run probes the destination Count times, or until stopped when Continuous is set
+func (r *Runner) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// RunContext is Run, stopping as soon as ctx is done, even in the middle of a
+// probe whose own timeout is longer; that probe is dropped. A cancelled run is
+// not an error: the results gathered so far are kept.
+func (r *Runner) RunContext(ctx context.Context) error {
+	if err := r.validate(ctx, !r.Strict); err != nil {
+		if ctx.Err() != nil {
+			// cancelled while resolving the destination
+			return nil
+		}
+		return err
+	}
+	defer r.closeSocket()
+
+	// results are appended as probes complete, so only preallocate up to a
+	// bounded capacity; a mistyped huge count must not exhaust memory up front
+	if r.Results == nil && !r.Discard {
+		n := r.Parameters.Count
+		if n > maxPrealloc || r.Parameters.Continuous {
+			n = maxPrealloc
+		}
+		r.Results = make([]Result, 0, n)
+	}
+
+	if r.Parameters.EscalateTimeout {
+		r.timeouts = newEscalator(r.Parameters.EscalateStart, r.Parameters.Timeout)
+	} else if r.Parameters.AdaptiveTimeout {
+		r.timeouts = newRTOEstimator(r.Parameters.Timeout)
+	}
+
+	if r.Parameters.Fastest {
+		if err := r.selectFastest(ctx); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+	}
+
+	if r.Parameters.Burst > 1 {
+		return r.runBursts(ctx)
+	}
+
+	if r.Parameters.Protocol == "udp" || r.Parameters.Protocol == "tcp" || r.Parameters.Protocol == "icmp" {
+		// every protocol uses our own ping functions
+		first := time.Now()
+		for i := 0; r.Parameters.Continuous || i < r.Parameters.Count; i++ {
+			if i > 0 && r.Parameters.Interval > 0 && r.Parameters.Spacing == 0 {
+				// between probes, never after the last one; a spacing replaces the interval
+				sleepContext(ctx, r.Parameters.Interval)
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			r.waitSpacing(ctx, first, i)
+
+			res := r.newResult()
+			if r.Parameters.ResolveEach || r.reresolveDue() {
+				// follow DNS changes during the run
+				prev := r.answers
+				ip, err := r.resolve(ctx)
+				if err != nil && ctx.Err() != nil {
+					break
+				}
+				if err != nil && !r.Parameters.ResolveEach {
+					// keep probing the last address, the lookup is tried again before the next probe
+					r.debugf("re-resolving %s: %v\n", r.Parameters.Destination, err)
+				} else if err != nil {
+					res.IP, res.ResolvedName = "", ""
+					res.Error = err.Error()
+					res.Class = classify(err)
+					res.Outcome = OutcomeError
+					r.record(res)
+					continue
+				} else {
+					r.Parameters.ipDest = ip
+					// the name of the address probed, not of the one newResult saw
+					res.IP, res.ResolvedName = ip, r.reverseName(ip)
+					res.ResolveTime = r.resolveTime.Seconds()
+					r.debugf("%s resolved to %s in %v\n", r.Parameters.Destination, ip, r.resolveTime)
+					if answersChanged(prev, r.answers) {
+						res.AnswersChanged, res.Answers = true, r.answers
+						r.logf("[%v] %s now resolves to %s\n", i, r.Parameters.Destination, strings.Join(r.answers, ", "))
+					}
+				}
+			}
+
+			if r.Parameters.Protocol == "icmp" {
+				r.logf("[%v] pinging %s\n", i, r.Parameters.Destination)
+			} else {
+				r.logf("[%v] pinging %s\n", i, net.JoinHostPort(r.Parameters.Destination, fmt.Sprintf("%d", r.Parameters.DestinationPort)))
+			}
+			started := time.Now()
+			res, err := r.measure(ctx, res)
+			if err == nil && ctx.Err() == nil {
+				outcome := res.Error
+				if outcome == "" {
+					outcome = "ok"
+				}
+				r.debugf("[%v] %s after %v\n", i, outcome, time.Since(started))
+			}
+			if ctx.Err() != nil {
+				// the probe in flight was abandoned
+				break
+			}
+			if errors.Is(err, ErrByteBudget) {
+				// the budget is a planned stop, not a failure
+				break
+			}
+			if err != nil {
+				return err
+			}
+			r.record(res)
+		}
+
+	} else {
+		return fmt.Errorf("protocol %s is not supported", r.Parameters.Protocol)
+	}
+
+	return nil
+
+}
+
+// Port states of a Result, in the terms of port scanners
+const (
+	StateOpen         = "open"          // the port answered
+	StateClosed       = "closed"        // the port was refused
+	StateFiltered     = "filtered"      // a tcp connection got no answer
+	StateOpenFiltered = "open|filtered" // a udp probe got no answer, the port may be open and silent or filtered
+)
+
+// portState returns the state of the destination port given the outcome of
+// a probe, empty for icmp and for failures that say nothing of the port
+func (r *Runner) portState(err error) string {
+	if r.Parameters.Protocol == "icmp" {
+		return ""
+	}
+	switch {
+	case err == nil || r.last.reply != nil:
+		// any reply, even one that failed its checks, comes from a listening port
+		return StateOpen
+	case err.Error() == E_ConnRefused:
+		return StateClosed
+	case err.Error() == E_Timeout && r.Parameters.Protocol == "udp":
+		return StateOpenFiltered
+	case err.Error() == E_Timeout:
+		return StateFiltered
+	}
+	return ""
+}
+
+// measure runs a single probe and fills in its outcome and timing.
+// The returned error is only set when the run cannot continue: the run is out
+// of file descriptors, which stops it with ErrFDLimit, out of its byte
+// budget, or cancelled. A probe that fails for any other reason, even before
+// it was sent, is recorded as failed and the run goes on.
+func (r *Runner) measure(ctx context.Context, res Result) (Result, error) {
+	if r.timeouts != nil {
+		res.Timeout = r.timeouts.next().Seconds()
+	}
+	var rtt time.Duration
+	var err error
+	for attempt := 1; ; attempt++ {
+		r.last = probeState{}
+		rtt, err = r.ping(ctx)
+		if r.Parameters.Retries > 0 {
+			res.Attempts = attempt
+		}
+		// only a probe that got no answer at all, or could not be sent for now, is retried
+		if err == nil || (err.Error() != E_Timeout && !isTransientSendError(err)) || attempt > r.Parameters.Retries {
+			break
+		}
+		if !sleepContext(ctx, r.backoff(attempt)) {
+			break
+		}
+	}
+	if errors.Is(err, ErrFDLimit) || errors.Is(err, ErrByteBudget) || ctx.Err() != nil {
+		return res, err
+	}
+	return r.complete(res, rtt, err), nil
+}
+
+// complete fills in the outcome and timing of res, a probe that took rtt and
+// ended with err, and what r.last learnt of it
+func (r *Runner) complete(res Result, rtt time.Duration, err error) Result {
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.Class = classify(err)
+	res.Outcome = outcome(res.Class)
+	res.Success = r.succeeded(res.Outcome, res.Class)
+	// only an answer has a round trip, timed out and failed probes have no RTT
+	if !r.last.sentAt.IsZero() {
+		sentAt := r.last.sentAt
+		res.SentAt = &sentAt
+	}
+	if err == nil || res.Error == E_ConnRefused || strings.HasPrefix(res.Error, E_TTLExceeded) {
+		res.RTT = rtt.Seconds()
+		res.RTTMs = float64(rtt) / float64(time.Millisecond)
+		if res.SentAt != nil {
+			receivedAt := res.SentAt.Add(rtt)
+			res.ReceivedAt = &receivedAt
+		}
+	}
+	if r.last.oneWay != nil {
+		res.ForwardDelay = r.last.oneWay.forward.Seconds()
+		res.ReverseDelay = r.last.oneWay.reverse.Seconds()
+	}
+	res.State = r.portState(err)
+	res.CorrelationID = r.last.correlationID
+	res.Seq = r.last.seq
+	res.Late = r.last.late
+	res.Duplicates = r.last.duplicates
+	res.MappedAddress = r.last.mappedAddress
+	if r.Parameters.SourcePort != 0 || r.Parameters.Spray > 1 {
+		res.SourcePort = portOf(r.last.localAddr)
+	}
+	res.MTU = r.last.mtu
+	res.FragRejected = r.last.fragRejected
+	res.SIPStatus = r.last.sipStatus
+	res.ServiceError = r.last.serviceError
+	if r.last.replyTTL > 0 {
+		res.ReplyTTL, res.ReverseHops = r.last.replyTTL, reverseHops(r.last.replyTTL)
+	}
+	res.ReplyTOS = r.last.replyTOS
+	if r.last.reply != nil {
+		res.BytesReceived = len(r.last.reply)
+		if dump := r.Parameters.ReplyDump; dump > 0 {
+			if dump > len(r.last.reply) {
+				dump = len(r.last.reply)
+			}
+			res.Reply = hex.EncodeToString(r.last.reply[:dump])
+			if r.Parameters.ReplyEncoding == DumpBase64 {
+				res.Reply = base64.StdEncoding.EncodeToString(r.last.reply[:dump])
+			}
+		}
+	}
+	if r.timeouts != nil && (res.Error == E_Timeout || res.RTT > 0) {
+		// probes that failed without an answer tell nothing about the path's latency
+		r.timeouts.observe(res.Error != E_Timeout, rtt)
+	}
+	return res
+}
+
+// waitSpacing sleeps until probe i is due when a probe spacing is set.
+// Probe i is scheduled at first + i*Spacing, so the send-to-send cadence stays
+// steady however long earlier probes waited for their replies; a probe that
+// overran its slot delays the next one only until that one's own slot.
+func (r *Runner) waitSpacing(ctx context.Context, first time.Time, i int) {
+	if r.Parameters.Spacing <= 0 {
+		return
+	}
+	due := first.Add(time.Duration(i) * r.Parameters.Spacing)
+	if d := time.Until(due); d > 0 {
+		sleepContext(ctx, d)
+	}
+}
+
+// newResult returns a result describing the probe target
+func (r *Runner) newResult() Result {
+	return Result{
+		Destination:     r.Parameters.Destination,
+		DestinationPort: float64(r.Parameters.DestinationPort),
+		Protocol:        r.Parameters.Protocol,
+		IP:              r.Parameters.ipDest,
+		ResolvedName:    r.reverseName(r.Parameters.ipDest),
+		ResolveTime:     r.resolveTime.Seconds(),
+		Source:          r.Parameters.Source,
+		Interface:       r.Parameters.Interface,
+	}
+}
+
+// reverseName returns the PTR name of ip when ReverseLookup is set. Each
+// address is looked up once per run; a failed lookup leaves the name empty.
+func (r *Runner) reverseName(ip string) string {
+	if !r.Parameters.ReverseLookup || ip == "" {
+		return ""
+	}
+	if name, ok := r.names[ip]; ok {
+		return name
+	}
+	if r.names == nil {
+		r.names = map[string]string{}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.Parameters.Timeout)
+	defer cancel()
+	name := ""
+	if names, err := NewResolver(r.Parameters.Resolver).LookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		name = strings.TrimSuffix(names[0], ".")
+	}
+	r.names[ip] = name
+	return name
+}
+
+// record hands a completed result to OnResult and keeps it in Results unless Discard is set
+func (r *Runner) record(res Result) {
+	if r.Parameters.Histogram && r.stats.hist == nil {
+		r.stats.hist = NewHistogram()
+	}
+	r.stats.add(res)
+	if r.OnResult != nil {
+		r.OnResult(res)
+	}
+	if !r.Discard {
+		r.Results = append(r.Results, res)
+	}
+}
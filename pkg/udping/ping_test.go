@@ -0,0 +1,472 @@
+package udping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// listenUDP returns a udp socket on a free loopback port, closed when the test ends
+func listenUDP(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// echoServer runs an echo server on a free loopback port and returns its
+// port. stamp makes it a udping echo server, plain otherwise.
+func echoServer(t *testing.T, stamp bool) int {
+	t.Helper()
+	pc := listenUDP(t)
+	go echoLoop(pc, nil, stamp)
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+// silentServer returns the port of a socket that reads datagrams and never answers
+func silentServer(t *testing.T) int {
+	t.Helper()
+	pc := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			if _, _, err := pc.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+// closedPort returns a loopback port nothing listens on, so that probes to it
+// are refused with an ICMP port unreachable
+func closedPort(t *testing.T) int {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	pc.Close()
+	return port
+}
+
+// run probes port on loopback with p, filling in what every test shares
+func run(t *testing.T, port int, p Params) *Runner {
+	t.Helper()
+	p.Destination = "127.0.0.1"
+	p.DestinationPort = port
+	if p.Protocol == "" {
+		p.Protocol = "udp"
+	}
+	if p.Count == 0 {
+		p.Count = 1
+	}
+	if p.Timeout == 0 {
+		p.Timeout = time.Second
+	}
+	p.Interval = time.Millisecond
+	r := New(p)
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Results) != p.Count {
+		t.Fatalf("got %d results, want %d", len(r.Results), p.Count)
+	}
+	return r
+}
+
+func TestPingUdpSuccess(t *testing.T) {
+	port := echoServer(t, false)
+	r := run(t, port, Params{Count: 3, Payload: "hello"})
+	for i, res := range r.Results {
+		if !res.Success || res.Error != "" {
+			t.Errorf("probe %d: success %v, error %q", i, res.Success, res.Error)
+		}
+		if res.State != StateOpen {
+			t.Errorf("probe %d: state %q, want %q", i, res.State, StateOpen)
+		}
+		if res.BytesReceived != len("hello") {
+			t.Errorf("probe %d: received %d bytes, want %d", i, res.BytesReceived, len("hello"))
+		}
+		if res.RTT <= 0 || res.SentAt == nil || res.ReceivedAt == nil || res.ReceivedAt.Before(*res.SentAt) {
+			t.Errorf("probe %d: rtt %v, sent at %v, received at %v", i, res.RTT, res.SentAt, res.ReceivedAt)
+		}
+	}
+	if s := r.Summary(); s.Sent != 3 || s.Received != 3 || s.Loss != 0 {
+		t.Errorf("summary %+v, want 3 sent and received", s)
+	}
+}
+
+func TestReplyDump(t *testing.T) {
+	port := echoServer(t, false)
+	for _, tc := range []struct {
+		encoding string
+		want     string
+	}{
+		{"", "68656c"},
+		{DumpBase64, "aGVs"},
+	} {
+		res := run(t, port, Params{Payload: "hello", ReplyDump: 3, ReplyEncoding: tc.encoding}).Results[0]
+		if res.Reply != tc.want || res.BytesReceived != len("hello") {
+			t.Errorf("encoding %q: reply %q of %d bytes, want %q of %d", tc.encoding, res.Reply, res.BytesReceived, tc.want, len("hello"))
+		}
+	}
+}
+
+func TestPingUdpTimeout(t *testing.T) {
+	port := silentServer(t)
+	start := time.Now()
+	r := run(t, port, Params{Timeout: 100 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timed out after %v, want about 100ms", elapsed)
+	}
+	res := r.Results[0]
+	if res.Success || res.Error != E_Timeout {
+		t.Errorf("success %v, error %q, want a %q failure", res.Success, res.Error, E_Timeout)
+	}
+	if res.State != StateOpenFiltered || res.Class != ClassTimeout {
+		t.Errorf("state %q, class %q, want %q and %q", res.State, res.Class, StateOpenFiltered, ClassTimeout)
+	}
+	if res.RTT != 0 || res.ReceivedAt != nil {
+		t.Errorf("rtt %v, received at %v, want none", res.RTT, res.ReceivedAt)
+	}
+}
+
+func TestPingUdpRefused(t *testing.T) {
+	port := closedPort(t)
+	for _, tc := range []struct {
+		name         string
+		refusalFails bool
+	}{
+		{"reachable", false},
+		{"refused fails", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := run(t, port, Params{RefusalFails: tc.refusalFails}).Results[0]
+			if res.Error != E_ConnRefused {
+				t.Fatalf("error %q, want %q", res.Error, E_ConnRefused)
+			}
+			if res.Success == tc.refusalFails {
+				t.Errorf("success %v with RefusalFails %v", res.Success, tc.refusalFails)
+			}
+			if res.State != StateClosed || res.Class != ClassRefused {
+				t.Errorf("state %q, class %q, want %q and %q", res.State, res.Class, StateClosed, ClassRefused)
+			}
+			if res.RTT <= 0 {
+				t.Errorf("rtt %v, want the time to the refusal", res.RTT)
+			}
+		})
+	}
+}
+
+func TestSuccessPolicy(t *testing.T) {
+	answered, silent, closed := echoServer(t, false), silentServer(t), closedPort(t)
+	for _, tc := range []struct {
+		policy  string
+		port    int
+		outcome Outcome
+		success bool
+	}{
+		{SuccessReachable, answered, OutcomeAnswered, true},
+		{SuccessReachable, closed, OutcomeClosed, true},
+		{SuccessReachable, silent, OutcomeOpenFiltered, false},
+		{SuccessAnswered, closed, OutcomeClosed, false},
+		{SuccessNotClosed, silent, OutcomeOpenFiltered, true},
+		{SuccessNotClosed, closed, OutcomeClosed, false},
+	} {
+		res := run(t, tc.port, Params{SuccessPolicy: tc.policy, Timeout: 50 * time.Millisecond}).Results[0]
+		if res.Outcome != tc.outcome || res.Success != tc.success {
+			t.Errorf("%s policy: outcome %q, success %v, want %q and %v", tc.policy, res.Outcome, res.Success, tc.outcome, tc.success)
+		}
+	}
+}
+
+func TestPingUdpReplyChecks(t *testing.T) {
+	plain, stamped := echoServer(t, false), echoServer(t, true)
+	for _, tc := range []struct {
+		name    string
+		port    int
+		params  Params
+		wantErr string // prefix of the result's error, empty for a success
+	}{
+		{"expect found", plain, Params{Payload: "abc", Expect: "b"}, ""},
+		{"expect missing", plain, Params{Payload: "abc", Expect: "x"}, E_Mismatch},
+		{"expect hex", plain, Params{Payload: "0x00ff", Expect: "0xff"}, ""},
+		{"exact length", plain, Params{Payload: "abc", ExpectLen: 3}, ""},
+		{"wrong length", plain, Params{Payload: "abc", ExpectLen: 4}, E_ReplyLength},
+		{"minimum length", plain, Params{Payload: "abc", ExpectLen: 2, ExpectLenMin: true}, ""},
+		{"plain echo", plain, Params{ExpectEcho: true}, ""},
+		{"stamped is no plain echo", stamped, Params{ExpectEcho: true}, E_NotEcho},
+		{"echo mode", stamped, Params{Mode: ModeEcho}, ""},
+		{"echo mode needs a stamp", plain, Params{Mode: ModeEcho}, E_EchoReply},
+		{"sequence", plain, Params{Sequence: true, Count: 2}, ""},
+		{"correlate", plain, Params{Correlate: true}, ""},
+		{"pattern", plain, Params{Pattern: "ff00", Size: 100, ExpectLen: 100}, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, res := range run(t, tc.port, tc.params).Results {
+				if tc.wantErr == "" && (!res.Success || res.Error != "") {
+					t.Errorf("success %v, error %q, want a success", res.Success, res.Error)
+				}
+				if tc.wantErr != "" && (res.Success || !strings.HasPrefix(res.Error, tc.wantErr) || res.Class != ClassBadReply) {
+					t.Errorf("success %v, error %q, class %q, want a %q failure", res.Success, res.Error, res.Class, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestCorruptedEcho(t *testing.T) {
+	// a middlebox rewriting the first byte of every datagram
+	pc := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			buf[0] ^= 0xff
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+
+	for _, p := range []Params{{ExpectEcho: true, Count: 2}, {ExpectEcho: true, Correlate: true, Count: 2}} {
+		r := run(t, port, p)
+		for _, res := range r.Results {
+			if res.Success || !strings.HasPrefix(res.Error, E_Corrupted) || res.Class != ClassCorrupted {
+				t.Errorf("success %v, error %q, class %q, want a corrupted echo", res.Success, res.Error, res.Class)
+			}
+		}
+		if s := r.Summary(); s.Corrupted != 2 || s.Loss != 100 {
+			t.Errorf("summary %+v, want 2 corrupted probes lost", s)
+		}
+	}
+}
+
+func TestEchoModeOneWayDelays(t *testing.T) {
+	res := run(t, echoServer(t, true), Params{Mode: ModeEcho}).Results[0]
+	if !res.Success {
+		t.Fatalf("echo probe failed: %s", res.Error)
+	}
+	// the server shares our clock, so both halves fit within the round trip
+	if res.ForwardDelay < 0 || res.ReverseDelay < 0 || res.ForwardDelay+res.ReverseDelay > res.RTT+1e-3 {
+		t.Errorf("forward %v and reverse %v delays for an rtt of %v", res.ForwardDelay, res.ReverseDelay, res.RTT)
+	}
+}
+
+func TestRunContextCancel(t *testing.T) {
+	port := silentServer(t)
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp", Count: 5, Timeout: 10 * time.Second, Interval: time.Millisecond})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := r.RunContext(ctx); err != nil {
+		t.Fatalf("a cancelled run is not an error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("run stopped after %v, want about 100ms", elapsed)
+	}
+	if len(r.Results) != 0 {
+		t.Errorf("got %d results, want the probe in flight dropped", len(r.Results))
+	}
+}
+
+func TestRunInvalidParameters(t *testing.T) {
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: 70000, Protocol: "udp"})
+	if err := r.Run(); !errors.Is(err, ErrInvalidParameters) {
+		t.Errorf("got %v, want an ErrInvalidParameters error", err)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	port := echoServer(t, false)
+	r := run(t, port, Params{Count: 3, Timeout: 5 * time.Second, AdaptiveTimeout: true})
+	if len(r.Results) != 3 {
+		t.Fatalf("got %d results, want 3", len(r.Results))
+	}
+	// the first probe waits the configured timeout, the next ones the floor of a loopback rtt
+	if got := r.Results[0].Timeout; got != 5 {
+		t.Errorf("first probe timeout %vs, want 5s", got)
+	}
+	if got := r.Results[2].Timeout; got != adaptiveFloor.Seconds() {
+		t.Errorf("third probe timeout %vs, want %vs", got, adaptiveFloor.Seconds())
+	}
+
+	e := newRTOEstimator(time.Second)
+	e.observe(true, 100*time.Millisecond)
+	// srtt + 4 rttvar with rttvar half the first sample
+	if got := e.next(); got != 300*time.Millisecond {
+		t.Errorf("timeout after the first sample %v, want 300ms", got)
+	}
+	e.observe(false, 0)
+	e.observe(false, 0)
+	if got := e.next(); got != time.Second {
+		t.Errorf("timeout after backing off %v, want the 1s cap", got)
+	}
+}
+
+func TestKernelTimestamps(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("kernel timestamps are linux only")
+	}
+	port := echoServer(t, false)
+	c, err := net.Dial("udp", net.JoinHostPort("127.0.0.1", fmt.Sprint(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := kernelTimestamps(c); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	if _, err := c.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	n, h, err := readMsg(c, make([]byte, 64))
+	at := h.at
+	after := time.Now()
+	if err != nil || n != len("hello") {
+		t.Fatalf("read %d bytes, error %v", n, err)
+	}
+	if at.Before(before.Round(0)) || at.After(after.Round(0)) {
+		t.Errorf("kernel timestamp %v outside the round trip from %v to %v", at, before, after)
+	}
+
+	r := run(t, port, Params{Count: 2, KernelTimestamps: true})
+	for i, res := range r.Results {
+		if !res.Success || res.RTT <= 0 {
+			t.Errorf("probe %d: success %v, rtt %v", i, res.Success, res.RTT)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize([]Result{{Success: true, RTT: 0.25}, {Success: true, RTT: 0.75}, {Error: E_Timeout}})
+	if s.Sent != 3 || s.Received != 2 || s.AvgRTT != 0.5 || s.MaxRTT != 0.75 {
+		t.Errorf("summary %+v, want 2 of 3 received at 500ms on average", s)
+	}
+	if s := Summarize(nil); s.Sent != 0 || s.Loss != 100 {
+		t.Errorf("summary of nothing %+v, want a total loss", s)
+	}
+}
+
+func TestStatsSampleBounded(t *testing.T) {
+	var a statsAccumulator
+	n := 3 * maxRTTSample
+	for i := 0; i < n; i++ {
+		a.add(Result{Success: true, RTT: float64(i%2+i) / float64(n)})
+	}
+	if len(a.rtts) != maxRTTSample {
+		t.Errorf("kept %d rtts, want %d", len(a.rtts), maxRTTSample)
+	}
+	s := a.stats()
+	if s.Received != n || math.Abs(s.P50-0.5) > 0.02 || math.Abs(s.P90-0.9) > 0.02 {
+		t.Errorf("received %d, p50 %v, p90 %v, want %d, about 0.5 and 0.9", s.Received, s.P50, s.P90, n)
+	}
+	// consecutive rtts alternate between 0 and 2/n apart
+	if want := 1 / float64(n); math.Abs(s.Jitter-want) > want/100 {
+		t.Errorf("jitter %v, want %v", s.Jitter, want)
+	}
+}
+
+func TestReplyHeaders(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reply headers are linux only")
+	}
+	pc := listenUDP(t)
+	// replies marked expedited forwarding
+	if err := ipv4.NewPacketConn(pc).SetTOS(0xb8); err != nil {
+		t.Fatal(err)
+	}
+	go echoLoop(pc, nil, false)
+	r := run(t, pc.LocalAddr().(*net.UDPAddr).Port, Params{ReplyHeaders: true})
+	res := r.Results[0]
+	if !res.Success || res.ReplyTTL != 64 || res.ReverseHops != 0 || res.ReplyTOS == nil || *res.ReplyTOS != 0xb8 {
+		t.Errorf("success %v, reply ttl %d, %d hops, tos %v, want a loopback reply with tos 0xb8", res.Success, res.ReplyTTL, res.ReverseHops, res.ReplyTOS)
+	}
+	if got := reverseHops(117); got != 11 {
+		t.Errorf("a ttl of 117 crossed %d hops, want 11 from 128", got)
+	}
+}
+
+func TestDontFragmentLocal(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("don't fragment is linux only")
+	}
+	// no packet leaves the host: a probe bigger than the route's mtu is refused by the send call
+	c, err := net.Dial("udp", "192.0.2.1:9")
+	if err != nil {
+		t.Skip("no route to a remote address:", err)
+	}
+	mtu := cachedMTU(c, false)
+	c.Close()
+	if mtu == 0 || mtu+100 > maxUDPPayload {
+		t.Skipf("route mtu %d", mtu)
+	}
+	r := New(Params{Destination: "192.0.2.1", DestinationPort: 9, Protocol: "udp", Timeout: time.Second,
+		DontFragment: true, Size: mtu + 100})
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	res := r.Results[0]
+	if res.Class != ClassFragNeeded || res.FragRejected != FragLocal || res.MTU != mtu {
+		t.Errorf("class %q, rejected %q, mtu %d, want %q, %q, %d (error %q)",
+			res.Class, res.FragRejected, res.MTU, ClassFragNeeded, FragLocal, mtu, res.Error)
+	}
+}
+
+func TestSharedBudget(t *testing.T) {
+	port := echoServer(t, false)
+	// room for five probes of five bytes between the two runners
+	budget := NewBudget(27)
+	runners := make([]*Runner, 2)
+	errs := make(chan error, len(runners))
+	for i := range runners {
+		runners[i] = New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp",
+			Count: 10, Timeout: time.Second, Interval: time.Millisecond, Payload: "hello"})
+		runners[i].Budget = budget
+		go func(r *Runner) { errs <- r.Run() }(runners[i])
+	}
+	for range runners {
+		if err := <-errs; err != nil && !errors.Is(err, ErrByteBudget) {
+			t.Fatal(err)
+		}
+	}
+	sent := runners[0].BytesSent + runners[1].BytesSent
+	if sent != 25 || budget.Spent() != sent {
+		t.Errorf("runners sent %d bytes, the budget spent %d, want 25", sent, budget.Spent())
+	}
+	if !budget.Exhausted() || !runners[0].BudgetExhausted || !runners[1].BudgetExhausted {
+		t.Errorf("exhausted %v, runners %v and %v, want all exhausted",
+			budget.Exhausted(), runners[0].BudgetExhausted, runners[1].BudgetExhausted)
+	}
+}
+
+func TestSpacingReplacesInterval(t *testing.T) {
+	port := echoServer(t, false)
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp",
+		Count: 3, Timeout: time.Second, Interval: time.Second, Spacing: 20 * time.Millisecond})
+	start := time.Now()
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	// two spacings, not two intervals
+	if d := time.Since(start); d < 40*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("3 probes 20ms apart took %v", d)
+	}
+}
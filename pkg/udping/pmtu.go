@@ -0,0 +1,30 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+)
+
+// E_FragNeeded is the error of a probe with DontFragment set that was too big for the path
+const E_FragNeeded = "fragmentation needed"
+
+// Where a probe with DontFragment set was found too big, as Result.FragRejected.
+// A probe silently dropped in the path instead times out.
+const (
+	FragLocal = "local" // this host's interface, or the path mtu it has cached, refused to send it
+	FragPath  = "path"  // a router on the way reported it with an ICMP error
+)
+
+// setDontFragment sets the DF bit on the datagrams sent on c, or keeps IPv6
+// from fragmenting them at the source, and asks for the errors of probes too
+// big for the path to be reported back on c
+func (r *Runner) setDontFragment(c net.Conn) error {
+	if !r.Parameters.DontFragment {
+		return nil
+	}
+	v6 := isIPv6(r.Parameters.ipDest)
+	if err := dontFragment(c, v6); err != nil {
+		return fmt.Errorf("setting don't fragment: %v", err)
+	}
+	return recvICMPErrors(c, v6)
+}
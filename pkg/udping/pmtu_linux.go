@@ -0,0 +1,65 @@
+package udping
+
+import (
+	"net"
+	"syscall"
+)
+
+// ipv6DontFrag is IPV6_DONTFRAG from linux/in6.h, which package syscall lacks
+const ipv6DontFrag = 0x3e
+
+// dontFragment turns path MTU discovery to probing on c: datagrams are sent
+// with DF set whatever path MTU the kernel has cached, so that every size is
+// actually tried. IPv6 datagrams are kept from being fragmented at the
+// source too, failing those larger than the interface mtu with EMSGSIZE.
+func dontFragment(c net.Conn, v6 bool) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+			if serr == nil {
+				serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, ipv6DontFrag, 1)
+			}
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// cachedMTU returns the path mtu the kernel knows for the destination c is
+// connected to, 0 when it cannot tell
+func cachedMTU(c net.Conn, v6 bool) int {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return 0
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	mtu := 0
+	rc.Control(func(fd uintptr) {
+		var err error
+		if v6 {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU)
+		} else {
+			mtu, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU)
+		}
+		if err != nil {
+			mtu = 0
+		}
+	})
+	return mtu
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package udping
+
+import (
+	"errors"
+	"net"
+)
+
+// dontFragment is not available on this platform
+func dontFragment(c net.Conn, v6 bool) error {
+	return errors.New("not supported on this platform")
+}
+
+// cachedMTU is not available on this platform
+func cachedMTU(c net.Conn, v6 bool) int {
+	return 0
+}
@@ -0,0 +1,41 @@
+package udping
+
+import "fmt"
+
+// Prober builds the payload of udp probes and validates their replies, for
+// application protocols the built-in modes do not cover. Set it on a Runner
+// to use it in place of Parameters.Mode:
+//
+//	r := udping.New(udping.Params{Destination: "sip.example.com", DestinationPort: 5060, Protocol: "udp"})
+//	r.Prober = sipOptions{}
+//	err := r.Run()
+type Prober interface {
+	// BuildPayload returns the payload of probe seq, counted from 0 for
+	// every payload the Runner sends, retries included
+	BuildPayload(seq int) []byte
+	// ValidateResponse reports whether reply answers the probe in flight.
+	// A reply that is not an answer, such as a late one to an earlier probe,
+	// returns false and a nil error and the probe keeps waiting for its own.
+	// An error fails the probe with the error as its result.
+	ValidateResponse(reply []byte) (bool, error)
+}
+
+// proberProbe returns the next payload of r.Prober and a function validating its reply
+func (r *Runner) proberProbe() ([]byte, func([]byte) error, error) {
+	payload := r.Prober.BuildPayload(r.proberSeq)
+	r.proberSeq++
+	if len(payload) > maxUDPPayload {
+		return nil, nil, fmt.Errorf("prober payload is limited to %d bytes, got %d", maxUDPPayload, len(payload))
+	}
+	check := func(reply []byte) error {
+		ok, err := r.Prober.ValidateResponse(reply)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errUncorrelated
+		}
+		return nil
+	}
+	return payload, check, nil
+}
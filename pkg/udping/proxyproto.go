@@ -0,0 +1,61 @@
+package udping
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	ProxyV1 = "v1" // human-readable PROXY protocol header
+	ProxyV2 = "v2" // binary PROXY protocol header
+)
+
+// proxyV2Sig is the fixed signature that starts every PROXY protocol v2 header
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader builds a PROXY protocol header of the given version announcing
+// a connection from local to remote, as a load balancer would in front of the target
+func proxyHeader(version string, local, remote net.Addr) ([]byte, error) {
+	src, ok := local.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("proxy protocol needs a tcp source address, got %v", local)
+	}
+	dst, ok := remote.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("proxy protocol needs a tcp destination address, got %v", remote)
+	}
+	v4 := src.IP.To4() != nil && dst.IP.To4() != nil
+
+	switch version {
+	case ProxyV1:
+		fam := "TCP6"
+		if v4 {
+			fam = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", fam, src.IP, dst.IP, src.Port, dst.Port)), nil
+
+	case ProxyV2:
+		var fam byte
+		var addrs []byte
+		if v4 {
+			fam = 0x11 // AF_INET, STREAM
+			addrs = append(append(addrs, src.IP.To4()...), dst.IP.To4()...)
+		} else {
+			fam = 0x21 // AF_INET6, STREAM
+			addrs = append(append(addrs, src.IP.To16()...), dst.IP.To16()...)
+		}
+		var ports [4]byte
+		binary.BigEndian.PutUint16(ports[0:], uint16(src.Port))
+		binary.BigEndian.PutUint16(ports[2:], uint16(dst.Port))
+		addrs = append(addrs, ports[:]...)
+
+		var size [2]byte
+		binary.BigEndian.PutUint16(size[:], uint16(len(addrs)))
+		h := append([]byte{}, proxyV2Sig...)
+		h = append(h, 0x21, fam) // version 2, PROXY command
+		h = append(h, size[:]...)
+		return append(h, addrs...), nil
+	}
+	return nil, fmt.Errorf("unknown proxy protocol version %q, expected %s or %s", version, ProxyV1, ProxyV2)
+}
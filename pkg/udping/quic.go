@@ -0,0 +1,218 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	ModeQUIC = "quic" // send a QUIC Initial and require the server's handshake answer
+
+	E_QUICReply = "invalid quic reply"
+
+	// quicVersion is QUIC version 1, RFC 9000
+	quicVersion = 1
+	// quicMinDatagram is the size client Initial datagrams must be padded to
+	quicMinDatagram = 1200
+	// quicConnIDLen is the length of the random connection IDs of a probe
+	quicConnIDLen = 8
+	// quicPNLen is the length of the encoded packet number
+	quicPNLen = 4
+)
+
+// Long header packet types, RFC 9000 section 17.2
+const (
+	quicInitial   = 0
+	quicHandshake = 2
+	quicRetry     = 3
+)
+
+// quicInitialSalt derives the Initial packet keys of QUIC version 1, RFC 9001 section 5.2
+var quicInitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+// quicProbe builds a client Initial packet opening a QUIC connection to the
+// destination and returns it alongside a function that checks a reply is the
+// server's answer to it. The server's first flight is the answer: the
+// handshake is never completed, so the probe needs no key exchange of its own
+// and its key share is random bytes.
+func (r *Runner) quicProbe() ([]byte, func([]byte) error, error) {
+	ids := make([]byte, 2*quicConnIDLen)
+	if _, err := rand.Read(ids); err != nil {
+		return nil, nil, err
+	}
+	dcid, scid := ids[:quicConnIDLen], ids[quicConnIDLen:]
+	hello, err := r.quicClientHello(scid)
+	if err != nil {
+		return nil, nil, err
+	}
+	packet, err := quicInitialPacket(dcid, scid, hello)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	check := func(reply []byte) error {
+		if len(reply) < 7 || reply[0]&0x80 == 0 {
+			// a short header packet belongs to an established connection, not ours
+			return errUncorrelated
+		}
+		version := binary.BigEndian.Uint32(reply[1:5])
+		// the server addresses its answer to our source connection ID
+		n := int(reply[5])
+		if len(reply) < 6+n || !bytes.Equal(reply[6:6+n], scid) {
+			return errUncorrelated
+		}
+		if version == 0 {
+			return r.serviceError(fmt.Errorf("%s: server does not support QUIC version 1", E_QUICReply))
+		}
+		if version != quicVersion {
+			return fmt.Errorf("%s: unexpected version %#x", E_QUICReply, version)
+		}
+		switch typ := reply[0] >> 4 & 3; typ {
+		case quicInitial, quicHandshake, quicRetry:
+			return nil
+		default:
+			return fmt.Errorf("%s: unexpected packet type %d", E_QUICReply, typ)
+		}
+	}
+	return packet, check, nil
+}
+
+// quicClientHello returns a TLS 1.3 ClientHello offering h3, as a QUIC
+// client sends it in its first CRYPTO frame. The server name is sent when
+// the destination is a name rather than an address.
+func (r *Runner) quicClientHello(scid []byte) ([]byte, error) {
+	random := make([]byte, 32+32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+
+	var ext []byte
+	if name := r.Parameters.Destination; net.ParseIP(name) == nil {
+		ext = appendTLSExt(ext, 0x0000, prefix16(append([]byte{0}, prefix16([]byte(name))...)))
+	}
+	// x25519
+	ext = appendTLSExt(ext, 0x000a, prefix16([]byte{0x00, 0x1d}))
+	// ecdsa, rsa-pss and rsa signatures with sha256, sha384 and sha512
+	ext = appendTLSExt(ext, 0x000d, prefix16([]byte{0x04, 0x03, 0x08, 0x04, 0x04, 0x01, 0x05, 0x03, 0x08, 0x05, 0x05, 0x01, 0x08, 0x06, 0x06, 0x01}))
+	ext = appendTLSExt(ext, 0x0010, prefix16(append([]byte{2}, "h3"...)))
+	// TLS 1.3 only
+	ext = appendTLSExt(ext, 0x002b, []byte{2, 0x03, 0x04})
+	ext = appendTLSExt(ext, 0x0033, prefix16(append([]byte{0x00, 0x1d}, prefix16(random[32:])...)))
+	// initial_source_connection_id, the one transport parameter a client must send
+	ext = appendTLSExt(ext, 0x0039, append([]byte{0x0f, byte(len(scid))}, scid...))
+
+	body := []byte{0x03, 0x03}
+	body = append(body, random[:32]...)
+	// empty legacy session id
+	body = append(body, 0)
+	body = append(body, prefix16([]byte{0x13, 0x01, 0x13, 0x02, 0x13, 0x03})...)
+	// null compression
+	body = append(body, 1, 0)
+	body = append(body, prefix16(ext)...)
+
+	hello := []byte{0x01, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}
+	return append(hello, body...), nil
+}
+
+// appendTLSExt appends a TLS extension of type typ and contents data to b
+func appendTLSExt(b []byte, typ uint16, data []byte) []byte {
+	b = append(b, byte(typ>>8), byte(typ))
+	return append(b, prefix16(data)...)
+}
+
+// prefix16 returns b preceded by its length as two bytes
+func prefix16(b []byte) []byte {
+	return append([]byte{byte(len(b) >> 8), byte(len(b))}, b...)
+}
+
+// quicInitialPacket returns a client Initial packet from scid to dcid carrying
+// hello in a CRYPTO frame, padded to the minimum datagram size and protected
+// with the Initial keys of dcid, RFC 9001 section 5
+func quicInitialPacket(dcid, scid, hello []byte) ([]byte, error) {
+	key, iv, hp := quicInitialKeys(dcid)
+
+	header := []byte{0xc0 | quicInitial<<4 | (quicPNLen - 1)}
+	header = append(header, 0, 0, 0, quicVersion)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, byte(len(scid)))
+	header = append(header, scid...)
+	// no token
+	header = append(header, 0)
+
+	// CRYPTO frame at offset 0, two byte length
+	payload := append([]byte{0x06, 0x00, 0x40 | byte(len(hello)>>8), byte(len(hello))}, hello...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// the length field is two bytes, the rest of the datagram is PADDING frames
+	if pad := quicMinDatagram - (len(header) + 2 + quicPNLen + len(payload) + aead.Overhead()); pad > 0 {
+		payload = append(payload, make([]byte, pad)...)
+	}
+	length := quicPNLen + len(payload) + aead.Overhead()
+	header = append(header, 0x40|byte(length>>8), byte(length))
+	pnOffset := len(header)
+	// packet number 0
+	header = append(header, make([]byte, quicPNLen)...)
+
+	// the nonce is the iv xored with the packet number, which is 0
+	packet := aead.Seal(header, iv, payload, header)
+
+	// header protection, sampled 4 bytes after the start of the packet number
+	hpBlock, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, err
+	}
+	mask := make([]byte, aes.BlockSize)
+	hpBlock.Encrypt(mask, packet[pnOffset+4:pnOffset+4+aes.BlockSize])
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < quicPNLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet, nil
+}
+
+// quicInitialKeys derives the client Initial packet protection key, iv and
+// header protection key for the destination connection ID dcid
+func quicInitialKeys(dcid []byte) (key, iv, hp []byte) {
+	initial := hkdfExtract(quicInitialSalt, dcid)
+	client := hkdfExpandLabel(initial, "client in", 32)
+	return hkdfExpandLabel(client, "quic key", 16), hkdfExpandLabel(client, "quic iv", 12), hkdfExpandLabel(client, "quic hp", 16)
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256, RFC 5869
+func hkdfExtract(salt, secret []byte) []byte {
+	m := hmac.New(sha256.New, salt)
+	m.Write(secret)
+	return m.Sum(nil)
+}
+
+// hkdfExpandLabel is TLS 1.3's HKDF-Expand-Label with SHA-256 and an empty context, RFC 8446 section 7.1
+func hkdfExpandLabel(secret []byte, label string, length int) []byte {
+	info := []byte{byte(length >> 8), byte(length), byte(len("tls13 " + label))}
+	info = append(info, "tls13 "+label...)
+	info = append(info, 0)
+
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		m := hmac.New(sha256.New, secret)
+		m.Write(t)
+		m.Write(info)
+		m.Write([]byte{i})
+		t = m.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
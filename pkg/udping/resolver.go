@@ -0,0 +1,91 @@
+package udping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// NewResolver returns a resolver sending its queries to server, an ip:port
+// such as 1.1.1.1:53 or an ip, instead of the servers the system is
+// configured with. An empty server returns the system resolver.
+func NewResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if net.ParseIP(server) != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// resolverAddr returns server as an ip:port, port 53 when it has none
+func resolverAddr(server string) (string, error) {
+	if net.ParseIP(server) != nil {
+		return net.JoinHostPort(server, "53"), nil
+	}
+	host, _, err := net.SplitHostPort(server)
+	if err != nil || net.ParseIP(host) == nil {
+		// a resolver given by name would need another resolver to find it
+		return "", fmt.Errorf("resolver must be an IP address, optionally with a port, got %q", server)
+	}
+	return server, nil
+}
+
+// reresolves reports whether the destination is resolved again during the run
+func (r *Runner) reresolves() bool {
+	return r.Parameters.ResolveEach || r.Parameters.Reresolve > 0
+}
+
+// reresolveDue reports whether Reresolve has passed since the latest lookup
+func (r *Runner) reresolveDue() bool {
+	return r.Parameters.Reresolve > 0 && time.Since(r.resolvedAt) >= r.Parameters.Reresolve
+}
+
+// sortedAddrs returns a sorted copy of ips, so that answer sets compare
+// whatever order the resolver returned them in
+func sortedAddrs(ips []string) []string {
+	out := append([]string(nil), ips...)
+	sort.Strings(out)
+	return out
+}
+
+// answersChanged reports whether the answer set of a lookup differs from
+// the one before. The first lookup of a run changes nothing.
+func answersChanged(prev, cur []string) bool {
+	if prev == nil {
+		return false
+	}
+	if len(prev) != len(cur) {
+		return true
+	}
+	for i := range prev {
+		if prev[i] != cur[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupHost resolves the destination with the resolver of the run,
+// recording how long the lookup took. An IP destination is returned as it is.
+func (r *Runner) lookupHost(ctx context.Context) ([]string, error) {
+	if net.ParseIP(r.Parameters.Destination) != nil {
+		return []string{r.Parameters.Destination}, nil
+	}
+	if r.lookup == nil {
+		r.lookup = NewResolver(r.Parameters.Resolver)
+	}
+	start := time.Now()
+	ips, err := r.lookup.LookupHost(ctx, r.Parameters.Destination)
+	r.resolveTime = time.Since(start)
+	return ips, err
+}
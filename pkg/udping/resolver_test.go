@@ -0,0 +1,90 @@
+package udping
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsServer runs a DNS server answering A queries with the address addr
+// returns for the n-th of them, counting from 1, PTR queries with host-<last
+// octet>.test and AAAA queries with no address, and returns its ip:port
+func dnsServer(t *testing.T, addr func(n int) [4]byte) string {
+	t.Helper()
+	pc := listenUDP(t)
+	var queries int32
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			q, err := p.Question()
+			if err != nil {
+				continue
+			}
+			b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true})
+			b.StartQuestions()
+			b.Question(q)
+			b.StartAnswers()
+			if q.Type == dnsmessage.TypeA {
+				a := addr(int(atomic.AddInt32(&queries, 1)))
+				b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 1}, dnsmessage.AResource{A: a})
+			}
+			if q.Type == dnsmessage.TypePTR {
+				octet := strings.SplitN(q.Name.String(), ".", 2)[0]
+				b.PTRResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 1},
+					dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("host-" + octet + ".test.")})
+			}
+			msg, err := b.Finish()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(msg, from)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestReresolve(t *testing.T) {
+	resolver := dnsServer(t, func(n int) [4]byte {
+		if n < 3 {
+			return [4]byte{127, 0, 0, 1}
+		}
+		return [4]byte{127, 0, 0, 2}
+	})
+	r := New(Params{Destination: "svc.test.", DestinationPort: echoServer(t, false), Protocol: "udp", Count: 3,
+		Timeout: 200 * time.Millisecond, Interval: time.Millisecond, Resolver: resolver, Reresolve: time.Nanosecond, ReverseLookup: true})
+	if err := r.Run(); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []struct {
+		ip      string
+		name    string // 127.0.0.1 may be named by the hosts file instead
+		changed bool
+	}{
+		{"127.0.0.1", "", false},
+		{"127.0.0.2", "host-2.test", true},
+		{"127.0.0.2", "host-2.test", false},
+	} {
+		res := r.Results[i]
+		if res.IP != want.ip || res.AnswersChanged != want.changed {
+			t.Errorf("probe %d: ip %s, answers changed %v %v, want %s and %v", i, res.IP, res.AnswersChanged, res.Answers, want.ip, want.changed)
+		}
+		if want.name != "" && res.ResolvedName != want.name {
+			t.Errorf("probe %d: resolved name %q, want %q", i, res.ResolvedName, want.name)
+		}
+	}
+	if !r.Results[0].Success {
+		t.Errorf("probe 0: error %q", r.Results[0].Error)
+	}
+}
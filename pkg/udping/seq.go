@@ -0,0 +1,89 @@
+package udping
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// seqMarker precedes the sequence token appended to payloads when Sequence is set
+	seqMarker = "udping-seq:"
+	// seqDigits is the length of the token after the marker: the sequence
+	// number and the send time in nanoseconds, 16 hex digits each
+	seqDigits = 32
+	// seqWindow is how many earlier probes are remembered to tell a
+	// duplicate reply from a late one
+	seqWindow = 1024
+)
+
+// sequence appends the number of the probe and its send time to payload when
+// Sequence is set, as text so that services echoing the payload return it
+// intact, and returns the number, counted from 1. Without Sequence the payload is unchanged.
+func (r *Runner) sequence(payload []byte) ([]byte, int) {
+	if !r.Parameters.Sequence {
+		return payload, 0
+	}
+	r.seq++
+	seq := r.seq
+	r.last.seq = seq
+	token := seqToken(seq, time.Now())
+
+	out := make([]byte, 0, len(payload)+len(token))
+	return append(append(out, payload...), token...), seq
+}
+
+// seqToken returns the token carrying seq and the send time at, which parseSeq reads back
+func seqToken(seq int, at time.Time) string {
+	return fmt.Sprintf("%s%016x%016x", seqMarker, uint64(seq), uint64(at.UnixNano()))
+}
+
+// parseSeq returns the sequence number and send time carried by reply
+func parseSeq(reply []byte) (int, time.Time, bool) {
+	i := bytes.LastIndex(reply, []byte(seqMarker))
+	if i < 0 || len(reply) < i+len(seqMarker)+seqDigits {
+		return 0, time.Time{}, false
+	}
+	digits := string(reply[i+len(seqMarker) : i+len(seqMarker)+seqDigits])
+	if _, err := hex.DecodeString(digits); err != nil {
+		return 0, time.Time{}, false
+	}
+	seq, _ := strconv.ParseUint(digits[:16], 16, 64)
+	sent, _ := strconv.ParseUint(digits[16:], 16, 64)
+	return int(seq), time.Unix(0, int64(sent)), true
+}
+
+// matchSeq wraps check so that only replies carrying sequence number seq are
+// accepted when Sequence is set. Replies to earlier probes are counted as late
+// the first time and as duplicates afterwards, and rejected with
+// errUncorrelated so the probe keeps waiting for its own.
+func (r *Runner) matchSeq(seq int, check func([]byte) error) func([]byte) error {
+	if !r.Parameters.Sequence {
+		return check
+	}
+	if r.answered == nil {
+		r.answered = map[int]bool{}
+	}
+	delete(r.answered, seq-seqWindow)
+	return func(reply []byte) error {
+		got, sent, ok := parseSeq(reply)
+		if !ok {
+			return errUncorrelated
+		}
+		if got != seq {
+			if r.answered[got] {
+				r.last.duplicates++
+				r.debugf("duplicate reply to probe %d\n", got)
+			} else if got < seq {
+				r.last.late++
+				r.answered[got] = true
+				r.debugf("late reply to probe %d after %v\n", got, time.Since(sent))
+			}
+			return errUncorrelated
+		}
+		r.answered[seq] = true
+		return check(reply)
+	}
+}
@@ -0,0 +1,100 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	ModeSIP = "sip" // send a SIP OPTIONS request and report the status code of the final response
+
+	E_SIPReply = "invalid sip reply"
+
+	// SIPPort is probed in sip mode when no destination port is given
+	SIPPort = 5060
+)
+
+// sipProbe builds a SIP OPTIONS request, RFC 3261 section 11, and returns it
+// alongside a function that checks a reply is a final response to it,
+// recording its status code. Any final response counts, an error status too:
+// a 404 or a 405 still comes from a listening SIP stack. Provisional
+// responses are skipped. The rport parameter asks the server to answer the
+// port the request came from, whatever address translation it went through.
+func (r *Runner) sipProbe() ([]byte, func([]byte) error, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, err
+	}
+	branch := "z9hG4bK" + hex.EncodeToString(id[:6]) // the magic cookie of RFC 3261 branches
+	tag := hex.EncodeToString(id[6:10])
+	callID := hex.EncodeToString(id[10:])
+
+	local := r.last.localAddr
+	if local == "" {
+		local = "0.0.0.0:0"
+	}
+	target := net.JoinHostPort(r.Parameters.Destination, strconv.Itoa(r.Parameters.DestinationPort))
+	var b strings.Builder
+	fmt.Fprintf(&b, "OPTIONS sip:%s SIP/2.0\r\n", target)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP %s;branch=%s;rport\r\n", local, branch)
+	b.WriteString("Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "From: <sip:udping@%s>;tag=%s\r\n", local, tag)
+	fmt.Fprintf(&b, "To: <sip:%s>\r\n", target)
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", callID)
+	b.WriteString("CSeq: 1 OPTIONS\r\n")
+	fmt.Fprintf(&b, "Contact: <sip:udping@%s>\r\n", local)
+	b.WriteString("Accept: application/sdp\r\n")
+	b.WriteString("User-Agent: udping\r\n")
+	b.WriteString("Content-Length: 0\r\n\r\n")
+
+	check := func(reply []byte) error {
+		head := reply
+		if i := bytes.Index(reply, []byte("\r\n\r\n")); i >= 0 {
+			head = reply[:i]
+		}
+		lines := strings.Split(string(head), "\r\n")
+		// SIP/2.0 200 OK
+		status := strings.SplitN(lines[0], " ", 3)
+		if len(status) < 2 || status[0] != "SIP/2.0" {
+			return fmt.Errorf("%s: not a sip response", E_SIPReply)
+		}
+		code, err := strconv.Atoi(status[1])
+		if err != nil || code < 100 || code > 699 {
+			return fmt.Errorf("%s: status %q", E_SIPReply, status[1])
+		}
+		if sipHeader(lines[1:], "call-id", "i") != callID {
+			// the answer to another request
+			return errUncorrelated
+		}
+		if code < 200 {
+			// 100 Trying and the like, the final response is still to come
+			return errUncorrelated
+		}
+		r.last.sipStatus = code
+		if len(status) > 2 {
+			r.debugf("sip status %d %s\n", code, status[2])
+		}
+		return nil
+	}
+	return []byte(b.String()), check, nil
+}
+
+// sipHeader returns the value of the first header of lines named name, or
+// its compact form short, matched case-insensitively
+func sipHeader(lines []string, name, short string) string {
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		if key := strings.TrimSpace(line[:i]); strings.EqualFold(key, name) || strings.EqualFold(key, short) {
+			return strings.TrimSpace(line[i+1:])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package udping
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sipServer runs a server answering OPTIONS requests with a 100 Trying, a
+// response to another call and the final response status, and returns its port
+func sipServer(t *testing.T, status string) int {
+	t.Helper()
+	pc := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			lines := strings.Split(string(buf[:n]), "\r\n")
+			if !strings.HasPrefix(lines[0], "OPTIONS sip:") {
+				continue
+			}
+			callID := sipHeader(lines[1:], "call-id", "i")
+			for _, reply := range []string{
+				"SIP/2.0 100 Trying\r\nCall-ID: " + callID + "\r\n\r\n",
+				"SIP/2.0 200 OK\r\nCall-ID: other\r\n\r\n",
+				"SIP/2.0 " + status + "\r\nVia: " + sipHeader(lines[1:], "via", "v") + "\r\ni: " + callID + "\r\nCSeq: 1 OPTIONS\r\nContent-Length: 0\r\n\r\n",
+			} {
+				pc.WriteTo([]byte(reply), addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestSIPMode(t *testing.T) {
+	for _, tc := range []struct {
+		status string
+		want   int
+	}{
+		{"200 OK", 200},
+		{"405 Method Not Allowed", 405},
+	} {
+		t.Run(tc.status, func(t *testing.T) {
+			res := run(t, sipServer(t, tc.status), Params{Mode: ModeSIP, Timeout: 500 * time.Millisecond}).Results[0]
+			if !res.Success || res.Error != "" || res.SIPStatus != tc.want {
+				t.Errorf("success %v, error %q, sip status %d, want %d", res.Success, res.Error, res.SIPStatus, tc.want)
+			}
+		})
+	}
+
+	res := run(t, echoServer(t, false), Params{Mode: ModeSIP, Timeout: 500 * time.Millisecond}).Results[0]
+	if res.Success || !strings.HasPrefix(res.Error, E_SIPReply) {
+		t.Errorf("success %v, error %q for a reflected request, want a %q failure", res.Success, res.Error, E_SIPReply)
+	}
+}
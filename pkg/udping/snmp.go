@@ -0,0 +1,141 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	ModeSNMP = "snmp" // send an SNMPv2c GET for sysUpTime and require the agent's value
+
+	E_SNMPReply = "invalid snmp reply"
+
+	// DefaultSNMPCommunity is sent in snmp mode when no community is given
+	DefaultSNMPCommunity = "public"
+)
+
+// SNMP constants from RFC 3416; PDUs are context-specific constructed types
+const (
+	snmpV2c         = 1
+	snmpGetRequest  = 0xa0
+	snmpGetResponse = 2
+	snmpTimeTicks   = 3 // application type of sysUpTime, hundredths of a second
+)
+
+// snmpSysUpTime is the OID of sysUpTime.0, which every agent implements
+var snmpSysUpTime = asn1.ObjectIdentifier{1, 3, 6, 1, 2, 1, 1, 3, 0}
+
+// snmpErrors names the error statuses of RFC 3416 section 3
+var snmpErrors = []string{"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr", "noAccess", "wrongType",
+	"wrongLength", "wrongEncoding", "wrongValue", "noCreation", "inconsistentValue", "resourceUnavailable",
+	"commitFailed", "undoFailed", "authorizationError", "notWritable", "inconsistentName"}
+
+// snmpExceptions name the context-specific values of a variable binding without a value
+var snmpExceptions = []string{"noSuchObject", "noSuchInstance", "endOfMibView"}
+
+type (
+	// snmpMessage is an SNMPv1 or v2c message, its PDU left encoded
+	snmpMessage struct {
+		Version   int
+		Community []byte
+		PDU       asn1.RawValue
+	}
+
+	// snmpPDU is the body of a request or response PDU, encoded as a SEQUENCE
+	snmpPDU struct {
+		RequestID   int32
+		ErrorStatus int
+		ErrorIndex  int
+		VarBinds    []snmpVarBind
+	}
+
+	// snmpVarBind is an OID with its value
+	snmpVarBind struct {
+		OID   asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}
+)
+
+// snmpCommunity returns the community of an snmp mode probe
+func (r *Runner) snmpCommunity() string {
+	if r.Parameters.SNMPCommunity == "" {
+		return DefaultSNMPCommunity
+	}
+	return r.Parameters.SNMPCommunity
+}
+
+// snmpProbe builds an SNMPv2c GET request for sysUpTime.0 with a random
+// request ID and returns it alongside a function that checks a reply is the
+// agent's response to it. An agent silently drops requests with a community
+// it does not know, so a wrong community looks like a timeout.
+func (r *Runner) snmpProbe() ([]byte, func([]byte) error, error) {
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, err
+	}
+	// a positive ID, which some agents insist on
+	requestID := int32(binary.BigEndian.Uint32(id[:]) & 0x7fffffff)
+	pdu, err := asn1.Marshal(snmpPDU{
+		RequestID: requestID,
+		VarBinds:  []snmpVarBind{{OID: snmpSysUpTime, Value: asn1.RawValue{Tag: asn1.TagNull}}},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// the same fields as a SEQUENCE, tagged as a GetRequest
+	pdu[0] = snmpGetRequest
+	community := []byte(r.snmpCommunity())
+	query, err := asn1.Marshal(snmpMessage{Version: snmpV2c, Community: community, PDU: asn1.RawValue{FullBytes: pdu}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	check := func(reply []byte) error {
+		var msg snmpMessage
+		if _, err := asn1.Unmarshal(reply, &msg); err != nil {
+			return fmt.Errorf("%s: not an snmp message", E_SNMPReply)
+		}
+		if msg.Version != snmpV2c || msg.PDU.Class != asn1.ClassContextSpecific || msg.PDU.Tag != snmpGetResponse {
+			return fmt.Errorf("%s: not an snmpv2c response", E_SNMPReply)
+		}
+		// decode the PDU as the SEQUENCE it is underneath its tag
+		body := append([]byte(nil), msg.PDU.FullBytes...)
+		body[0] = 0x30
+		var resp snmpPDU
+		if _, err := asn1.Unmarshal(body, &resp); err != nil {
+			return fmt.Errorf("%s: %v", E_SNMPReply, err)
+		}
+		if resp.RequestID != requestID || !bytes.Equal(msg.Community, community) {
+			// the answer to another request
+			return errUncorrelated
+		}
+		if resp.ErrorStatus != 0 {
+			name := fmt.Sprintf("%d", resp.ErrorStatus)
+			if resp.ErrorStatus > 0 && resp.ErrorStatus < len(snmpErrors) {
+				name = snmpErrors[resp.ErrorStatus]
+			}
+			return r.serviceError(fmt.Errorf("%s: error status %s", E_SNMPReply, name))
+		}
+		if len(resp.VarBinds) != 1 || !resp.VarBinds[0].OID.Equal(snmpSysUpTime) {
+			return fmt.Errorf("%s: not the variable asked for", E_SNMPReply)
+		}
+		v := resp.VarBinds[0].Value
+		if v.Class == asn1.ClassContextSpecific && v.Tag < len(snmpExceptions) {
+			return r.serviceError(fmt.Errorf("%s: %s", E_SNMPReply, snmpExceptions[v.Tag]))
+		}
+		if v.Class != asn1.ClassApplication || v.Tag != snmpTimeTicks || len(v.Bytes) == 0 || len(v.Bytes) > 5 {
+			return fmt.Errorf("%s: sysUpTime is not a TimeTicks value", E_SNMPReply)
+		}
+		var ticks uint64
+		for _, b := range v.Bytes {
+			ticks = ticks<<8 | uint64(b)
+		}
+		r.debugf("agent up for %v\n", time.Duration(ticks)*10*time.Millisecond)
+		return nil
+	}
+	return query, check, nil
+}
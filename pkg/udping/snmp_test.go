@@ -0,0 +1,86 @@
+package udping
+
+import (
+	"encoding/asn1"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSNMPRequest(t *testing.T) {
+	query, _, err := New(Params{}).snmpProbe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// GetRequest for sysUpTime.0 with community public, as snmpget sends it,
+	// with whatever request ID
+	want := "302902010104067075626c6963a01c0204" + "????????" + "020100020100300e300c06082b060102010103000500"
+	got := hex.EncodeToString(query)
+	if len(got) != len(want) || got[:34] != want[:34] || got[42:] != want[42:] {
+		t.Errorf("request %s, want %s", got, want)
+	}
+}
+
+// snmpAgent runs an agent that answers GETs carrying community, and only
+// those, with value, and returns its port
+func snmpAgent(t *testing.T, community string, value asn1.RawValue) int {
+	t.Helper()
+	pc := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg snmpMessage
+			if _, err := asn1.Unmarshal(buf[:n], &msg); err != nil || string(msg.Community) != community {
+				continue
+			}
+			body := append([]byte(nil), msg.PDU.FullBytes...)
+			body[0] = 0x30
+			var req snmpPDU
+			if _, err := asn1.Unmarshal(body, &req); err != nil {
+				continue
+			}
+			req.VarBinds[0].Value = value
+			pdu, _ := asn1.Marshal(req)
+			pdu[0] = 0xa0 | snmpGetResponse
+			msg.PDU = asn1.RawValue{FullBytes: pdu}
+			reply, _ := asn1.Marshal(msg)
+			pc.WriteTo(reply, addr)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestSNMPMode(t *testing.T) {
+	uptime := asn1.RawValue{Class: asn1.ClassApplication, Tag: snmpTimeTicks, Bytes: []byte{0x01, 0xe2, 0x40}}
+	noSuchObject := asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0}
+	for _, tc := range []struct {
+		name      string
+		port      int
+		community string
+		wantErr   string // prefix of the result's error, empty for a success
+	}{
+		{"uptime", snmpAgent(t, "public", uptime), "", ""},
+		{"community", snmpAgent(t, "s3cret", uptime), "s3cret", ""},
+		{"wrong community", snmpAgent(t, "s3cret", uptime), "public", E_Timeout},
+		{"no such object", snmpAgent(t, "public", noSuchObject), "", E_SNMPReply + ": noSuchObject"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := run(t, tc.port, Params{Mode: ModeSNMP, SNMPCommunity: tc.community, Timeout: 200 * time.Millisecond}).Results[0]
+			if (tc.wantErr == "") != res.Success || !strings.HasPrefix(res.Error, tc.wantErr) || (tc.wantErr == "" && res.Error != "") {
+				t.Errorf("success %v, error %q, want error %q", res.Success, res.Error, tc.wantErr)
+			}
+		})
+	}
+
+	// with AnyReply the agent's exception proves it up
+	res := run(t, snmpAgent(t, "public", noSuchObject), Params{Mode: ModeSNMP, AnyReply: true, Timeout: 200 * time.Millisecond}).Results[0]
+	if !res.Success || res.Error != "" || res.ServiceError != E_SNMPReply+": noSuchObject" {
+		t.Errorf("any reply: success %v, error %q, service error %q", res.Success, res.Error, res.ServiceError)
+	}
+}
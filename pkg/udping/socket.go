@@ -0,0 +1,112 @@
+package udping
+
+import (
+	"context"
+	"net"
+)
+
+// udpReadBuffer is the size of the receive buffer, room for the largest
+// datagram so that the size of big replies is not cut down
+const udpReadBuffer = 65535
+
+// udpSocket returns the socket a udp probe to destination is sent over and
+// a function to call once the probe is done with it. Every probe gets a
+// fresh socket, unless ReuseSocket is set: the run then keeps one socket,
+// dialled again only when the destination changes, and closed by closeSocket.
+// With Spray set, probes rotate over a socket per source port instead.
+// With a Proxy the socket sends through the proxy's relay instead.
+func (r *Runner) udpSocket(ctx context.Context, destination string) (net.Conn, func(), error) {
+	if r.Parameters.Spray > 1 {
+		return r.spraySocket(ctx, destination)
+	}
+	if r.Parameters.ReuseSocket && r.udpConn != nil && r.udpAddr == destination {
+		return r.udpConn, func() {}, nil
+	}
+	r.closeSocket()
+	c, err := r.newUDPSocket(ctx, destination)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !r.Parameters.ReuseSocket {
+		return c, func() { c.Close() }, nil
+	}
+	r.udpConn, r.udpAddr = c, destination
+	return c, func() {}, nil
+}
+
+// newUDPSocket dials a udp socket to destination with the socket options of
+// the parameters set. In tftp mode the socket is left unconnected, to hear
+// the server answer from another port.
+func (r *Runner) newUDPSocket(ctx context.Context, destination string) (net.Conn, error) {
+	var c net.Conn
+	var raddr *net.UDPAddr
+	var err error
+	switch {
+	case r.Parameters.Proxy != "":
+		c, err = r.socksDial(ctx, destination)
+	case r.Parameters.Mode == ModeTFTP:
+		// the options below go on the socket itself, wrapped once they are set
+		c, raddr, err = r.listenTFTP(ctx, destination)
+	default:
+		c, err = r.dial(ctx, r.network("udp"), destination, r.probeDeadline())
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := r.setTTL(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := r.setDSCP(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := r.setDontFragment(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := r.setKernelTimestamps(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := r.setReplyHeaders(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := r.setSocketBuffers(c); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if raddr != nil {
+		// an unconnected socket only hears of refusals with IP_RECVERR on
+		if err := recvICMPErrors(c, raddr.IP.To4() == nil); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = &tftpConn{c: c.(*net.UDPConn), raddr: raddr}
+	}
+	r.debugf("udp socket %v -> %v\n", c.LocalAddr(), c.RemoteAddr())
+	return c, nil
+}
+
+// closeSocket closes the socket kept by ReuseSocket, if any, and the sockets of Spray
+func (r *Runner) closeSocket() {
+	if r.udpConn != nil {
+		r.udpConn.Close()
+		r.udpConn = nil
+	}
+	for i, c := range r.sprayConns {
+		if c != nil {
+			c.Close()
+			r.sprayConns[i] = nil
+		}
+	}
+}
+
+// readBuffer returns the receive buffer, allocated once per Runner
+func (r *Runner) readBuffer() []byte {
+	if r.rbuf == nil {
+		r.rbuf = make([]byte, udpReadBuffer)
+	}
+	return r.rbuf
+}
@@ -0,0 +1,287 @@
+package udping
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 constants from RFC 1928 and RFC 1929
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksUserPass     = 2
+	socksNoAcceptable = 0xff
+	socksAssociate    = 3
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+	socksAuthVersion  = 1
+)
+
+// socksReplies names the reply codes of RFC 1928 section 6
+var socksReplies = []string{"succeeded", "general SOCKS server failure", "connection not allowed by ruleset",
+	"network unreachable", "host unreachable", "connection refused", "TTL expired", "command not supported",
+	"address type not supported"}
+
+// socksProxy parses a Proxy parameter, socks5://[user:password@]host:port
+func socksProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil || u.Scheme != "socks5" || u.Hostname() == "" || u.Port() == "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("proxy must be socks5://[user:password@]host:port, got %q", proxy)
+	}
+	return u, nil
+}
+
+// socksUDPConn is a udp socket whose datagrams go through a SOCKS5 UDP
+// ASSOCIATE relay. Each datagram written gets the header naming the
+// destination prepended; each read has the relay's header stripped. The
+// association lasts as long as its control connection.
+type socksUDPConn struct {
+	net.Conn          // the udp socket to the relay
+	ctrl     net.Conn // the tcp control connection holding the association
+	header   []byte   // the header of the datagrams sent to the destination
+	dst      net.Addr
+	rbuf     []byte
+}
+
+func (c *socksUDPConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(append(append([]byte(nil), c.header...), b...))
+	if n -= len(c.header); n < 0 {
+		n = 0
+	}
+	return n, err
+}
+
+func (c *socksUDPConn) Read(b []byte) (int, error) {
+	if c.rbuf == nil {
+		c.rbuf = make([]byte, udpReadBuffer)
+	}
+	for {
+		n, err := c.Conn.Read(c.rbuf)
+		if err != nil {
+			return 0, err
+		}
+		// RSV, FRAG and the address of the sender
+		if n < 4 || c.rbuf[2] != 0 {
+			// fragments are not reassembled, which RFC 1928 allows
+			continue
+		}
+		_, hn, err := socksAddr(c.rbuf[3:n])
+		if err != nil {
+			continue
+		}
+		return copy(b, c.rbuf[3+hn:n]), nil
+	}
+}
+
+// RemoteAddr returns the destination, not the relay
+func (c *socksUDPConn) RemoteAddr() net.Addr {
+	return c.dst
+}
+
+func (c *socksUDPConn) Close() error {
+	c.ctrl.Close()
+	return c.Conn.Close()
+}
+
+// socksDial associates a udp relay on the SOCKS5 proxy of the Proxy
+// parameter and returns a socket sending through it to destination, an
+// ip:port. The handshake must complete by the deadline of the probe.
+func (r *Runner) socksDial(ctx context.Context, destination string) (net.Conn, error) {
+	proxy, err := socksProxy(r.Parameters.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := net.ResolveUDPAddr("udp", destination)
+	if err != nil {
+		return nil, err
+	}
+	deadline := r.probeDeadline()
+	ctrl, err := r.dial(ctx, "tcp", proxy.Host, deadline)
+	if err != nil {
+		return nil, fmt.Errorf("socks proxy: %w", err)
+	}
+	relay, err := socksHandshake(ctx, ctrl, proxy, deadline)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks proxy: %w", err)
+	}
+	r.debugf("socks relay %v for %v\n", relay, dst)
+	c, err := r.dial(ctx, "udp", relay, deadline)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks relay: %w", err)
+	}
+	return &socksUDPConn{Conn: c, ctrl: ctrl, header: socksHeader(dst), dst: dst}, nil
+}
+
+// socksHandshake authenticates on ctrl, with the user and password of proxy
+// when it has some, and asks for a udp association, returning the ip:port of
+// the relay to send datagrams to. It gives up at deadline.
+func socksHandshake(ctx context.Context, ctrl net.Conn, proxy *url.URL, deadline time.Time) (string, error) {
+	ctrl.SetDeadline(deadline)
+	defer ctrl.SetDeadline(time.Time{})
+	defer watchContext(ctx, ctrl)()
+
+	methods := []byte{socksNoAuth}
+	if proxy.User != nil {
+		methods = []byte{socksUserPass}
+	}
+	if _, err := ctrl.Write(append([]byte{socksVersion, byte(len(methods))}, methods...)); err != nil {
+		return "", err
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(ctrl, choice[:]); err != nil {
+		return "", err
+	}
+	if choice[0] != socksVersion {
+		return "", fmt.Errorf("not a socks5 server")
+	}
+	switch choice[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if proxy.User == nil {
+			return "", fmt.Errorf("the server requires a user and password")
+		}
+		if err := socksAuthenticate(ctrl, proxy.User); err != nil {
+			return "", err
+		}
+	case socksNoAcceptable:
+		return "", fmt.Errorf("no acceptable authentication method")
+	default:
+		return "", fmt.Errorf("unsupported authentication method %d", choice[1])
+	}
+
+	// the address datagrams come from is not known before binding the udp
+	// socket, all zeros lets the relay take them from anywhere
+	req := []byte{socksVersion, socksAssociate, 0, socksIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := ctrl.Write(req); err != nil {
+		return "", err
+	}
+	var head [3]byte
+	if _, err := io.ReadFull(ctrl, head[:]); err != nil {
+		return "", err
+	}
+	if head[0] != socksVersion {
+		return "", fmt.Errorf("not a socks5 server")
+	}
+	if head[1] != 0 {
+		name := fmt.Sprintf("reply %d", head[1])
+		if int(head[1]) < len(socksReplies) {
+			name = socksReplies[head[1]]
+		}
+		return "", fmt.Errorf("udp associate failed: %s", name)
+	}
+	bound, err := socksReadAddr(ctrl)
+	if err != nil {
+		return "", err
+	}
+	host, port, _ := net.SplitHostPort(bound)
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		// the relay listens on every address, the proxy's one included
+		host, _, _ = net.SplitHostPort(ctrl.RemoteAddr().String())
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// socksAuthenticate sends the user and password of RFC 1929 on ctrl
+func socksAuthenticate(ctrl net.Conn, user *url.Userinfo) error {
+	name := user.Username()
+	password, _ := user.Password()
+	if len(name) > 255 || len(password) > 255 {
+		return fmt.Errorf("socks user and password must be at most 255 bytes")
+	}
+	req := append([]byte{socksAuthVersion, byte(len(name))}, name...)
+	req = append(append(req, byte(len(password))), password...)
+	if _, err := ctrl.Write(req); err != nil {
+		return err
+	}
+	var status [2]byte
+	if _, err := io.ReadFull(ctrl, status[:]); err != nil {
+		return err
+	}
+	if status[1] != 0 {
+		return fmt.Errorf("authentication failed")
+	}
+	return nil
+}
+
+// socksReadAddr reads an address, type first, from ctrl
+func socksReadAddr(ctrl net.Conn) (string, error) {
+	b := make([]byte, 2, 1+1+255+2)
+	if _, err := io.ReadFull(ctrl, b); err != nil {
+		return "", err
+	}
+	var rest int
+	switch b[0] {
+	case socksIPv4:
+		rest = net.IPv4len + 2 - 1
+	case socksIPv6:
+		rest = net.IPv6len + 2 - 1
+	case socksDomain:
+		rest = int(b[1]) + 2
+	default:
+		return "", fmt.Errorf("unknown address type %d", b[0])
+	}
+	b = b[:2+rest]
+	if _, err := io.ReadFull(ctrl, b[2:]); err != nil {
+		return "", err
+	}
+	addr, _, err := socksAddr(b)
+	return addr, err
+}
+
+// socksHeader returns the header of a datagram relayed to dst
+func socksHeader(dst *net.UDPAddr) []byte {
+	h := []byte{0, 0, 0}
+	if ip4 := dst.IP.To4(); ip4 != nil {
+		h = append(append(h, socksIPv4), ip4...)
+	} else {
+		h = append(append(h, socksIPv6), dst.IP.To16()...)
+	}
+	return append(h, byte(dst.Port>>8), byte(dst.Port))
+}
+
+// socksAddr parses the address, type first, that b starts with and returns
+// it as host:port alongside its length
+func socksAddr(b []byte) (string, int, error) {
+	if len(b) < 1 {
+		return "", 0, io.ErrUnexpectedEOF
+	}
+	var host string
+	var n int
+	switch b[0] {
+	case socksIPv4:
+		n = 1 + net.IPv4len
+		if len(b) < n+2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		host = net.IP(b[1:n]).String()
+	case socksIPv6:
+		n = 1 + net.IPv6len
+		if len(b) < n+2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		host = net.IP(b[1:n]).String()
+	case socksDomain:
+		if len(b) < 2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		n = 2 + int(b[1])
+		if len(b) < n+2 {
+			return "", 0, io.ErrUnexpectedEOF
+		}
+		host = string(b[2:n])
+	default:
+		return "", 0, errors.New("unknown address type")
+	}
+	port := binary.BigEndian.Uint16(b[n:])
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), n + 2, nil
+}
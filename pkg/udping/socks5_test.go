@@ -0,0 +1,145 @@
+package udping
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// socksServer runs a SOCKS5 server relaying udp associations, asking for
+// user and password when user is not empty, and returns its address
+func socksServer(t *testing.T, user, password string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			ctrl, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go socksSession(ctrl, user, password)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// socksSession serves one association until its control connection closes
+func socksSession(ctrl net.Conn, user, password string) {
+	defer ctrl.Close()
+	b := make([]byte, 512)
+	if _, err := io.ReadFull(ctrl, b[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(ctrl, b[:b[1]]); err != nil {
+		return
+	}
+	if user == "" {
+		ctrl.Write([]byte{socksVersion, socksNoAuth})
+	} else {
+		ctrl.Write([]byte{socksVersion, socksUserPass})
+		if _, err := io.ReadFull(ctrl, b[:2]); err != nil {
+			return
+		}
+		name := make([]byte, b[1])
+		io.ReadFull(ctrl, name)
+		io.ReadFull(ctrl, b[:1])
+		pass := make([]byte, b[0])
+		io.ReadFull(ctrl, pass)
+		if string(name) != user || string(pass) != password {
+			ctrl.Write([]byte{socksAuthVersion, 1})
+			return
+		}
+		ctrl.Write([]byte{socksAuthVersion, 0})
+	}
+	// VER CMD RSV and an ipv4 address
+	if _, err := io.ReadFull(ctrl, b[:10]); err != nil || b[1] != socksAssociate {
+		return
+	}
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	defer relay.Close()
+	port := relay.LocalAddr().(*net.UDPAddr).Port
+	// bound to every address, the client falls back to the proxy's
+	ctrl.Write([]byte{socksVersion, 0, 0, socksIPv4, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+
+	go func() {
+		var client net.Addr
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if client == nil || from.String() == client.String() {
+				client = from
+				dst, hn, err := socksAddr(buf[3:n])
+				if err != nil {
+					continue
+				}
+				to, _ := net.ResolveUDPAddr("udp", dst)
+				relay.WriteTo(buf[3+hn:n], to)
+				continue
+			}
+			// an answer from the destination
+			h := socksHeader(from.(*net.UDPAddr))
+			relay.WriteTo(append(h, buf[:n]...), client)
+		}
+	}()
+	io.Copy(io.Discard, ctrl)
+}
+
+func TestSocksProxy(t *testing.T) {
+	port := echoServer(t, false)
+	for _, tc := range []struct {
+		name, user, password, url string
+		success                   bool
+	}{
+		{"no auth", "", "", "socks5://%s", true},
+		{"user and password", "u", "p", "socks5://u:p@%s", true},
+		{"wrong password", "u", "p", "socks5://u:x@%s", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := socksServer(t, tc.user, tc.password)
+			r := run(t, port, Params{Proxy: fmt.Sprintf(tc.url, addr), ExpectEcho: true, Payload: "hello", Count: 2})
+			for _, res := range r.Results {
+				if res.Success != tc.success {
+					t.Errorf("success %v, want %v: %v", res.Success, tc.success, res.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestSocksProxyDeadline(t *testing.T) {
+	// a proxy that accepts connections and never answers the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	start := time.Now()
+	r := run(t, echoServer(t, false), Params{Proxy: "socks5://" + ln.Addr().String(), Timeout: 100 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v, want the 100ms timeout to cover the handshake", elapsed)
+	}
+	if res := r.Results[0]; res.Success {
+		t.Error("a probe through a silent proxy succeeded")
+	}
+}
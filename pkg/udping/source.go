@@ -0,0 +1,71 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// localAddr returns the address probes are sent from for network, or nil to
+// let the system choose. Where sockets cannot be bound to the Interface, it
+// is the interface's address.
+func (r *Runner) localAddr(network string) (net.Addr, error) {
+	var ip net.IP
+	if r.Parameters.Source != "" {
+		if ip = net.ParseIP(r.Parameters.Source); ip == nil {
+			return nil, fmt.Errorf("invalid source address %q", r.Parameters.Source)
+		}
+	} else if r.Parameters.Interface != "" && !bindsToDevice {
+		var err error
+		if ip, err = interfaceIP(r.Parameters.Interface, r.Parameters.ipDest); err != nil {
+			return nil, err
+		}
+	}
+	if ip == nil && r.Parameters.SourcePort == 0 {
+		return nil, nil
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return &net.TCPAddr{IP: ip}, nil
+	case "udp", "udp4", "udp6":
+		port := r.Parameters.SourcePort
+		if port != 0 && r.Parameters.Spray > 1 {
+			port += r.last.spraySlot
+		}
+		return &net.UDPAddr{IP: ip, Port: port}, nil
+	}
+	return nil, fmt.Errorf("source address is not supported for %s", network)
+}
+
+// validateSource checks that the source address and port are usable for the destination
+func (r *Runner) validateSource() error {
+	if r.Parameters.SourcePort < 0 || r.Parameters.SourcePort > 65535 {
+		return &FieldError{"SourcePort", fmt.Errorf("source port must be between 1 and 65535, got %d", r.Parameters.SourcePort)}
+	}
+	if r.Parameters.SourcePort != 0 && r.Parameters.Protocol != "udp" {
+		// a tcp port lingers in TIME_WAIT after each probe and could not be bound again
+		return &FieldError{"SourcePort", fmt.Errorf("source port is only supported for udp pings")}
+	}
+	if r.Parameters.Source == "" && r.Parameters.SourcePort == 0 {
+		return nil
+	}
+	host := ""
+	if r.Parameters.Source != "" {
+		src := net.ParseIP(r.Parameters.Source)
+		if src == nil {
+			return fmt.Errorf("invalid source address %q", r.Parameters.Source)
+		}
+		if dst := net.ParseIP(r.Parameters.ipDest); dst != nil && (src.To4() == nil) != (dst.To4() == nil) {
+			return fmt.Errorf("source address %s and destination %s are not of the same address family", src, dst)
+		}
+		host = src.String()
+	}
+	// binding is the only reliable test, loopback answers for a whole range it does not list
+	local := net.JoinHostPort(host, strconv.Itoa(r.Parameters.SourcePort))
+	pc, err := net.ListenPacket("udp", local)
+	if err != nil {
+		return fmt.Errorf("source address %s is not usable on this host: %v", local, err)
+	}
+	pc.Close()
+	return nil
+}
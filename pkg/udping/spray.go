@@ -0,0 +1,62 @@
+package udping
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// spraySocket returns the socket of the next of the Spray source ports, so
+// that consecutive probes differ in their 5-tuple and routers hashing flows
+// over equal cost paths send them down different ones. Each port keeps its
+// socket for the run, its path staying the same from one probe to the next.
+func (r *Runner) spraySocket(ctx context.Context, destination string) (net.Conn, func(), error) {
+	if r.sprayConns == nil || r.sprayAddr != destination {
+		r.closeSocket()
+		r.sprayConns, r.sprayAddr = make([]net.Conn, r.Parameters.Spray), destination
+	}
+	slot := r.sprayNext % r.Parameters.Spray
+	r.sprayNext++
+	r.last.spraySlot = slot
+	if c := r.sprayConns[slot]; c != nil {
+		return c, func() {}, nil
+	}
+	c, err := r.newUDPSocket(ctx, destination)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.sprayConns[slot] = c
+	return c, func() {}, nil
+}
+
+// validateSpray checks that probes can be sprayed over source ports
+func (r *Runner) validateSpray() error {
+	n := r.Parameters.Spray
+	if n < 0 {
+		return fmt.Errorf("spray must not be negative, got %d", n)
+	}
+	if n <= 1 {
+		return nil
+	}
+	if r.Parameters.Protocol != "udp" {
+		return fmt.Errorf("spraying source ports is only supported for udp pings")
+	}
+	if r.Parameters.Burst > 1 || r.Parameters.Proxy != "" {
+		return fmt.Errorf("spraying source ports cannot be combined with bursts or a socks proxy, which send from a single socket")
+	}
+	if r.Parameters.SourcePort != 0 && r.Parameters.SourcePort+n-1 > 65535 {
+		return fmt.Errorf("spraying %d source ports from %d runs past port 65535", n, r.Parameters.SourcePort)
+	}
+	return nil
+}
+
+// portOf returns the port of the ip:port address addr, 0 when it has none
+func portOf(addr string) int {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
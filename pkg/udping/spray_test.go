@@ -0,0 +1,24 @@
+package udping
+
+import "testing"
+
+func TestSpray(t *testing.T) {
+	port := echoServer(t, false)
+	r := run(t, port, Params{Count: 6, Spray: 3, Payload: "hello"})
+	ports := map[int]int{}
+	for i, res := range r.Results {
+		if !res.Success || res.SourcePort == 0 {
+			t.Errorf("probe %d: success %v, source port %d, error %q", i, res.Success, res.SourcePort, res.Error)
+		}
+		ports[res.SourcePort]++
+		if i >= 3 && res.SourcePort != r.Results[i-3].SourcePort {
+			t.Errorf("probe %d sent from port %d, probe %d from %d", i, res.SourcePort, i-3, r.Results[i-3].SourcePort)
+		}
+	}
+	if len(ports) != 3 {
+		t.Errorf("probes sent from ports %v, want 3 ports", ports)
+	}
+	if r.sprayConns[0] != nil {
+		t.Error("spray sockets left open after the run")
+	}
+}
@@ -0,0 +1,141 @@
+package udping
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// maxRTTSample caps the RTTs kept for the percentiles. Past it they come from
+// a uniform sample of the run, so that long and continuous runs keep their
+// memory bounded while their percentiles stay close.
+const maxRTTSample = 10000
+
+type (
+	// Stats summarizes a run like ping's closing statistics. RTTs are in
+	// seconds and only cover successful probes; they are zero when none succeeded.
+	// Past 10000 successful probes, the percentiles are those of a uniform sample of them.
+	Stats struct {
+		Sent      int     `json:"sent"`                // Sent is the number of probes completed
+		Received  int     `json:"received"`            // Received is the number of successful probes
+		Loss      float64 `json:"loss"`                // Loss is the percentage of probes that did not succeed
+		Corrupted int     `json:"corrupted,omitempty"` // Corrupted is the number of probes whose echo came back with changed payload bytes, counted in Loss
+		MinRTT    float64 `json:"minrtt,omitempty"`    // MinRTT is the shortest RTT
+		AvgRTT    float64 `json:"avgrtt,omitempty"`    // AvgRTT is the mean RTT
+		MaxRTT    float64 `json:"maxrtt,omitempty"`    // MaxRTT is the longest RTT
+		StdDev    float64 `json:"stddev,omitempty"`    // StdDev is the population standard deviation of the RTTs
+		Jitter    float64 `json:"jitter,omitempty"`    // Jitter is the mean absolute difference between consecutive RTTs
+		// RFC3550Jitter is the interarrival jitter estimate of RFC 3550
+		// section 6.4.1, each RTT difference weighing 1/16, as RTP receivers
+		// report it
+		RFC3550Jitter float64 `json:"rfc3550jitter,omitempty"`
+		P50           float64 `json:"p50,omitempty"` // P50 is the median RTT
+		P90           float64 `json:"p90,omitempty"` // P90 is the 90th percentile RTT
+		P95           float64 `json:"p95,omitempty"` // P95 is the 95th percentile RTT
+		P99           float64 `json:"p99,omitempty"` // P99 is the 99th percentile RTT
+		// Histogram is the distribution of the RTTs, when the Histogram
+		// parameter is set
+		Histogram *Histogram `json:"histogram,omitempty"`
+	}
+
+	// statsAccumulator builds Stats one result at a time, so a summary is
+	// available even when results are discarded
+	statsAccumulator struct {
+		sent, received int
+		corrupted      int
+		min, max       float64
+		mean, m2       float64    // running mean and sum of squared deviations (Welford)
+		jitter         float64    // sum of absolute differences between consecutive RTTs
+		rfcJitter      float64    // running RFC 3550 jitter estimate
+		last           float64    // the latest RTT, for the jitter
+		rtts           []float64  // every RTT up to maxRTTSample, a reservoir sample of them past it, for the percentiles
+		hist           *Histogram // the RTTs bucketed, when the Histogram parameter is set
+	}
+)
+
+// add records a completed probe
+func (a *statsAccumulator) add(res Result) {
+	a.sent++
+	if res.Class == ClassCorrupted {
+		a.corrupted++
+	}
+	if !res.Success {
+		return
+	}
+	a.received++
+	if a.received == 1 || res.RTT < a.min {
+		a.min = res.RTT
+	}
+	if res.RTT > a.max {
+		a.max = res.RTT
+	}
+	d := res.RTT - a.mean
+	a.mean += d / float64(a.received)
+	a.m2 += d * (res.RTT - a.mean)
+	if a.received > 1 {
+		d := math.Abs(res.RTT - a.last)
+		a.jitter += d
+		a.rfcJitter += (d - a.rfcJitter) / 16
+	}
+	a.last = res.RTT
+	if len(a.rtts) < maxRTTSample {
+		a.rtts = append(a.rtts, res.RTT)
+	} else if i := rand.Intn(a.received); i < maxRTTSample {
+		// algorithm R: the n-th RTT replaces a kept one with probability maxRTTSample/n
+		a.rtts[i] = res.RTT
+	}
+	if a.hist != nil {
+		a.hist.Add(res.RTT)
+	}
+}
+
+// stats returns the summary of the probes added so far
+func (a *statsAccumulator) stats() Stats {
+	// a run that sent nothing reached nothing
+	s := Stats{Sent: a.sent, Received: a.received, Loss: 100, Corrupted: a.corrupted}
+	if a.sent > 0 {
+		s.Loss = 100 * float64(a.sent-a.received) / float64(a.sent)
+	}
+	if a.hist != nil {
+		s.Histogram = a.hist.clone()
+	}
+	if a.received == 0 {
+		return s
+	}
+	s.MinRTT, s.AvgRTT, s.MaxRTT = a.min, a.mean, a.max
+	s.StdDev = math.Sqrt(a.m2 / float64(a.received))
+	if a.received > 1 {
+		s.Jitter = a.jitter / float64(a.received-1)
+		s.RFC3550Jitter = a.rfcJitter
+	}
+	sorted := append([]float64(nil), a.rtts...)
+	sort.Float64s(sorted)
+	s.P50, s.P90 = percentile(sorted, 50), percentile(sorted, 90)
+	s.P95, s.P99 = percentile(sorted, 95), percentile(sorted, 99)
+	return s
+}
+
+// percentile returns the p-th percentile of the sorted values, interpolating
+// linearly between the two closest ranks. values must not be empty.
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// Summary returns the statistics of the probes completed so far, including
+// the ones not kept in Results because Discard is set
+func (r *Runner) Summary() Stats {
+	return r.stats.stats()
+}
+
+// Summarize returns the statistics of results, such as those of several runs
+// read back from where they were stored, as Summary would for a run of them
+func Summarize(results []Result) Stats {
+	var a statsAccumulator
+	for _, res := range results {
+		a.add(res)
+	}
+	return a.stats()
+}
@@ -0,0 +1,49 @@
+package udping
+
+import "context"
+
+// RunWithCallback is RunContext, calling fn with each result as soon as its
+// probe completes, after OnResult if that is set too. Set Discard as well to
+// keep nothing in memory, as continuous runs need.
+func (r *Runner) RunWithCallback(ctx context.Context, fn func(res Result)) error {
+	prev := r.OnResult
+	r.OnResult = func(res Result) {
+		if prev != nil {
+			prev(res)
+		}
+		fn(res)
+	}
+	defer func() { r.OnResult = prev }()
+	return r.RunContext(ctx)
+}
+
+// Stream runs the probes in the background and returns a channel receiving
+// each result as soon as its probe completes, closed once the run ends, and
+// a channel receiving the error of the run, nil included, before that:
+//
+//	results, errc := r.Stream(ctx)
+//	for res := range results {
+//		...
+//	}
+//	if err := <-errc; err != nil {
+//		return err
+//	}
+//
+// Results are not kept in Results. The run waits for each result to be
+// received, so stop reading only once ctx is done; a result completed after
+// that may be dropped.
+func (r *Runner) Stream(ctx context.Context) (<-chan Result, <-chan error) {
+	results := make(chan Result)
+	errc := make(chan error, 1)
+	r.Discard = true
+	go func() {
+		defer close(results)
+		errc <- r.RunWithCallback(ctx, func(res Result) {
+			select {
+			case results <- res:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return results, errc
+}
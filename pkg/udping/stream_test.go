@@ -0,0 +1,58 @@
+package udping
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	port := echoServer(t, false)
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp", Count: 3, Timeout: time.Second, Interval: time.Millisecond})
+	results, errc := r.Stream(context.Background())
+	n := 0
+	for res := range results {
+		if !res.Success {
+			t.Errorf("probe %d: %s", n, res.Error)
+		}
+		n++
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || len(r.Results) != 0 {
+		t.Errorf("streamed %d results and kept %d, want 3 and none", n, len(r.Results))
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	port := echoServer(t, false)
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp", Continuous: true, Timeout: time.Second, Interval: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	results, errc := r.Stream(ctx)
+	<-results
+	<-results
+	// stop reading: the run must still end
+	cancel()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("a cancelled stream is not an error, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the run did not end after cancelling")
+	}
+}
+
+func TestRunWithCallback(t *testing.T) {
+	port := echoServer(t, false)
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp", Count: 2, Timeout: time.Second, Interval: time.Millisecond})
+	var onResult, callback int
+	r.OnResult = func(Result) { onResult++ }
+	if err := r.RunWithCallback(context.Background(), func(Result) { callback++ }); err != nil {
+		t.Fatal(err)
+	}
+	if onResult != 2 || callback != 2 || r.OnResult == nil {
+		t.Errorf("OnResult called %d times and the callback %d, want both 2 and OnResult kept", onResult, callback)
+	}
+}
@@ -0,0 +1,139 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+const (
+	ModeSTUN = "stun" // send a STUN Binding request and report the mapped address of the answer
+
+	E_STUNReply = "invalid stun reply"
+
+	// stunHeaderLen is the size of a STUN message header, RFC 8489 section 5
+	stunHeaderLen = 20
+	// stunMagicCookie is carried by every RFC 5389 and later message
+	stunMagicCookie = 0x2112a442
+)
+
+// STUN message types and attributes
+const (
+	stunBindingRequest   = 0x0001
+	stunBindingSuccess   = 0x0101
+	stunBindingError     = 0x0111
+	stunMappedAddress    = 0x0001
+	stunErrorCode        = 0x0009
+	stunXorMappedAddress = 0x0020
+)
+
+// stunProbe builds a STUN Binding request with a random transaction ID and
+// returns it alongside a function that checks a reply is the success
+// response to it, recording the reflexive address the server saw the
+// request come from.
+func (r *Runner) stunProbe() ([]byte, func([]byte) error, error) {
+	query := make([]byte, stunHeaderLen)
+	binary.BigEndian.PutUint16(query[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(query[4:8], stunMagicCookie)
+	if _, err := rand.Read(query[8:20]); err != nil {
+		return nil, nil, err
+	}
+	txID := query[8:20]
+
+	check := func(reply []byte) error {
+		if len(reply) < stunHeaderLen || reply[0]&0xc0 != 0 || binary.BigEndian.Uint32(reply[4:8]) != stunMagicCookie {
+			return fmt.Errorf("%s: not a stun message", E_STUNReply)
+		}
+		if !bytes.Equal(reply[8:20], txID) {
+			// the answer to another request
+			return errUncorrelated
+		}
+		n := int(binary.BigEndian.Uint16(reply[2:4]))
+		if len(reply) < stunHeaderLen+n {
+			return fmt.Errorf("%s: %d bytes, header says %d", E_STUNReply, len(reply), stunHeaderLen+n)
+		}
+		attrs := stunAttributes(reply[stunHeaderLen : stunHeaderLen+n])
+
+		switch binary.BigEndian.Uint16(reply[0:2]) {
+		case stunBindingSuccess:
+		case stunBindingError:
+			if v, ok := attrs[stunErrorCode]; ok && len(v) >= 4 {
+				return r.serviceError(fmt.Errorf("%s: error %d %s", E_STUNReply, int(v[2]&7)*100+int(v[3]), v[4:]))
+			}
+			return r.serviceError(fmt.Errorf("%s: error response", E_STUNReply))
+		default:
+			return fmt.Errorf("%s: unexpected message type %#04x", E_STUNReply, binary.BigEndian.Uint16(reply[0:2]))
+		}
+
+		var mapped string
+		if v, ok := attrs[stunXorMappedAddress]; ok {
+			mapped = stunAddress(v, reply[4:20])
+		} else if v, ok := attrs[stunMappedAddress]; ok {
+			// servers predating RFC 5389 send the address as it is
+			mapped = stunAddress(v, nil)
+		}
+		if mapped == "" {
+			return fmt.Errorf("%s: no mapped address", E_STUNReply)
+		}
+		r.last.mappedAddress = mapped
+		return nil
+	}
+	return query, check, nil
+}
+
+// stunAttributes returns the values of the attributes in b by type, the first of each type
+func stunAttributes(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= 4 {
+		typ, n := binary.BigEndian.Uint16(b[0:2]), int(binary.BigEndian.Uint16(b[2:4]))
+		if len(b) < 4+n {
+			break
+		}
+		if _, ok := attrs[typ]; !ok {
+			attrs[typ] = b[4 : 4+n]
+		}
+		// values are padded to a multiple of 4 bytes
+		if skip := 4 + (n+3)&^3; skip < len(b) {
+			b = b[skip:]
+		} else {
+			break
+		}
+	}
+	return attrs
+}
+
+// stunAddress decodes a MAPPED-ADDRESS value as ip:port, xored with the
+// magic cookie and transaction ID in xor when it is an XOR-MAPPED-ADDRESS
+// value. It returns an empty string for a malformed value.
+func stunAddress(v, xor []byte) string {
+	if len(v) < 4 {
+		return ""
+	}
+	var size int
+	switch v[1] {
+	case 1:
+		size = net.IPv4len
+	case 2:
+		size = net.IPv6len
+	default:
+		return ""
+	}
+	if len(v) < 4+size {
+		return ""
+	}
+	port := []byte{v[2], v[3]}
+	ip := make(net.IP, size)
+	copy(ip, v[4:4+size])
+	for i := range xor {
+		if i < len(port) {
+			port[i] ^= xor[i]
+		}
+		if i < len(ip) {
+			ip[i] ^= xor[i]
+		}
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
@@ -0,0 +1,134 @@
+package udping
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const (
+	ModeTFTP = "tftp" // send a TFTP read request for a file that does not exist and accept any reply, an ERROR packet too
+
+	E_TFTPReply = "invalid tftp reply"
+
+	// TFTPPort is probed in tftp mode when no destination port is given
+	TFTPPort = 69
+)
+
+// TFTP opcodes, RFC 1350 section 5 and RFC 2347
+const (
+	tftpRRQ   = 1
+	tftpData  = 3
+	tftpError = 5
+	tftpOACK  = 6
+)
+
+// tftpProbe builds a read request, RFC 1350, for a randomly named file and
+// returns it alongside a function that checks a reply is a TFTP packet. The
+// file should not exist, so the server's usual answer is an ERROR packet,
+// which proves a TFTP server listens as well as the file would: its code and
+// message are recorded as the service error. DATA and OACK, should the file
+// exist after all, count too. The server answers from a port of its own, its
+// transfer ID, which is why tftp probes go over an unconnected socket.
+func (r *Runner) tftpProbe() ([]byte, func([]byte) error, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, err
+	}
+	var b bytes.Buffer
+	b.Write([]byte{0, tftpRRQ})
+	b.WriteString("udping-" + hex.EncodeToString(id[:]))
+	b.WriteByte(0)
+	b.WriteString("octet")
+	b.WriteByte(0)
+
+	check := func(reply []byte) error {
+		if len(reply) < 4 || reply[0] != 0 {
+			return fmt.Errorf("%s: not a tftp packet", E_TFTPReply)
+		}
+		switch reply[1] {
+		case tftpData:
+			r.debugf("tftp data block %d\n", binary.BigEndian.Uint16(reply[2:4]))
+		case tftpOACK:
+			r.debugf("tftp option acknowledgment\n")
+		case tftpError:
+			msg := reply[4:]
+			if i := bytes.IndexByte(msg, 0); i >= 0 {
+				msg = msg[:i]
+			}
+			return r.serviceError(fmt.Errorf("%s: error %d %s", E_TFTPReply, binary.BigEndian.Uint16(reply[2:4]), msg))
+		default:
+			return fmt.Errorf("%s: unexpected opcode %d", E_TFTPReply, binary.BigEndian.Uint16(reply[0:2]))
+		}
+		return nil
+	}
+	return b.Bytes(), check, nil
+}
+
+// tftpConn is an unconnected udp socket that sends to one destination and
+// reads the datagrams coming from any port of its address, as the transfer
+// ID of a TFTP server is. It does not embed the *net.UDPConn, whose reads of
+// any source would bypass the filter.
+type tftpConn struct {
+	c     *net.UDPConn
+	raddr *net.UDPAddr
+}
+
+// listenTFTP opens the unconnected socket tftp probes to destination are
+// sent over, bound as a dialled socket would be
+func (r *Runner) listenTFTP(ctx context.Context, destination string) (*net.UDPConn, *net.UDPAddr, error) {
+	network := r.network("udp")
+	raddr, err := net.ResolveUDPAddr(network, destination)
+	if err != nil {
+		return nil, nil, err
+	}
+	if network == "udp" {
+		// bind the family of the destination, a wildcard of the other one cannot reach it
+		network = "udp4"
+		if raddr.IP.To4() == nil {
+			network = "udp6"
+		}
+	}
+	local := ""
+	addr, err := r.localAddr(network)
+	if err != nil {
+		return nil, nil, err
+	}
+	if addr != nil {
+		local = addr.String()
+	}
+	lc := net.ListenConfig{Control: bindDevice(r.Parameters.Interface)}
+	pc, err := lc.ListenPacket(ctx, network, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pc.(*net.UDPConn), raddr, nil
+}
+
+func (c *tftpConn) Read(b []byte) (int, error) {
+	for {
+		n, from, err := c.c.ReadFromUDP(b)
+		if err != nil || from.IP.Equal(c.raddr.IP) {
+			return n, err
+		}
+		// a stray datagram from another host
+	}
+}
+
+func (c *tftpConn) Write(b []byte) (int, error) { return c.c.WriteToUDP(b, c.raddr) }
+func (c *tftpConn) Close() error                { return c.c.Close() }
+func (c *tftpConn) LocalAddr() net.Addr         { return c.c.LocalAddr() }
+func (c *tftpConn) RemoteAddr() net.Addr        { return c.raddr }
+
+func (c *tftpConn) SetDeadline(t time.Time) error      { return c.c.SetDeadline(t) }
+func (c *tftpConn) SetReadDeadline(t time.Time) error  { return c.c.SetReadDeadline(t) }
+func (c *tftpConn) SetWriteDeadline(t time.Time) error { return c.c.SetWriteDeadline(t) }
+
+// SyscallConn reaches the socket underneath, for its error queue
+func (c *tftpConn) SyscallConn() (syscall.RawConn, error) { return c.c.SyscallConn() }
@@ -0,0 +1,47 @@
+package udping
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tftpServer runs a server answering read requests with a file not found
+// ERROR sent from another port, its transfer ID, and returns its port
+func tftpServer(t *testing.T) int {
+	t.Helper()
+	pc := listenUDP(t)
+	tid := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 2 || buf[1] != tftpRRQ {
+				continue
+			}
+			tid.WriteTo(append([]byte{0, tftpError, 0, 1}, "File not found\x00"...), addr)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestTFTPMode(t *testing.T) {
+	res := run(t, tftpServer(t), Params{Mode: ModeTFTP, Timeout: 500 * time.Millisecond}).Results[0]
+	if !res.Success || res.Outcome != OutcomeAnswered || res.ServiceError != E_TFTPReply+": error 1 File not found" {
+		t.Errorf("success %v, outcome %s, error %q, service error %q", res.Success, res.Outcome, res.Error, res.ServiceError)
+	}
+
+	res = run(t, closedPort(t), Params{Mode: ModeTFTP, Timeout: 500 * time.Millisecond}).Results[0]
+	if res.Outcome != OutcomeClosed {
+		t.Errorf("outcome %s, error %q for a closed port, want %s", res.Outcome, res.Error, OutcomeClosed)
+	}
+
+	res = run(t, echoServer(t, false), Params{Mode: ModeTFTP, Timeout: 500 * time.Millisecond}).Results[0]
+	if res.Success || !strings.HasPrefix(res.Error, E_TFTPReply) {
+		t.Errorf("success %v, error %q for a reflected request, want a %q failure", res.Success, res.Error, E_TFTPReply)
+	}
+}
@@ -0,0 +1,136 @@
+package udping
+
+import "time"
+
+const (
+	// DefaultEscalateStart is the first per-probe timeout in escalation mode
+	DefaultEscalateStart = 250 * time.Millisecond
+
+	// escalateWindow is how many recent RTTs the escalating timeout is based on
+	escalateWindow = 5
+	// escalateFactor multiplies the recent average RTT to get the next timeout
+	escalateFactor = 4
+)
+
+// escalator adapts the per-probe timeout during a run. It starts at a short
+// timeout, doubles it after every timeout up to the configured Timeout, and
+// after a reply settles on escalateFactor times the recent average RTT, never
+// below the start value. Fast hosts are probed quickly while slow replies
+// are still caught once the timeout has grown.
+type escalator struct {
+	start   time.Duration
+	max     time.Duration
+	current time.Duration
+	recent  []time.Duration
+}
+
+func newEscalator(start, max time.Duration) *escalator {
+	if start <= 0 {
+		start = DefaultEscalateStart
+	}
+	if start > max {
+		start = max
+	}
+	return &escalator{start: start, max: max, current: start}
+}
+
+// next returns the timeout for the next probe
+func (e *escalator) next() time.Duration {
+	return e.current
+}
+
+// observe adjusts the timeout after a probe. answered is false when the probe timed out.
+func (e *escalator) observe(answered bool, rtt time.Duration) {
+	if !answered {
+		e.current *= 2
+		if e.current > e.max {
+			e.current = e.max
+		}
+		return
+	}
+
+	e.recent = append(e.recent, rtt)
+	if len(e.recent) > escalateWindow {
+		e.recent = e.recent[1:]
+	}
+	var sum time.Duration
+	for _, d := range e.recent {
+		sum += d
+	}
+	e.current = escalateFactor * sum / time.Duration(len(e.recent))
+	if e.current < e.start {
+		e.current = e.start
+	}
+	if e.current > e.max {
+		e.current = e.max
+	}
+}
+
+const (
+	// adaptiveFloor is the shortest adaptive timeout, so that a loopback path
+	// does not time out on scheduling jitter alone
+	adaptiveFloor = 10 * time.Millisecond
+	// adaptiveFactor is the smallest multiple of the smoothed RTT waited for
+	adaptiveFactor = 2
+)
+
+// timeoutAdapter adapts the per-probe timeout to the replies of a run
+type timeoutAdapter interface {
+	// next returns the timeout for the next probe
+	next() time.Duration
+	// observe adjusts the timeout after a probe. answered is false when the probe timed out.
+	observe(answered bool, rtt time.Duration)
+}
+
+// rtoEstimator computes the per-probe timeout as TCP computes its
+// retransmission timeout, RFC 6298: the smoothed RTT plus four times its
+// variation, never below adaptiveFactor times the smoothed RTT. It starts at
+// the configured Timeout, so that the first replies are not missed, and
+// backs off by doubling after a timeout, up to that Timeout again.
+type rtoEstimator struct {
+	max     time.Duration
+	current time.Duration
+	srtt    time.Duration
+	rttvar  time.Duration
+}
+
+func newRTOEstimator(max time.Duration) *rtoEstimator {
+	return &rtoEstimator{max: max, current: max}
+}
+
+func (e *rtoEstimator) next() time.Duration {
+	return e.current
+}
+
+func (e *rtoEstimator) observe(answered bool, rtt time.Duration) {
+	if !answered {
+		e.current *= 2
+		if e.current > e.max {
+			e.current = e.max
+		}
+		return
+	}
+
+	if e.srtt == 0 {
+		e.srtt = rtt
+		e.rttvar = rtt / 2
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		// alpha = 1/8 and beta = 1/4
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.current = e.srtt + 4*e.rttvar
+	if e.current < adaptiveFactor*e.srtt {
+		e.current = adaptiveFactor * e.srtt
+	}
+	if e.current < adaptiveFloor {
+		e.current = adaptiveFloor
+	}
+	if e.current > e.max {
+		e.current = e.max
+	}
+}
@@ -0,0 +1,15 @@
+package udping
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isTransientSendError reports whether sending a probe failed for a reason
+// that may well be gone a moment later: a conntrack or firewall rule
+// rejecting the packet under load, full socket buffers, or a route being
+// replaced. Probes failing this way are sent again like timed out ones.
+func isTransientSendError(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN) ||
+		isUnreachable(err) || errors.Is(err, syscall.ENETDOWN)
+}
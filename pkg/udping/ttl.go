@@ -0,0 +1,59 @@
+package udping
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// E_TTLExceeded is the error of a probe dropped by a router on the way once its TTL ran out
+const E_TTLExceeded = "ttl exceeded in transit"
+
+// maxTTL is the largest value the 8 bit TTL and hop limit fields hold
+const maxTTL = 255
+
+// setTTL applies the TTL parameter to c, as the hop limit for IPv6, and asks
+// for the time exceeded errors it causes to be reported back on c
+func (r *Runner) setTTL(c net.Conn) error {
+	if r.Parameters.TTL == 0 {
+		return nil
+	}
+	var err error
+	if isIPv6(r.Parameters.ipDest) {
+		err = ipv6.NewConn(c).SetHopLimit(r.Parameters.TTL)
+	} else {
+		err = ipv4.NewConn(c).SetTTL(r.Parameters.TTL)
+	}
+	if err != nil {
+		return fmt.Errorf("setting ttl: %v", err)
+	}
+	return recvICMPErrors(c, isIPv6(r.Parameters.ipDest))
+}
+
+// icmpReport is an ICMP error queued on a probe's socket
+type icmpReport struct {
+	from         string // the router that sent it, empty when the local stack refused the probe
+	timeExceeded bool
+	fragNeeded   bool
+	mtu          int // the mtu the probe must fit, for fragNeeded
+}
+
+// icmpError returns the error for a failed read on c when a router reported
+// the probe expired in transit, or too big to forward without fragmenting it,
+// naming that router
+func (r *Runner) icmpError(c net.Conn) error {
+	rep, ok := readICMPError(c)
+	switch {
+	case !ok:
+		return nil
+	case rep.timeExceeded:
+		return fmt.Errorf("%s at %s", E_TTLExceeded, rep.from)
+	case rep.from == "":
+		r.last.mtu, r.last.fragRejected = rep.mtu, FragLocal
+		return fmt.Errorf("%s, local mtu %d", E_FragNeeded, rep.mtu)
+	}
+	r.last.mtu, r.last.fragRejected = rep.mtu, FragPath
+	return fmt.Errorf("%s at %s, next hop mtu %d", E_FragNeeded, rep.from, rep.mtu)
+}
@@ -0,0 +1,107 @@
+package udping
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// ICMP error origins, types and codes, from linux/errqueue.h and the ICMP RFCs
+const (
+	eeOriginLocal     = 1
+	eeOriginICMP      = 2
+	eeOriginICMP6     = 3
+	icmpDestUnreach   = 3
+	icmpFragNeeded    = 4
+	icmpTimeExceeded  = 11
+	icmp6PacketTooBig = 2
+	icmp6TimeExceeded = 3
+	// sizeofExtendedErr is the size of struct sock_extended_err, which the offender address follows
+	sizeofExtendedErr = 16
+)
+
+// recvICMPErrors turns on IP_RECVERR, so that the ICMP errors answering a
+// probe are queued on its socket instead of only being retried silently
+func recvICMPErrors(c net.Conn, v6 bool) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = rc.Control(func(fd uintptr) {
+		if v6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// readICMPError reads the error queue of c and returns the ICMP error
+// queued there, if it is a time exceeded or fragmentation needed error
+func readICMPError(c net.Conn) (icmpReport, bool) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return icmpReport{}, false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return icmpReport{}, false
+	}
+	oob := make([]byte, 512)
+	var oobn int
+	var rerr error
+	err = rc.Read(func(fd uintptr) bool {
+		_, oobn, _, _, rerr = syscall.Recvmsg(int(fd), nil, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+		// never wait, the queue is either filled already or the error was something else
+		return true
+	})
+	if err != nil || rerr != nil {
+		return icmpReport{}, false
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return icmpReport{}, false
+	}
+	for _, m := range msgs {
+		if len(m.Data) < sizeofExtendedErr {
+			continue
+		}
+		origin, typ, code := m.Data[4], m.Data[5], m.Data[6]
+		// ee_info, in host byte order, holds the mtu of a fragmentation needed error
+		mtu := int(*(*uint32)(unsafe.Pointer(&m.Data[8])))
+		offender := m.Data[sizeofExtendedErr:]
+		switch {
+		case origin == eeOriginLocal && syscall.Errno(*(*uint32)(unsafe.Pointer(&m.Data[0]))) == syscall.EMSGSIZE:
+			// larger than the mtu of the outgoing interface
+			return icmpReport{fragNeeded: true, mtu: mtu}, true
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_RECVERR && origin == eeOriginICMP && len(offender) >= 8:
+			// sockaddr_in: family, port, then the address
+			from := net.IP(offender[4:8]).String()
+			if typ == icmpTimeExceeded {
+				return icmpReport{timeExceeded: true, from: from}, true
+			}
+			if typ == icmpDestUnreach && code == icmpFragNeeded {
+				return icmpReport{fragNeeded: true, from: from, mtu: mtu}, true
+			}
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_RECVERR && origin == eeOriginICMP6 && len(offender) >= 24:
+			// sockaddr_in6: family, port, flow info, then the address
+			from := net.IP(offender[8:24]).String()
+			if typ == icmp6TimeExceeded {
+				return icmpReport{timeExceeded: true, from: from}, true
+			}
+			if typ == icmp6PacketTooBig {
+				return icmpReport{fragNeeded: true, from: from, mtu: mtu}, true
+			}
+		}
+	}
+	return icmpReport{}, false
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package udping
+
+import "net"
+
+// recvICMPErrors is not available on this platform, expired probes time out
+func recvICMPErrors(c net.Conn, v6 bool) error {
+	return nil
+}
+
+// readICMPError is not available on this platform
+func readICMPError(c net.Conn) (icmpReport, bool) {
+	return icmpReport{}, false
+}
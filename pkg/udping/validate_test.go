@@ -0,0 +1,164 @@
+package udping
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateParameters(t *testing.T) {
+	valid := func() Params {
+		return Params{Destination: "127.0.0.1", DestinationPort: 53, Protocol: "udp"}
+	}
+	for _, tc := range []struct {
+		name    string
+		edit    func(p *Params)
+		wantErr string // substring of the error, empty when the parameters are valid
+	}{
+		{"defaults", func(p *Params) {}, ""},
+		{"port 0", func(p *Params) { p.DestinationPort = 0 }, ""},
+		{"port 65535", func(p *Params) { p.DestinationPort = 65535 }, ""},
+		{"negative port", func(p *Params) { p.DestinationPort = -1 }, "valid destination port"},
+		{"port too high", func(p *Params) { p.DestinationPort = 65536 }, "valid destination port"},
+		{"icmp with a port", func(p *Params) { p.Protocol = "icmp" }, "does not use a destination port"},
+		{"bracketed ipv6", func(p *Params) { p.Destination = "[::1]" }, ""},
+		{"both families", func(p *Params) { p.IPv4Only, p.IPv6Only = true, true }, "cannot both be set"},
+		{"ipv4 only to ipv6", func(p *Params) { p.Destination, p.IPv4Only = "::1", true }, "IPv4"},
+		{"negative timeout", func(p *Params) { p.Timeout = -time.Second }, "timeout must not be negative"},
+		{"sub-second timeout", func(p *Params) { p.Timeout = 50 * time.Millisecond }, ""},
+		{"negative escalation start", func(p *Params) { p.EscalateTimeout, p.EscalateStart = true, -time.Second }, "escalation start"},
+		{"negative count", func(p *Params) { p.Count = -1 }, "count must be at least 1"},
+		{"unknown mode", func(p *Params) { p.Mode = "gopher" }, "unknown probe mode"},
+		{"size too big", func(p *Params) { p.Size = maxUDPPayload + 1 }, "payload size"},
+		{"largest size", func(p *Params) { p.Size = maxUDPPayload }, ""},
+		{"invalid pattern", func(p *Params) { p.Pattern = "zz" }, "pattern"},
+		{"dscp and tos", func(p *Params) { p.DSCP, p.TOS = 46, 0xb8 }, "cannot both be set"},
+		{"ttl over tcp", func(p *Params) { p.Protocol, p.TTL = "tcp", 5 }, "ttl is only supported for udp"},
+		{"expect over tcp", func(p *Params) { p.Protocol, p.Expect = "tcp", "x" }, "only supported for udp"},
+		{"expect echo in dns mode", func(p *Params) { p.Mode, p.ExpectEcho = ModeDNS, true }, "raw mode"},
+		{"sequence in ntp mode", func(p *Params) { p.Mode, p.Sequence = ModeNTP, true }, "raw or echo mode"},
+		{"reuse socket over tcp", func(p *Params) { p.Protocol, p.ReuseSocket = "tcp", true }, "only supported for udp"},
+		{"dont fragment over tcp", func(p *Params) { p.Protocol, p.DontFragment = "tcp", true }, "only supported for udp"},
+		{"unknown success policy", func(p *Params) { p.SuccessPolicy = "optimistic" }, "unknown success policy"},
+		{"refusal fails not closed", func(p *Params) { p.RefusalFails, p.SuccessPolicy = true, SuccessNotClosed }, "success policy"},
+		{"negative receive buffer", func(p *Params) { p.RecvBuffer = -1 }, "receive buffer"},
+		{"reresolve with a fixed ip", func(p *Params) { p.IP, p.Reresolve = "127.0.0.1", time.Minute }, "fixed destination IP"},
+		{"tftp through a proxy", func(p *Params) { p.Mode, p.Proxy = ModeTFTP, "socks5://127.0.0.1:1080" }, "tftp probes"},
+		{"burst in dns mode", func(p *Params) { p.Mode, p.Burst = ModeDNS, 4 }, "raw or echo mode"},
+		{"spray over tcp", func(p *Params) { p.Protocol, p.Spray = "tcp", 4 }, "only supported for udp"},
+		{"spray past the last port", func(p *Params) { p.SourcePort, p.Spray = 65534, 4 }, "past port 65535"},
+		{"http proxy", func(p *Params) { p.Proxy = "http://127.0.0.1:8080" }, "socks5://"},
+		{"proxy with ttl", func(p *Params) { p.Proxy, p.TTL = "socks5://127.0.0.1:1080", 5 }, "through a socks proxy"},
+		{"unknown interface", func(p *Params) { p.Interface = "nosuchif0" }, "interface"},
+		{"no resolve with an ip", func(p *Params) { p.NoResolve = true }, ""},
+		{"no resolve with a name", func(p *Params) { p.Destination, p.NoResolve = "localhost", true }, "resolving is disabled"},
+		{"resolver by name", func(p *Params) { p.Resolver = "dns.example" }, "resolver must be an IP address"},
+		{"resolver without port", func(p *Params) { p.Resolver = "127.0.0.1" }, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := valid()
+			tc.edit(&p)
+			err := New(p).ValidateParameters()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tc.wantErr)
+			}
+			if !errors.Is(err, ErrInvalidParameters) {
+				t.Errorf("error %v is not ErrInvalidParameters", err)
+			}
+		})
+	}
+}
+
+func TestValidateDefaults(t *testing.T) {
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: 53, Protocol: "udp"})
+	if err := r.ValidateParameters(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Parameters.Timeout != 5*time.Second || r.Parameters.Count != 3 {
+		t.Errorf("timeout %v and count %d, want the 5s and 3 defaults", r.Parameters.Timeout, r.Parameters.Count)
+	}
+
+	strict := New(Params{Destination: "127.0.0.1", DestinationPort: 53, Protocol: "udp"})
+	if err := strict.ValidateStrict(); err == nil {
+		t.Error("strict validation accepted a missing timeout and count")
+	}
+	if strict.Parameters.Timeout != 0 || strict.Parameters.Count != 0 {
+		t.Errorf("strict validation changed the parameters to timeout %v and count %d", strict.Parameters.Timeout, strict.Parameters.Count)
+	}
+}
+
+func TestResolverAddr(t *testing.T) {
+	for in, want := range map[string]string{
+		"1.1.1.1":       "1.1.1.1:53",
+		"1.1.1.1:5353":  "1.1.1.1:5353",
+		"::1":           "[::1]:53",
+		"[2001:db8::1]": "",
+		"[::1]:53":      "[::1]:53",
+		"dns.example":   "",
+	} {
+		got, err := resolverAddr(in)
+		if (err != nil) != (want == "") || got != want {
+			t.Errorf("resolverAddr(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestParamsJSONDurations(t *testing.T) {
+	var p Params
+	if err := json.Unmarshal([]byte(`{"destination":"127.0.0.1","timeout":"250ms","Interval":2,"backoffcap":"1.5s","reresolve":"90"}`), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Destination != "127.0.0.1" || p.Timeout != 250*time.Millisecond || p.Interval != 2*time.Second || p.BackoffCap != 1500*time.Millisecond ||
+		p.Reresolve != 90*time.Second {
+		t.Errorf("decoded %+v", p)
+	}
+	// encoded parameters decode to themselves
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back Params
+	if err := json.Unmarshal(b, &back); err != nil || back != p {
+		t.Errorf("round trip gave %+v, %v", back, err)
+	}
+	// bare numbers are seconds, as on the command line
+	var secs Params
+	if err := json.Unmarshal([]byte(`{"timeout":5,"interval":0.25}`), &secs); err != nil || secs.Timeout != 5*time.Second || secs.Interval != 250*time.Millisecond {
+		t.Errorf("decoded timeout %v and interval %v, %v, want 5s and 250ms", secs.Timeout, secs.Interval, err)
+	}
+	if !strings.Contains(string(b), `"timeout":0.25`) || !strings.Contains(string(b), `"interval":2,`) {
+		t.Errorf("encoded %s, want durations in seconds", b)
+	}
+	if err := json.Unmarshal([]byte(`{"timeout":"soon"}`), &p); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("invalid duration: got %v", err)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: 70000, Protocol: "udp", TTL: -1, DNSType: "BOGUS", Mode: ModeDNS})
+	err := r.ValidateStrict()
+	if !errors.Is(err, ErrInvalidParameters) {
+		t.Fatalf("got %v, want an ErrInvalidParameters error", err)
+	}
+	var v *ValidationError
+	if !errors.As(err, &v) {
+		t.Fatalf("got %T, want a *ValidationError", err)
+	}
+	want := []string{"DestinationPort", "DNSType", "TTL", "Timeout", "Count"}
+	if got := v.Fields(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("fields %v, want %v", got, want)
+	}
+	for _, fe := range v.Errors {
+		if !strings.Contains(err.Error(), fe.Error()) {
+			t.Errorf("message %q leaves out %q", err, fe)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package udping
+
+import (
+	"errors"
+	"strings"
+)
+
+// FieldError is a problem with one field of Params
+type FieldError struct {
+	Field string // Field is the name of the Params field at fault, such as "Timeout"
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Err.Error() }
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// ValidationError lists every problem found with the parameters, in the
+// order they were checked, rather than only the first. It matches
+// ErrInvalidParameters with errors.Is, and unwraps to its first problem.
+type ValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool { return target == ErrInvalidParameters }
+
+func (e *ValidationError) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
+
+// Fields returns the names of the fields at fault, each once
+func (e *ValidationError) Fields() []string {
+	var fields []string
+	seen := map[string]bool{}
+	for _, fe := range e.Errors {
+		if !seen[fe.Field] {
+			seen[fe.Field] = true
+			fields = append(fields, fe.Field)
+		}
+	}
+	return fields
+}
+
+// add records err against field, unless err is nil. An error that already
+// names the field at fault keeps it.
+func (e *ValidationError) add(field string, err error) {
+	if err == nil {
+		return
+	}
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		e.Errors = append(e.Errors, fe)
+		return
+	}
+	e.Errors = append(e.Errors, &FieldError{Field: field, Err: err})
+}
+
+// err returns e when it lists any problem, nil otherwise
+func (e *ValidationError) err() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
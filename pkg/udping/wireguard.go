@@ -0,0 +1,97 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+const (
+	ModeWireGuard = "wireguard" // send a WireGuard handshake initiation and accept the server's response or cookie reply
+
+	E_WireGuardReply = "invalid wireguard reply"
+
+	// wgKeyLen is the size of a Curve25519 public key
+	wgKeyLen = 32
+	// wgLabelMAC1 prefixes the server's public key in the key of the first MAC
+	wgLabelMAC1 = "mac1----"
+)
+
+// WireGuard message types and sizes, from the protocol paper, section 5.4
+const (
+	wgInitiation     = 1
+	wgResponse       = 2
+	wgCookieReply    = 3
+	wgInitiationLen  = 148
+	wgResponseLen    = 92
+	wgCookieReplyLen = 64
+	// wgMAC1Offset is where the first MAC of an initiation goes, after what it covers
+	wgMAC1Offset = 116
+)
+
+// wgPublicKey decodes the base64 public key of a WireGuard server, as wg(8) prints it
+func wgPublicKey(key string) ([]byte, error) {
+	if key == "" {
+		return nil, fmt.Errorf("wireguard mode needs the public key of the server")
+	}
+	pub, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(pub) != wgKeyLen {
+		return nil, fmt.Errorf("wireguard public key must be %d bytes in base64, got %q", wgKeyLen, key)
+	}
+	return pub, nil
+}
+
+// wireguardProbe builds a handshake initiation and returns it alongside a
+// function that checks a reply answers it. A server drops any datagram whose
+// first MAC, keyed with its public key, does not check out, so only that MAC
+// is genuine: the ephemeral key and encrypted fields are random. The server
+// then fails to decrypt the static key and drops the initiation too, unless
+// it is under load and first answers with a cookie reply. A port refusing the
+// probe has no server behind it; silence is what a server normally answers.
+func (r *Runner) wireguardProbe() ([]byte, func([]byte) error, error) {
+	pub, err := wgPublicKey(r.Parameters.WireGuardKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := make([]byte, wgInitiationLen)
+	msg[0] = wgInitiation
+	// sender index, ephemeral key, encrypted static key and timestamp
+	if _, err := rand.Read(msg[4:wgMAC1Offset]); err != nil {
+		return nil, nil, err
+	}
+	mac1Key := blake2s(32, nil, append([]byte(wgLabelMAC1), pub...))
+	copy(msg[wgMAC1Offset:], blake2s(16, mac1Key, msg[:wgMAC1Offset]))
+	// the second MAC stays zero without a cookie
+	sender := msg[4:8]
+
+	check := func(reply []byte) error {
+		if len(reply) < 8 || reply[1]|reply[2]|reply[3] != 0 {
+			return fmt.Errorf("%s: not a wireguard message", E_WireGuardReply)
+		}
+		var receiver []byte
+		switch reply[0] {
+		case wgResponse:
+			if len(reply) != wgResponseLen {
+				return fmt.Errorf("%s: handshake response of %d bytes", E_WireGuardReply, len(reply))
+			}
+			receiver = reply[8:12]
+		case wgCookieReply:
+			if len(reply) != wgCookieReplyLen {
+				return fmt.Errorf("%s: cookie reply of %d bytes", E_WireGuardReply, len(reply))
+			}
+			receiver = reply[4:8]
+		default:
+			return fmt.Errorf("%s: unexpected message type %d", E_WireGuardReply, reply[0])
+		}
+		if !bytes.Equal(receiver, sender) {
+			// the answer to another initiation
+			return errUncorrelated
+		}
+		if reply[0] == wgCookieReply {
+			r.debugf("cookie reply, the server is under load\n")
+		}
+		return nil
+	}
+	return msg, check, nil
+}
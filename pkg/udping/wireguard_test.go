@@ -0,0 +1,83 @@
+package udping
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBlake2s(t *testing.T) {
+	for _, tc := range []struct {
+		size      int
+		key, data string
+		want      string
+	}{
+		// RFC 7693 appendix B
+		{32, "", "abc", "508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"},
+		{16, strings.Repeat("k", 32), "", "9881f5423279891d5742261a168c1aa2"},
+	} {
+		if got := hex.EncodeToString(blake2s(tc.size, []byte(tc.key), []byte(tc.data))); got != tc.want {
+			t.Errorf("blake2s(%d, %q, %q) = %s, want %s", tc.size, tc.key, tc.data, got, tc.want)
+		}
+	}
+}
+
+// wireguardServer runs a server that, like a WireGuard server under load,
+// answers initiations carrying a valid first MAC with a cookie reply, and
+// returns its port and public key
+func wireguardServer(t *testing.T) (int, string) {
+	t.Helper()
+	pub := make([]byte, wgKeyLen)
+	rand.Read(pub)
+	mac1Key := blake2s(32, nil, append([]byte(wgLabelMAC1), pub...))
+	pc := listenUDP(t)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			msg := buf[:n]
+			if n != wgInitiationLen || msg[0] != wgInitiation || !bytes.Equal(msg[wgMAC1Offset:wgMAC1Offset+16], blake2s(16, mac1Key, msg[:wgMAC1Offset])) {
+				continue
+			}
+			reply := make([]byte, wgCookieReplyLen)
+			reply[0] = wgCookieReply
+			copy(reply[4:8], msg[4:8])
+			pc.WriteTo(reply, addr)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr).Port, base64.StdEncoding.EncodeToString(pub)
+}
+
+func TestWireGuardMode(t *testing.T) {
+	port, key := wireguardServer(t)
+	other := make([]byte, wgKeyLen)
+	for _, tc := range []struct {
+		name    string
+		key     string
+		wantErr string
+	}{
+		{"cookie reply", key, ""},
+		{"wrong key is dropped", base64.StdEncoding.EncodeToString(other), E_Timeout},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := run(t, port, Params{Mode: ModeWireGuard, WireGuardKey: tc.key, Timeout: 200 * time.Millisecond}).Results[0]
+			if res.Error != tc.wantErr || res.Success != (tc.wantErr == "") {
+				t.Errorf("success %v, error %q, want error %q", res.Success, res.Error, tc.wantErr)
+			}
+		})
+	}
+
+	r := New(Params{Destination: "127.0.0.1", DestinationPort: port, Protocol: "udp", Mode: ModeWireGuard, WireGuardKey: "c2hvcnQ="})
+	if err := r.Run(); !errors.Is(err, ErrInvalidParameters) {
+		t.Errorf("got %v for a short key, want an ErrInvalidParameters error", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+type (
+	// portVerdict is the state the probes of one port found it in
+	portVerdict struct {
+		Port     int    `json:"port"`
+		State    string `json:"state"`
+		Sent     int    `json:"sent"`
+		Received int    `json:"received"`
+	}
+
+	// hostPorts are the ports probed on one host
+	hostPorts struct {
+		Host  string        `json:"host"`
+		Ports []portVerdict `json:"ports"`
+	}
+
+	// portReport is the state of every port of a port list scan
+	portReport struct {
+		Hosts []hostPorts `json:"hosts"`
+	}
+
+	// portTracker gathers the state of each probed port from its results
+	portTracker struct {
+		mu     sync.Mutex
+		hosts  []string // hosts in the order they were first probed
+		ports  map[string]map[int]*portVerdict
+		states map[string]map[int]map[string]int
+	}
+)
+
+// splitPorts splits an address whose port part is a comma separated list of
+// ports and ranges, as in host:53,123,5000-5010, into its host and ports in
+// the order given. A port listed twice is probed once.
+func splitPorts(addr string) (string, []int, error) {
+	host, list, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return "", nil, fmt.Errorf("invalid address %q", addr)
+	}
+	var ports []int
+	seen := map[int]bool{}
+	for _, item := range strings.Split(list, ",") {
+		lo, hi := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			lo, hi = item[:i], item[i+1:]
+		}
+		first, err := strconv.ParseUint(lo, 10, 16)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid port %q in %q", item, addr)
+		}
+		last, err := strconv.ParseUint(hi, 10, 16)
+		if err != nil || last < first {
+			return "", nil, fmt.Errorf("invalid port range %q in %q", item, addr)
+		}
+		for p := int(first); p <= int(last); p++ {
+			if !seen[p] {
+				seen[p] = true
+				ports = append(ports, p)
+			}
+		}
+	}
+	return host, ports, nil
+}
+
+// isPortList reports whether the port part of addr lists several ports
+func isPortList(addr string) bool {
+	_, list, err := net.SplitHostPort(addr)
+	return err == nil && strings.ContainsAny(list, ",-")
+}
+
+// expandPorts replaces every target whose port part is a port list with one
+// target per port. It reports whether any target was expanded.
+func expandPorts(targets []target) ([]target, bool, error) {
+	out := make([]target, 0, len(targets))
+	expanded := false
+	for _, t := range targets {
+		if !isPortList(t.Addr) {
+			out = append(out, t)
+			continue
+		}
+		host, ports, err := splitPorts(t.Addr)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, p := range ports {
+			e := t
+			e.Addr = net.JoinHostPort(host, strconv.Itoa(p))
+			out = append(out, e)
+		}
+		expanded = true
+	}
+	return out, expanded, nil
+}
+
+func newPortTracker() *portTracker {
+	return &portTracker{ports: map[string]map[int]*portVerdict{}, states: map[string]map[int]map[string]int{}}
+}
+
+// Add records a result of the probes of its port
+func (t *portTracker) Add(res udping.Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	host, port := res.Destination, int(res.DestinationPort)
+	if _, ok := t.ports[host]; !ok {
+		t.hosts = append(t.hosts, host)
+		t.ports[host] = map[int]*portVerdict{}
+		t.states[host] = map[int]map[string]int{}
+	}
+	v, ok := t.ports[host][port]
+	if !ok {
+		v = &portVerdict{Port: port}
+		t.ports[host][port] = v
+		t.states[host][port] = map[string]int{}
+	}
+	v.Sent++
+	if res.Success {
+		v.Received++
+	}
+	t.states[host][port][res.State]++
+}
+
+// Report returns the ports of each host in ascending order. A port is open
+// when any probe was answered and closed when any was refused; otherwise it
+// takes the state most of its probes reported.
+func (t *portTracker) Report() portReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rep := portReport{Hosts: []hostPorts{}}
+	for _, host := range t.hosts {
+		hp := hostPorts{Host: host}
+		for port, v := range t.ports[host] {
+			verdict := *v
+			states := t.states[host][port]
+			switch {
+			case states[udping.StateOpen] > 0:
+				verdict.State = udping.StateOpen
+			case states[udping.StateClosed] > 0:
+				verdict.State = udping.StateClosed
+			default:
+				most := 0
+				for state, n := range states {
+					if state != "" && n > most {
+						verdict.State, most = state, n
+					}
+				}
+			}
+			hp.Ports = append(hp.Ports, verdict)
+		}
+		sort.Slice(hp.Ports, func(i, j int) bool { return hp.Ports[i].Port < hp.Ports[j].Port })
+		rep.Hosts = append(rep.Hosts, hp)
+	}
+	return rep
+}
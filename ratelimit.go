@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const (
+	// rateLimitMinSamples is the fewest probes of a target needed to compare its early and late probes
+	rateLimitMinSamples = 10
+	// rateLimitLossRise is the rise in loss, in percentage points, from the early to the late probes that suggests rate limiting
+	rateLimitLossRise = 20
+	// rateLimitRTTRise is the factor by which the late average RTT must exceed the early one to suggest rate limiting
+	rateLimitRTTRise = 2
+	// rateLimitMinRTTRise is the smallest rise of the average RTT, in seconds, considered; it keeps sub-millisecond noise out
+	rateLimitMinRTTRise = 0.001
+)
+
+type (
+	// rateLimitVerdict compares the first and second half of the probes sent to one target
+	rateLimitVerdict struct {
+		Target    string  `json:"target"`
+		Source    string  `json:"source,omitempty"`
+		Samples   int     `json:"samples"`
+		Rate      float64 `json:"rate"`       // Rate is the number of probes completed per second
+		EarlyLoss float64 `json:"early_loss"` // EarlyLoss is the loss percentage of the first half of the probes
+		LateLoss  float64 `json:"late_loss"`  // LateLoss is the loss percentage of the second half of the probes
+		EarlyRTT  float64 `json:"early_rtt"`  // EarlyRTT is the average RTT of the answered probes of the first half, in seconds
+		LateRTT   float64 `json:"late_rtt"`   // LateRTT is the average RTT of the answered probes of the second half, in seconds
+		Suspected bool    `json:"suspected"`
+		Reason    string  `json:"reason,omitempty"`
+	}
+
+	// rateLimitReport flags targets that degrade as probes keep coming. It is
+	// a heuristic: congestion or an outage starting mid-run looks the same.
+	rateLimitReport struct {
+		Heuristic bool               `json:"heuristic"`
+		Suspected bool               `json:"suspected"` // Suspected is true when any target is suspected of rate limiting
+		Targets   []rateLimitVerdict `json:"targets"`
+	}
+
+	// rateSeries is the ordered outcome of the probes of one target
+	rateSeries struct {
+		target      string
+		source      string
+		rtts        []float64 // RTT of each probe in order, negative when it was lost
+		first, last time.Time
+	}
+
+	// rateLimitTracker records the probes of every target in order as results arrive
+	rateLimitTracker struct {
+		mu     sync.Mutex
+		series map[string]*rateSeries
+		order  []string
+	}
+)
+
+func newRateLimitTracker() *rateLimitTracker {
+	return &rateLimitTracker{series: map[string]*rateSeries{}}
+}
+
+// Add records the outcome of a probe
+func (t *rateLimitTracker) Add(res udping.Result) {
+	target := net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+	key := res.Source + "/" + target
+	rtt := -1.0
+	if res.Success && res.Error == "" {
+		rtt = res.RTT
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.series[key]
+	if !ok {
+		s = &rateSeries{target: target, source: res.Source, first: now}
+		t.series[key] = s
+		t.order = append(t.order, key)
+	}
+	s.rtts = append(s.rtts, rtt)
+	s.last = now
+}
+
+// Report judges every target seen so far, in the order they were first probed
+func (t *rateLimitTracker) Report() rateLimitReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rep := rateLimitReport{Heuristic: true}
+	for _, key := range t.order {
+		v := t.series[key].verdict()
+		if v.Suspected {
+			rep.Suspected = true
+		}
+		rep.Targets = append(rep.Targets, v)
+	}
+	return rep
+}
+
+// verdict compares the early and late halves of the series
+func (s *rateSeries) verdict() rateLimitVerdict {
+	v := rateLimitVerdict{Target: s.target, Source: s.source, Samples: len(s.rtts)}
+	if d := s.last.Sub(s.first).Seconds(); d > 0 {
+		v.Rate = float64(len(s.rtts)-1) / d
+	}
+	if len(s.rtts) < rateLimitMinSamples {
+		v.Reason = fmt.Sprintf("too few probes, at least %d are needed", rateLimitMinSamples)
+		return v
+	}
+
+	half := len(s.rtts) / 2
+	v.EarlyLoss, v.EarlyRTT = lossAndRTT(s.rtts[:half])
+	v.LateLoss, v.LateRTT = lossAndRTT(s.rtts[half:])
+	switch {
+	case v.LateLoss-v.EarlyLoss >= rateLimitLossRise:
+		v.Suspected = true
+		v.Reason = fmt.Sprintf("loss rose from %.0f%% to %.0f%% after %d probes", v.EarlyLoss, v.LateLoss, half)
+	case v.EarlyRTT > 0 && v.LateRTT >= rateLimitRTTRise*v.EarlyRTT && v.LateRTT-v.EarlyRTT >= rateLimitMinRTTRise:
+		v.Suspected = true
+		v.Reason = fmt.Sprintf("average rtt rose %.1fx after %d probes", v.LateRTT/v.EarlyRTT, half)
+	}
+	return v
+}
+
+// lossAndRTT returns the loss percentage and the average RTT of the answered probes of rtts
+func lossAndRTT(rtts []float64) (float64, float64) {
+	var lost, answered int
+	var sum float64
+	for _, rtt := range rtts {
+		if rtt < 0 {
+			lost++
+			continue
+		}
+		answered++
+		sum += rtt
+	}
+	loss := 100 * float64(lost) / float64(len(rtts))
+	if answered == 0 {
+		return loss, 0
+	}
+	return loss, sum / float64(answered)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+type (
+	// clientStats summarizes the probes received from one source in reverse mode
+	clientStats struct {
+		Source       string    `json:"source"`
+		Packets      int       `json:"packets"`
+		Bytes        int       `json:"bytes"`
+		First        time.Time `json:"first"`
+		Last         time.Time `json:"last"`
+		MeanInterval float64   `json:"meaninterval,omitempty"` // MeanInterval is the average time between arrivals, in seconds
+		Jitter       float64   `json:"jitter,omitempty"`       // Jitter is the mean difference between consecutive inter-arrival times, in seconds
+
+		prevGap  time.Duration
+		gapSum   time.Duration
+		jitSum   time.Duration
+		jitCount int
+	}
+
+	// arrivalRecorder collects per-source arrival statistics
+	arrivalRecorder struct {
+		mu      sync.Mutex
+		clients map[string]*clientStats
+	}
+)
+
+// add records a datagram of n bytes from src arriving at the given time
+func (a *arrivalRecorder) add(from net.Addr, n int, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// the client opens a new socket per probe, so group by address rather than address and port
+	src := from.String()
+	if ua, ok := from.(*net.UDPAddr); ok {
+		src = ua.IP.String()
+	}
+	c, ok := a.clients[src]
+	if !ok {
+		c = &clientStats{Source: src, First: at}
+		a.clients[src] = c
+	}
+	if c.Packets > 0 {
+		gap := at.Sub(c.Last)
+		c.gapSum += gap
+		if c.Packets > 1 {
+			d := gap - c.prevGap
+			if d < 0 {
+				d = -d
+			}
+			c.jitSum += d
+			c.jitCount++
+		}
+		c.prevGap = gap
+	}
+	c.Packets++
+	c.Bytes += n
+	c.Last = at
+}
+
+// summary returns the statistics of every source seen, ordered by first arrival
+func (a *arrivalRecorder) summary() []clientStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	out := make([]clientStats, 0, len(a.clients))
+	for _, c := range a.clients {
+		s := *c
+		if s.Packets > 1 {
+			s.MeanInterval = (s.gapSum / time.Duration(s.Packets-1)).Seconds()
+		}
+		if s.jitCount > 0 {
+			s.Jitter = math.Round((s.jitSum/time.Duration(s.jitCount)).Seconds()*1e9) / 1e9
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].First.Before(out[j].First) })
+	return out
+}
+
+// serveReverse runs the echo server on addr while measuring the probes it
+// receives. It has no RTT to report, but shows from the destination's side
+// which sources got through and how evenly their probes arrived. The
+// per-client summary is returned once the process is interrupted.
+func serveReverse(addr string) ([]clientStats, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("reverse mode listening on %v, interrupt to print the summary\n", pc.LocalAddr())
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		pc.Close()
+	}()
+
+	rec := &arrivalRecorder{clients: map[string]*clientStats{}}
+	err = udping.EchoLoop(pc, rec.add)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return rec.summary(), err
+}
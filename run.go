@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// readJob reads the parameters of a run from path, standard input for "-".
+// The document is the parameters JSON, or a report -format json printed for
+// a single target, whose parameters are run again. Durations are numbers of
+// seconds or strings such as "250ms", and the protocol defaults to udp, as
+// on the command line.
+func readJob(path string) (udping.Params, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return udping.Params{}, err
+	}
+	var report struct {
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(data, &report); err == nil && len(report.Params) > 0 {
+		data = report.Params
+	}
+	var p udping.Params
+	if err := json.Unmarshal(data, &p); err != nil {
+		return udping.Params{}, fmt.Errorf("%s: %v", path, err)
+	}
+	if p.Protocol == "" {
+		p.Protocol = "udp"
+	}
+	return p, nil
+}
+
+// runCommand runs "udping run", which executes a job of parameters JSON as
+// schedulers exchange them and writes the report of its results to standard
+// output, the one -format json prints for a single target
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	input := fs.String("input", "-", "file with the parameters JSON to run, or a saved report whose parameters are run again; - reads standard input")
+	var pretty optionalBool
+	fs.Var(&pretty, "pretty", "indent json output (default: only when writing to a terminal)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s run [-input <file>] [flags]\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "run the probes a parameters JSON document describes and print the report of their results")
+		fs.PrintDefaults()
+	}
+	arg, code, ok := parseCommand(fs, args)
+	if !ok {
+		return code
+	}
+	if arg != "" {
+		fs.Usage()
+		return exitUsage
+	}
+
+	p, err := readJob(*input)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	r := udping.New(p)
+	if err := r.RunContext(ctx); err != nil {
+		log.Println(err)
+		if errors.Is(err, udping.ErrInvalidParameters) {
+			return exitUsage
+		}
+		return exitUnreachable
+	}
+	summary := r.Summary()
+	rep := newReport(start, r.Parameters)
+	rep.Selection, rep.Results, rep.Summary = r.Selection, r.Results, &summary
+
+	indent := isTerminal(os.Stdout)
+	if pretty.set {
+		indent = pretty.value
+	}
+	fmt.Println(encodeJSON(rep, indent))
+	if summary.Received == 0 {
+		return exitUnreachable
+	}
+	if ctx.Err() != nil && !p.Continuous {
+		return exitInterrupted
+	}
+	return exitOK
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeJob writes a job document to a file of its own and returns its path
+func writeJob(t *testing.T, doc string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job.json")
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadJob(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		doc      string
+		timeout  time.Duration
+		interval time.Duration
+		protocol string
+	}{
+		{"integer seconds", `{"destination":"h","timeout":5,"interval":1}`, 5 * time.Second, time.Second, "udp"},
+		{"fractional seconds", `{"destination":"h","timeout":0.25}`, 250 * time.Millisecond, 0, "udp"},
+		{"duration strings", `{"destination":"h","timeout":"250ms","interval":"2s","protocol":"tcp"}`, 250 * time.Millisecond, 2 * time.Second, "tcp"},
+		{"saved report", `{"schema_version":3,"params":{"destination":"h","timeout":2,"protocol":"icmp"},"results":[]}`, 2 * time.Second, 0, "icmp"},
+	} {
+		p, err := readJob(writeJob(t, tc.doc))
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if p.Destination != "h" || p.Timeout != tc.timeout || p.Interval != tc.interval || p.Protocol != tc.protocol {
+			t.Errorf("%s: destination %q, timeout %v, interval %v, protocol %q, want h, %v, %v, %q",
+				tc.name, p.Destination, p.Timeout, p.Interval, p.Protocol, tc.timeout, tc.interval, tc.protocol)
+		}
+	}
+	for _, doc := range []string{`{"destination":`, `{"timeout":"soon"}`, `[1]`} {
+		if _, err := readJob(writeJob(t, doc)); err == nil {
+			t.Errorf("%s: read", doc)
+		}
+	}
+	if _, err := readJob(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("a missing job file was read")
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	port := strconv.Itoa(echoServer(t))
+
+	// a timeout of 5 is 5s: read as nanoseconds, every probe would time out at once
+	job := writeJob(t, `{"destination":"127.0.0.1","destinationport":`+port+`,"protocol":"udp","count":2,"timeout":5,"interval":0.01}`)
+	out := filepath.Join(t.TempDir(), "out.json")
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = f
+	code := runCommand([]string{"-input", job})
+	os.Stdout = stdout
+	f.Close()
+	if code != exitOK {
+		t.Fatalf("exit code %d, want %d", code, exitOK)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep runReport
+	if err := json.Unmarshal(b, &rep); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	if rep.SchemaVersion != reportSchema || rep.Params.Timeout != 5*time.Second || rep.Summary == nil || rep.Summary.Received != 2 {
+		t.Errorf("report %s", b)
+	}
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, doc := range []string{`{"destination":"127.0.0.1","protocol":"udp","count":-1}`, `not json`} {
+		if code := runCommand([]string{"-input", writeJob(t, doc)}); code != exitUsage {
+			t.Errorf("%s: exit code %d, want %d", doc, code, exitUsage)
+		}
+	}
+	if code := runCommand([]string{"-input", job, "extra"}); code != exitUsage {
+		t.Errorf("a positional argument: exit code %d, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// limits of a single API probe request, so that one caller cannot tie the
+// server up for long. apiMaxDuration bounds the longest the probes of a
+// request may take, every one of them timing out, intervals included.
+const (
+	apiMaxCount    = 100
+	apiMaxTimeout  = 30 * time.Second
+	apiMaxDuration = 5 * time.Minute
+)
+
+type (
+	// probeRequest is the body of POST /probe. Durations are strings such as
+	// "500ms" or numbers of seconds; fields left out take the library defaults.
+	probeRequest struct {
+		Destination string          `json:"destination"`
+		Port        int             `json:"port"`
+		Protocol    string          `json:"protocol"`
+		Mode        string          `json:"mode"`
+		Count       int             `json:"count"`
+		Interval    secondsDuration `json:"interval"`
+		Timeout     secondsDuration `json:"timeout"`
+	}
+
+	// apiError is the body of a failed request
+	apiError struct {
+		Error string `json:"error"`
+	}
+)
+
+// serveAPI answers probe requests on addr until the listener fails:
+//
+//	curl -d '{"destination":"192.0.2.53","port":53,"mode":"dns","count":3}' localhost:8080/probe
+//
+// The response is the report -format json prints for a single target.
+func serveAPI(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("serving probes on http://%v/probe\n", ln.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/probe", handleProbe)
+	return http.Serve(ln, mux)
+}
+
+// handleProbe runs the probes of a request and writes their report
+func handleProbe(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeAPI(w, http.StatusMethodNotAllowed, apiError{Error: "use POST"})
+		return
+	}
+	var pr probeRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&pr); err != nil {
+		writeAPI(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	r, err := newAPIRunner(pr)
+	if err != nil {
+		writeAPI(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	start := time.Now()
+	// a caller that hangs up cancels its probes
+	err = r.RunContext(req.Context())
+	if errors.Is(err, udping.ErrInvalidParameters) {
+		writeAPI(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeAPI(w, http.StatusInternalServerError, apiError{Error: err.Error()})
+		return
+	}
+	summary := r.Summary()
+	writeAPI(w, http.StatusOK, runReport{SchemaVersion: reportSchema, StartedAt: start, EndedAt: time.Now(), Params: r.Parameters, Results: r.Results, Summary: &summary})
+}
+
+// newAPIRunner checks a probe request is within the limits of the API and
+// returns the runner of its probes
+func newAPIRunner(pr probeRequest) (*udping.Runner, error) {
+	if pr.Count > apiMaxCount {
+		return nil, fmt.Errorf("count is limited to %d, got %d", apiMaxCount, pr.Count)
+	}
+	if time.Duration(pr.Timeout) > apiMaxTimeout {
+		return nil, fmt.Errorf("timeout is limited to %v, got %v", apiMaxTimeout, time.Duration(pr.Timeout))
+	}
+	// the library defaults of count and timeout, no pause between probes
+	count, timeout := pr.Count, time.Duration(pr.Timeout)
+	if count <= 0 {
+		count = 3
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	if time.Duration(pr.Interval) > apiMaxDuration {
+		return nil, fmt.Errorf("interval is limited to %v, got %v", apiMaxDuration, time.Duration(pr.Interval))
+	}
+	if d := time.Duration(count)*timeout + time.Duration(count-1)*time.Duration(pr.Interval); d > apiMaxDuration {
+		return nil, fmt.Errorf("probes are limited to %v in all, %d probes %v apart with a timeout of %v could take %v",
+			apiMaxDuration, count, time.Duration(pr.Interval), timeout, d)
+	}
+	if pr.Protocol == "" {
+		pr.Protocol = "udp"
+	}
+	return udping.New(udping.Params{
+		Destination:     pr.Destination,
+		DestinationPort: pr.Port,
+		Protocol:        pr.Protocol,
+		Mode:            pr.Mode,
+		Count:           pr.Count,
+		Interval:        time.Duration(pr.Interval),
+		Timeout:         time.Duration(pr.Timeout),
+	}), nil
+}
+
+// writeAPI writes v as the JSON body of a response with the given status
+func writeAPI(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
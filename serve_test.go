@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleProbe(t *testing.T) {
+	port := echoServer(t)
+	req := httptest.NewRequest(http.MethodPost, "/probe",
+		strings.NewReader(fmt.Sprintf(`{"destination":"127.0.0.1","port":%d,"count":2,"interval":0.01,"timeout":"1s"}`, port)))
+	w := httptest.NewRecorder()
+	handleProbe(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var rep runReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Results) != 2 || rep.Summary == nil || rep.Summary.Received != 2 || rep.Params.Timeout != time.Second {
+		t.Errorf("report %s", w.Body)
+	}
+}
+
+func TestHandleProbeRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		body   string
+		status int
+		error  string
+	}{
+		{"get", http.MethodGet, "", http.StatusMethodNotAllowed, "POST"},
+		{"malformed", http.MethodPost, `{"destination":`, http.StatusBadRequest, "EOF"},
+		{"unknown field", http.MethodPost, `{"destination":"127.0.0.1","port":9,"ttl":3}`, http.StatusBadRequest, "unknown field"},
+		{"count", http.MethodPost, `{"destination":"127.0.0.1","port":9,"count":101}`, http.StatusBadRequest, "count is limited"},
+		{"timeout", http.MethodPost, `{"destination":"127.0.0.1","port":9,"timeout":31}`, http.StatusBadRequest, "timeout is limited"},
+		{"interval", http.MethodPost, `{"destination":"127.0.0.1","port":9,"count":1,"interval":"1h"}`, http.StatusBadRequest, "interval is limited"},
+		{"total duration", http.MethodPost, `{"destination":"127.0.0.1","port":9,"count":100,"interval":10}`, http.StatusBadRequest, "in all"},
+		{"invalid parameters", http.MethodPost, `{"destination":"127.0.0.1","port":70000,"count":1}`, http.StatusBadRequest, "destination port"},
+	} {
+		w := httptest.NewRecorder()
+		handleProbe(w, httptest.NewRequest(tc.method, "/probe", strings.NewReader(tc.body)))
+		var body apiError
+		json.Unmarshal(w.Body.Bytes(), &body)
+		if w.Code != tc.status || !strings.Contains(body.Error, tc.error) {
+			t.Errorf("%s: status %d, error %q, want %d and %q", tc.name, w.Code, body.Error, tc.status, tc.error)
+		}
+	}
+}
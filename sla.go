@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// slaWorst is how many of the slowest probes are listed in an SLA report
+const slaWorst = 5
+
+type (
+	// slaReport states whether a latency objective such as "95% of probes under 50ms" was met
+	slaReport struct {
+		Threshold     float64         `json:"threshold"`      // Threshold is the RTT objective in seconds
+		TargetPercent float64         `json:"target_percent"` // TargetPercent is the share of probes required under Threshold
+		Percent       float64         `json:"percent"`        // Percent is the measured share of successful probes under Threshold
+		Samples       int             `json:"samples"`        // Samples is the number of successful probes considered
+		Met           bool            `json:"met"`            // Met is true when Percent reaches TargetPercent
+		Worst         []udping.Result `json:"worst,omitempty"`
+	}
+
+	// slaTracker accumulates successful RTTs as results arrive, so the report
+	// also works when results are streamed rather than kept
+	slaTracker struct {
+		threshold time.Duration
+		percent   float64
+		samples   int
+		under     int
+		worst     []udping.Result
+	}
+)
+
+func newSLATracker(threshold time.Duration, percent float64) *slaTracker {
+	return &slaTracker{threshold: threshold, percent: percent}
+}
+
+// Add records a result. Failed probes have no meaningful RTT and are ignored.
+func (t *slaTracker) Add(res udping.Result) {
+	if !res.Success || res.Error != "" {
+		return
+	}
+	t.samples++
+	if res.RTT < t.threshold.Seconds() {
+		t.under++
+	}
+
+	// keep the slowest slaWorst results, slowest first
+	t.worst = append(t.worst, res)
+	sort.SliceStable(t.worst, func(i, j int) bool { return t.worst[i].RTT > t.worst[j].RTT })
+	if len(t.worst) > slaWorst {
+		t.worst = t.worst[:slaWorst]
+	}
+}
+
+// Report returns the verdict over the results added so far. With no successful probes the SLA is not met.
+func (t *slaTracker) Report() slaReport {
+	rep := slaReport{
+		Threshold:     t.threshold.Seconds(),
+		TargetPercent: t.percent,
+		Samples:       t.samples,
+		Worst:         t.worst,
+	}
+	if t.samples > 0 {
+		rep.Percent = float64(t.under) / float64(t.samples) * 100
+		rep.Met = rep.Percent >= t.percent
+	}
+	return rep
+}
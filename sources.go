@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// parseSources splits a comma separated list of source addresses, ignoring empty entries
+func parseSources(list string) ([]string, error) {
+	var sources []string
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if net.ParseIP(s) == nil {
+			return nil, fmt.Errorf("invalid source address %q", s)
+		}
+		sources = append(sources, s)
+	}
+	return sources, nil
+}
+
+// expandSources replaces every target with one target per source address, in source order
+func expandSources(targets []target, sources []string) []target {
+	out := make([]target, 0, len(targets)*len(sources))
+	for _, t := range targets {
+		for _, src := range sources {
+			e := t
+			e.Source = src
+			out = append(out, e)
+		}
+	}
+	return out
+}
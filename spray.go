@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const (
+	// sprayLossRise is how many percentage points more loss than the best port of its target marks a port as suspect
+	sprayLossRise = 20
+	// sprayRTTRise is the factor by which a port's average RTT must exceed the best port's to mark it as suspect
+	sprayRTTRise = 2
+	// sprayMinRTTRise is the smallest rise of the average RTT, in seconds, considered; it keeps sub-millisecond noise out
+	sprayMinRTTRise = 0.001
+)
+
+type (
+	// sprayPort is how the probes sent from one source port fared
+	sprayPort struct {
+		Port    int          `json:"port"`
+		Summary udping.Stats `json:"summary"`
+		// Suspect is set when the port fared clearly worse than the best port
+		// of its target, as a single bad path among equal cost ones would
+		Suspect bool   `json:"suspect,omitempty"`
+		Reason  string `json:"reason,omitempty"`
+	}
+
+	// sprayTarget breaks the probes of one target down by source port
+	sprayTarget struct {
+		Target string      `json:"target"`
+		Source string      `json:"source,omitempty"`
+		Ports  []sprayPort `json:"ports"`
+	}
+
+	// sprayReport is the -spray breakdown of every target
+	sprayReport struct {
+		Suspected bool          `json:"suspected"` // Suspected is true when any port of any target is suspect
+		Targets   []sprayTarget `json:"targets"`
+	}
+
+	// sprayTracker keeps the outcome of every probe by target and source port as results arrive
+	sprayTracker struct {
+		mu      sync.Mutex
+		targets map[string]*sprayTarget
+		results map[string]map[int][]udping.Result
+		order   []string
+	}
+)
+
+func newSprayTracker() *sprayTracker {
+	return &sprayTracker{targets: map[string]*sprayTarget{}, results: map[string]map[int][]udping.Result{}}
+}
+
+// Add records the outcome of a probe sent from a known source port
+func (t *sprayTracker) Add(res udping.Result) {
+	if res.SourcePort == 0 {
+		return
+	}
+	target := net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+	key := res.Source + "/" + target
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.targets[key]; !ok {
+		t.targets[key] = &sprayTarget{Target: target, Source: res.Source}
+		t.results[key] = map[int][]udping.Result{}
+		t.order = append(t.order, key)
+	}
+	// only what the summary is computed from
+	t.results[key][res.SourcePort] = append(t.results[key][res.SourcePort], udping.Result{Success: res.Success, RTT: res.RTT, Class: res.Class})
+}
+
+// Report summarizes every port of every target seen so far, flagging the
+// ports that fared clearly worse than the best one of their target
+func (t *sprayTracker) Report() sprayReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rep := sprayReport{Targets: []sprayTarget{}}
+	for _, key := range t.order {
+		st := *t.targets[key]
+		bestLoss, bestRTT := 100.0, 0.0
+		for port, results := range t.results[key] {
+			s := udping.Summarize(results)
+			st.Ports = append(st.Ports, sprayPort{Port: port, Summary: s})
+			if s.Loss < bestLoss {
+				bestLoss = s.Loss
+			}
+			if s.Received > 0 && (bestRTT == 0 || s.AvgRTT < bestRTT) {
+				bestRTT = s.AvgRTT
+			}
+		}
+		sort.Slice(st.Ports, func(i, j int) bool { return st.Ports[i].Port < st.Ports[j].Port })
+		for i := range st.Ports {
+			p := &st.Ports[i]
+			switch {
+			case p.Summary.Loss-bestLoss >= sprayLossRise:
+				p.Suspect = true
+				p.Reason = fmt.Sprintf("%.0f%% loss against %.0f%% from the best port", p.Summary.Loss, bestLoss)
+			case bestRTT > 0 && p.Summary.AvgRTT >= sprayRTTRise*bestRTT && p.Summary.AvgRTT-bestRTT >= sprayMinRTTRise:
+				p.Suspect = true
+				p.Reason = fmt.Sprintf("average rtt %.1fx that of the best port", p.Summary.AvgRTT/bestRTT)
+			}
+			if p.Suspect {
+				rep.Suspected = true
+			}
+		}
+		rep.Targets = append(rep.Targets, st)
+	}
+	return rep
+}
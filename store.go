@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// A -store file accumulates the results of every run, daemon or cron, one
+// JSON object per line: the result with the time it was stored. Runs only
+// ever append to it, so several can share it. "udping report" summarizes it:
+//
+//	udping -store results.ndjson -c 10 192.0.2.53:53     (every 5 minutes from cron)
+//	udping report -store results.ndjson -since 24h
+
+// storeScanBuffer is the longest line read back from a store, room for results with reply dumps
+const storeScanBuffer = 1 << 20
+
+type (
+	// storedResult is a line of a -store file
+	storedResult struct {
+		At time.Time `json:"at"`
+		udping.Result
+	}
+
+	// storedTarget summarizes the stored results of one target
+	storedTarget struct {
+		Target   string       `json:"target"`
+		Protocol string       `json:"protocol"`
+		First    time.Time    `json:"first"` // First is when the earliest result in range was stored
+		Last     time.Time    `json:"last"`
+		Summary  udping.Stats `json:"summary"`
+	}
+
+	// storeReport is the output of the report command
+	storeReport struct {
+		From    time.Time      `json:"from"`
+		To      time.Time      `json:"to"`
+		Results int            `json:"results"` // Results is the number of stored results in range
+		Targets []storedTarget `json:"targets"`
+	}
+)
+
+// storeWriter returns a results hook appending every result to w
+func storeWriter(w io.Writer) func(res udping.Result) {
+	enc := json.NewEncoder(w)
+	return func(res udping.Result) {
+		enc.Encode(storedResult{At: time.Now(), Result: res})
+	}
+}
+
+// readStore summarizes the results of a store stored from from up to to,
+// per target in the order they first appear. Malformed lines are reported
+// with their line number and skipped.
+func readStore(path string, from, to time.Time) (storeReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return storeReport{}, err
+	}
+	defer f.Close()
+
+	rep := storeReport{From: from, To: to, Targets: []storedTarget{}}
+	index := make(map[string]int)
+	var results [][]udping.Result
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), storeScanBuffer)
+	for line := 1; sc.Scan(); line++ {
+		var sr storedResult
+		if err := json.Unmarshal(sc.Bytes(), &sr); err != nil {
+			log.Printf("%s:%d: %v, skipping\n", path, line, err)
+			continue
+		}
+		if sr.At.Before(from) || sr.At.After(to) {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", sr.Destination, int(sr.DestinationPort))
+		if sr.Protocol == "icmp" {
+			key = sr.Destination
+		}
+		id := key + "/" + sr.Protocol
+		i, ok := index[id]
+		if !ok {
+			i = len(rep.Targets)
+			index[id] = i
+			rep.Targets = append(rep.Targets, storedTarget{Target: key, Protocol: sr.Protocol, First: sr.At})
+			results = append(results, nil)
+		}
+		rep.Targets[i].Last = sr.At
+		results[i] = append(results[i], sr.Result)
+		rep.Results++
+	}
+	if err := sc.Err(); err != nil {
+		return storeReport{}, fmt.Errorf("%s: %v", path, err)
+	}
+	for i := range rep.Targets {
+		rep.Targets[i].Summary = udping.Summarize(results[i])
+	}
+	return rep, nil
+}
+
+// reportCommand runs "udping report", which summarizes the results kept in
+// a -store file over a time range
+func reportCommand(args []string) int {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	path := fs.String("store", "", "file the results were stored in with -store")
+	since := fs.Duration("since", 24*time.Hour, "summarize the results stored within this long before -to")
+	fromFlag := fs.String("from", "", "summarize the results stored from this RFC 3339 time on, instead of -since")
+	toFlag := fs.String("to", "", "summarize the results stored up to this RFC 3339 time (default now)")
+	var pretty optionalBool
+	fs.Var(&pretty, "pretty", "indent json output (default: only when writing to a terminal)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s report -store <file> [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	arg, code, ok := parseCommand(fs, args)
+	if !ok {
+		return code
+	}
+	if *path == "" || arg != "" {
+		fs.Usage()
+		return exitUsage
+	}
+
+	to := time.Now()
+	if *toFlag != "" {
+		t, err := time.Parse(time.RFC3339, *toFlag)
+		if err != nil {
+			log.Printf("invalid -to: %v\n", err)
+			return exitUsage
+		}
+		to = t
+	}
+	from := to.Add(-*since)
+	if *fromFlag != "" {
+		t, err := time.Parse(time.RFC3339, *fromFlag)
+		if err != nil {
+			log.Printf("invalid -from: %v\n", err)
+			return exitUsage
+		}
+		from = t
+	}
+	if from.After(to) {
+		log.Printf("the range must not end before it starts, got %v to %v\n", from, to)
+		return exitUsage
+	}
+
+	rep, err := readStore(*path, from, to)
+	if err != nil {
+		log.Println(err)
+		return exitUsage
+	}
+	indent := isTerminal(os.Stdout)
+	if pretty.set {
+		indent = pretty.value
+	}
+	fmt.Println(encodeJSON(rep, indent))
+	return exitOK
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const formatTable = "table" // the csv columns aligned for reading, printed once the run is over
+
+// tableWriter returns an OnResult hook that collects each result as a row of
+// an aligned table, and a function writing the table to w. Columns can only be
+// aligned once every row is known, so nothing is written before flush.
+func tableWriter(w io.Writer) (func(res udping.Result), func()) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	io.WriteString(tw, strings.Join(csvColumns, "\t")+"\n")
+	probes := map[string]int{}
+	hook := func(res udping.Result) {
+		key := probeKey(res)
+		row := csvRow(probes[key], res)
+		probes[key]++
+		if row[5] == "" {
+			// keep the empty rtt of a failed probe visible
+			row[5] = "-"
+		}
+		io.WriteString(tw, strings.Join(row, "\t")+"\n")
+	}
+	return hook, func() { tw.Flush() }
+}
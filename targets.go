@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+type (
+	// target is a destination to probe as given on the command line or in a targets file
+	target struct {
+		Name     string        // Name labels the target in reports, from a config file
+		Addr     string        // host:port
+		Count    int           // Count overrides -c for this target when positive
+		Interval time.Duration // Interval overrides -i for this target when positive
+		Timeout  time.Duration // Timeout overrides -t for this target when positive
+		Deadline time.Duration // Deadline overrides -target-deadline for this target when positive
+		Mode     string        // Mode overrides -mode for this target when set
+		IP       string        // IP pins the target to one of the addresses its host resolves to
+		Source   string        // Source is the local address to probe from
+		Weight   float64       // Weight is how much the target counts in the weighted loss; 0 means 1
+		Group    string        // Group is the label the target is aggregated under in the group report
+	}
+
+	// targetResult holds the results of one target in a multi-target run
+	targetResult struct {
+		Name      string            `json:"name,omitempty"`
+		Group     string            `json:"group,omitempty"`
+		Target    string            `json:"target"`
+		IP        string            `json:"ip,omitempty"`
+		Source    string            `json:"source,omitempty"`
+		Error     string            `json:"error,omitempty"`
+		Selection *udping.Selection `json:"selection,omitempty"`
+		Results   []udping.Result   `json:"results"`
+		Summary   udping.Stats      `json:"summary"`
+
+		params      udping.Params // parameters of the run, as validated once it started
+		sent        int           // probes completed, counted even when results are not kept
+		succeeded   int
+		invalid     bool      // the run was rejected by parameter validation
+		firstAnswer time.Time // when the first successful probe got its answer
+		rtts        []float64 // with -compare, the RTT of every probe in seconds, -1 for failed ones
+		weight      float64
+	}
+)
+
+// readTargets reads a targets file with one "host:port [count] [weight=w] [group=g]"
+// entry per line, the port possibly a port list. Blank lines and lines starting with # are ignored.
+// Malformed lines are reported with their line number and skipped.
+func readTargets(path string) ([]target, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var targets []target
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		t, err := parseTargetLine(text)
+		if err != nil {
+			log.Printf("%s:%d: %v, skipping\n", path, line, err)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	return targets, sc.Err()
+}
+
+// parseTargetLine parses a single targets file entry
+func parseTargetLine(text string) (target, error) {
+	fields := strings.Fields(text)
+	if len(fields) > 4 {
+		return target{}, fmt.Errorf("expected \"host:port [count] [weight=w] [group=g]\", got %q", text)
+	}
+	t := target{Addr: fields[0]}
+	if _, _, err := splitPorts(t.Addr); err != nil {
+		return target{}, err
+	}
+	for _, field := range fields[1:] {
+		if w := strings.TrimPrefix(field, "weight="); w != field {
+			weight, err := strconv.ParseFloat(w, 64)
+			if err != nil || weight <= 0 || t.Weight != 0 {
+				return target{}, fmt.Errorf("invalid weight %q", w)
+			}
+			t.Weight = weight
+			continue
+		}
+		if g := strings.TrimPrefix(field, "group="); g != field {
+			if g == "" || t.Group != "" {
+				return target{}, fmt.Errorf("invalid group %q", g)
+			}
+			t.Group = g
+			continue
+		}
+		count, err := strconv.Atoi(field)
+		if err != nil || count < 1 || t.Count != 0 {
+			return target{}, fmt.Errorf("invalid count %q", field)
+		}
+		t.Count = count
+	}
+	return t, nil
+}
+
+// batchTargets splits n targets into consecutive batches of size, the last one possibly shorter
+func batchTargets(n, size int) [][]int {
+	var batches [][]int
+	for i := 0; i < n; i += size {
+		var batch []int
+		for j := i; j < i+size && j < n; j++ {
+			batch = append(batch, j)
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// splitHostPort splits a host:port address into its host and numeric port.
+// IPv6 literals must be bracketed, as in [2001:db8::1]:53, and are returned without brackets.
+func splitHostPort(ipport string) (string, int, error) {
+	ip, portStr, err := net.SplitHostPort(ipport)
+	if err != nil || ip == "" {
+		return "", 0, fmt.Errorf("invalid address %q", ipport)
+	}
+
+	// ports are 16 bits, so a targets file line with a bad port is caught with its line number
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %q: %v", ipport, err)
+	}
+	return ip, int(port), nil
+}
+
+// expandAddrs replaces every target whose host is a name with one target per
+// address the name resolves to, leaving out the addresses of a family other
+// than v4 or v6 when either is set. Literal IPs, names that fail to resolve
+// and names without an address of the family are kept as they are.
+func expandAddrs(targets []target, v4, v6 bool, resolver *net.Resolver) []target {
+	out := make([]target, 0, len(targets))
+	for _, t := range targets {
+		host, _, err := splitHostPort(t.Addr)
+		if err != nil || net.ParseIP(host) != nil {
+			out = append(out, t)
+			continue
+		}
+		ips, err := resolver.LookupHost(context.Background(), host)
+		if err != nil || len(ips) == 0 {
+			out = append(out, t)
+			continue
+		}
+		expanded := 0
+		for _, ip := range ips {
+			if ip6 := net.ParseIP(ip).To4() == nil; (v4 && ip6) || (v6 && !ip6) {
+				continue
+			}
+			e := t
+			e.IP = ip
+			out = append(out, e)
+			expanded++
+		}
+		if expanded == 0 {
+			// let the run report that no address of the family is left
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+type (
+	// targetStats is the summary of one target in a multi-target run
+	targetStats struct {
+		Name    string       `json:"name,omitempty"`
+		Group   string       `json:"group,omitempty"`
+		Target  string       `json:"target"`
+		IP      string       `json:"ip,omitempty"`
+		Source  string       `json:"source,omitempty"`
+		Summary udping.Stats `json:"summary"`
+	}
+
+	// addrVerdict is whether one address of a target passed its probes
+	addrVerdict struct {
+		Target    string `json:"target"`
+		IP        string `json:"ip,omitempty"`
+		Source    string `json:"source,omitempty"`
+		Sent      int    `json:"sent"`
+		Succeeded int    `json:"succeeded"`
+		Up        bool   `json:"up"` // Up is true when at least one probe succeeded
+	}
+
+	// addrReport is the -require-all-ips verdict: every address must be up
+	addrReport struct {
+		AllUp     bool          `json:"all_up"`
+		Addresses []addrVerdict `json:"addresses"`
+	}
+)
+
+// newAddrReport judges every run. Runs that could not start count as down.
+func newAddrReport(runs []targetResult) addrReport {
+	rep := addrReport{AllUp: len(runs) > 0}
+	for _, tr := range runs {
+		v := addrVerdict{Target: tr.Target, IP: tr.IP, Source: tr.Source, Sent: tr.sent, Succeeded: tr.succeeded}
+		v.Up = v.Succeeded > 0
+		if !v.Up {
+			rep.AllUp = false
+		}
+		rep.Addresses = append(rep.Addresses, v)
+	}
+	return rep
+}
+
+// dedupeTargets drops targets that resolve to the same address, port and
+// protocol as an earlier one, keeping the first occurrence. It returns the
+// remaining targets and how many were removed. Targets that fail to resolve
+// are compared by host name.
+func dedupeTargets(targets []target, protocol string, resolver *net.Resolver) ([]target, int) {
+	seen := map[string]bool{}
+	out := make([]target, 0, len(targets))
+	for _, t := range targets {
+		host, port, err := splitHostPort(t.Addr)
+		if err != nil {
+			// let the run report it
+			out = append(out, t)
+			continue
+		}
+		addr := t.IP
+		if addr == "" {
+			addr = host
+			if ips, err := resolver.LookupHost(context.Background(), host); err == nil && len(ips) > 0 {
+				addr = ips[0]
+			}
+		}
+		key := protocol + "/" + net.JoinHostPort(addr, strconv.Itoa(port))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, t)
+	}
+	return out, len(targets) - len(out)
+}
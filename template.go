@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// templateFuncs are the functions result templates may call besides the builtins
+var templateFuncs = template.FuncMap{
+	// ms formats a duration in seconds, as RTT is, in milliseconds to the microsecond
+	"ms": func(secs float64) string { return fmt.Sprintf("%.3f", secs*1000) },
+	// time formats a timestamp, such as SentAt, in RFC 3339 to the nanosecond
+	"time": func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format(time.RFC3339Nano)
+	},
+}
+
+// isTemplate reports whether the -format f is a text/template over the
+// fields of udping.Result rather than the name of a format
+func isTemplate(f string) bool {
+	return strings.Contains(f, "{{")
+}
+
+// parseResultTemplate parses a result template, trying it on an empty result
+// so that a misspelled field is reported before any probe is sent
+func parseResultTemplate(f string) (*template.Template, error) {
+	t, err := template.New("format").Funcs(templateFuncs).Parse(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, udping.Result{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// templateWriter returns an OnResult hook that writes each result to w as
+// the template f renders it, with a newline unless it ends with one:
+//
+//	udping -format '{{.Destination}} {{.RTTMs}}ms {{.State}}' 192.0.2.1:53
+//	udping -format '{{if .Success}}{{ms .RTT}}{{else}}{{.Error}}{{end}}' 192.0.2.1:53
+func templateWriter(w io.Writer, f string) (func(res udping.Result), error) {
+	t, err := parseResultTemplate(f)
+	if err != nil {
+		return nil, err
+	}
+	newline := !strings.HasSuffix(f, "\n")
+	return func(res udping.Result) {
+		if err := t.Execute(w, res); err != nil {
+			fmt.Fprintln(w, err)
+			return
+		}
+		if newline {
+			io.WriteString(w, "\n")
+		}
+	}, nil
+}
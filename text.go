@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+const formatText = "text" // one line per probe as it completes and ping's closing statistics, for people
+
+// textWriter returns an OnResult hook that writes each result to w as a line
+// in the spirit of ping's. Probes are numbered per destination, port,
+// address and source, as in the csv format.
+func textWriter(w io.Writer) func(res udping.Result) {
+	probes := map[string]int{}
+	return func(res udping.Result) {
+		addr := textAddr(res)
+		if res.IP != "" && res.IP != res.Destination {
+			// the address behind the name, as ping shows it
+			addr += " (" + res.IP + ")"
+		}
+		key := probeKey(res)
+		i := probes[key]
+		probes[key]++
+		switch {
+		case res.Success && res.MappedAddress != "":
+			fmt.Fprintf(w, "%d bytes from %s: probe=%d mapped=%s time=%.3f ms\n", res.BytesReceived, addr, i, res.MappedAddress, res.RTT*1000)
+		case res.Success && res.ServiceError != "":
+			fmt.Fprintf(w, "%d bytes from %s: probe=%d time=%.3f ms, %s\n", res.BytesReceived, addr, i, res.RTT*1000, res.ServiceError)
+		case res.Success && res.SIPStatus != 0:
+			fmt.Fprintf(w, "%d bytes from %s: probe=%d sip=%d time=%.3f ms\n", res.BytesReceived, addr, i, res.SIPStatus, res.RTT*1000)
+		case res.Success && res.Error == "" && res.ReplyTTL != 0:
+			fmt.Fprintf(w, "%d bytes from %s: probe=%d ttl=%d time=%.3f ms\n", res.BytesReceived, addr, i, res.ReplyTTL, res.RTT*1000)
+		case res.Success && res.Error == "":
+			fmt.Fprintf(w, "%d bytes from %s: probe=%d time=%.3f ms\n", res.BytesReceived, addr, i, res.RTT*1000)
+		case res.Success && res.Outcome == udping.OutcomeOpenFiltered:
+			// a timeout that counts as open with -success not-closed
+			fmt.Fprintf(w, "from %s: probe=%d %s, %s\n", addr, i, res.Error, res.Outcome)
+		case res.Success:
+			// a refusal that still counts as reachable
+			fmt.Fprintf(w, "from %s: probe=%d %s time=%.3f ms\n", addr, i, res.Error, res.RTT*1000)
+		default:
+			fmt.Fprintf(w, "from %s: probe=%d %s\n", addr, i, res.Error)
+		}
+		if res.AnswersChanged {
+			fmt.Fprintf(w, "    %s now resolves to %s\n", res.Destination, strings.Join(res.Answers, ", "))
+		}
+		if res.Reply != "" {
+			// the leading bytes of -reply-dump
+			fmt.Fprintf(w, "    reply: %s\n", res.Reply)
+		}
+	}
+}
+
+// textAddr returns the destination of a result as host:port, or the host alone for icmp
+func textAddr(res udping.Result) string {
+	if res.Protocol == "icmp" {
+		return res.Destination
+	}
+	return net.JoinHostPort(res.Destination, strconv.Itoa(int(res.DestinationPort)))
+}
+
+// writeTextStats writes ping's closing statistics block for one target
+func writeTextStats(w io.Writer, ts targetStats) {
+	name := ts.Target
+	if ts.IP != "" {
+		name += " (" + ts.IP + ")"
+	}
+	if ts.Source != "" {
+		name += " from " + ts.Source
+	}
+	s := ts.Summary
+	fmt.Fprintf(w, "--- %s udping statistics ---\n", name)
+	fmt.Fprintf(w, "%d probes transmitted, %d received, %.1f%% loss\n", s.Sent, s.Received, s.Loss)
+	if s.Received > 0 {
+		fmt.Fprintf(w, "rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n", s.MinRTT*1000, s.AvgRTT*1000, s.MaxRTT*1000, s.StdDev*1000)
+		fmt.Fprintf(w, "rtt p50/p90/p99 = %.3f/%.3f/%.3f ms, jitter = %.3f ms\n", s.P50*1000, s.P90*1000, s.P99*1000, s.Jitter*1000)
+	}
+}
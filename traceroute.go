@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nguyendhst/udping/pkg/udping"
+)
+
+// defaultMaxHops is the TTL a traceroute gives up at, as in traceroute(8)
+const defaultMaxHops = 30
+
+type (
+	// traceHop is what the probes sent with one TTL found
+	traceHop struct {
+		TTL     int       `json:"ttl"`
+		Addr    string    `json:"addr,omitempty"` // Addr is the router that dropped the probes, or the destination
+		RTTs    []float64 `json:"rtts"`           // RTTs are the round trip times of the answered probes, in seconds
+		Lost    int       `json:"lost"`           // Lost is the number of probes nothing answered
+		Reached bool      `json:"reached"`        // Reached is true when the destination itself answered
+	}
+
+	// traceReport is the route to one target, hop by hop
+	traceReport struct {
+		Target  string     `json:"target"`
+		Hops    []traceHop `json:"hops"`
+		Reached bool       `json:"reached"`
+		Error   string     `json:"error,omitempty"`
+	}
+)
+
+// traceroute sends the probes of params with TTLs from 1 up to maxHops until
+// the destination answers, collecting the routers that report the probes
+// expired on the way. Each hop is written to w as it completes, in the style
+// of traceroute(8).
+func traceroute(ctx context.Context, params udping.Params, maxHops int, w io.Writer) traceReport {
+	rep := traceReport{Target: params.Destination}
+	for ttl := 1; ttl <= maxHops && !rep.Reached && ctx.Err() == nil; ttl++ {
+		p := params
+		p.TTL = ttl
+		r := udping.New(p)
+		if err := r.RunContext(ctx); err != nil {
+			rep.Error = err.Error()
+			return rep
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		hop := traceHop{TTL: ttl, RTTs: []float64{}}
+		for _, res := range r.Results {
+			switch {
+			case strings.HasPrefix(res.Error, udping.E_TTLExceeded+" at "):
+				hop.Addr = strings.TrimPrefix(res.Error, udping.E_TTLExceeded+" at ")
+				hop.RTTs = append(hop.RTTs, res.RTT)
+			case res.State == udping.StateOpen || res.State == udping.StateClosed:
+				hop.Addr = res.IP
+				hop.Reached = true
+				hop.RTTs = append(hop.RTTs, res.RTT)
+			default:
+				hop.Lost++
+			}
+		}
+		rep.Hops = append(rep.Hops, hop)
+		rep.Reached = hop.Reached
+		writeTraceHop(w, hop)
+	}
+	return rep
+}
+
+// writeTraceHop writes a hop as traceroute(8) does, a star for each lost probe
+func writeTraceHop(w io.Writer, hop traceHop) {
+	addr := hop.Addr
+	if addr == "" {
+		addr = "*"
+	}
+	line := fmt.Sprintf("%2d  %s", hop.TTL, addr)
+	for _, rtt := range hop.RTTs {
+		line += fmt.Sprintf("  %.3f ms", rtt*1000)
+	}
+	lost := hop.Lost
+	if hop.Addr == "" {
+		// the address already stands for the first
+		lost--
+	}
+	line += strings.Repeat("  *", lost)
+	fmt.Fprintln(w, line)
+}
@@ -0,0 +1,56 @@
+package main
+
+type (
+	// targetLoss is the loss of one target and the weight it carries in a healthReport
+	targetLoss struct {
+		Target    string  `json:"target"`
+		IP        string  `json:"ip,omitempty"`
+		Source    string  `json:"source,omitempty"`
+		Weight    float64 `json:"weight"`
+		Sent      int     `json:"sent"`
+		Succeeded int     `json:"succeeded"`
+		Loss      float64 `json:"loss"` // Loss is the percentage of probes that did not succeed
+	}
+
+	// healthReport is the loss across all targets, each counted by its weight
+	healthReport struct {
+		Loss         float64      `json:"loss"`          // Loss is the unweighted percentage of failed probes over all targets
+		WeightedLoss float64      `json:"weighted_loss"` // WeightedLoss is the average loss of the targets, weighted by their weight
+		MaxLoss      float64      `json:"max_loss"`      // MaxLoss is the highest WeightedLoss considered healthy
+		Healthy      bool         `json:"healthy"`
+		Targets      []targetLoss `json:"targets"`
+	}
+)
+
+// newHealthReport weighs the loss of every run. Runs that could not start
+// count as a total loss, and targets without a weight count once.
+func newHealthReport(runs []targetResult, maxLoss float64) healthReport {
+	rep := healthReport{MaxLoss: maxLoss}
+	var sent, succeeded int
+	var weighted, weights float64
+	for _, tr := range runs {
+		tl := targetLoss{Target: tr.Target, IP: tr.IP, Source: tr.Source, Weight: tr.weight, Sent: tr.sent, Succeeded: tr.succeeded}
+		if tl.Weight == 0 {
+			tl.Weight = 1
+		}
+		tl.Loss = 100
+		if tl.Sent > 0 {
+			tl.Loss = 100 * float64(tl.Sent-tl.Succeeded) / float64(tl.Sent)
+		}
+		sent += tl.Sent
+		succeeded += tl.Succeeded
+		weighted += tl.Weight * tl.Loss
+		weights += tl.Weight
+		rep.Targets = append(rep.Targets, tl)
+	}
+	rep.Loss = 100
+	if sent > 0 {
+		rep.Loss = 100 * float64(sent-succeeded) / float64(sent)
+	}
+	rep.WeightedLoss = 100
+	if weights > 0 {
+		rep.WeightedLoss = weighted / weights
+	}
+	rep.Healthy = len(runs) > 0 && rep.WeightedLoss <= maxLoss
+	return rep
+}